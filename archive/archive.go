@@ -0,0 +1,578 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bestrui/wechatpush/logging"
+)
+
+var logger = logging.For("archive")
+
+// 归档文件名, 每行一条 JSON 格式的 Record
+const archiveFileName = "messages.jsonl"
+
+// Record 归档的一条消息
+type Record struct {
+	MsgId          string          `json:"msgId"`
+	NewMsgId       int64           `json:"newMsgId,omitempty"`
+	ConversationID string          `json:"conversationId"` // 会话的 UserName, 每次登录都会变化
+	Conversation   string          `json:"conversation"`   // 会话名称, 群名或好友名
+	IsGroup        bool            `json:"isGroup"`
+	Sender         string          `json:"sender"`
+	Type           int             `json:"type"`
+	Content        string          `json:"content"`
+	CreateTime     time.Time       `json:"createTime"`
+	Media          []string        `json:"media,omitempty"` // 已下载的媒体文件
+	Recalled       bool            `json:"recalled,omitempty"`
+	Notified       bool            `json:"notified,omitempty"` // 是否发送了通知
+	Raw            json.RawMessage `json:"raw,omitempty"`      // openwechat.Message.Raw, 只在磁盘上保存, 通过 Get 和 Raw 读取
+
+	offset int64 // 记录在归档文件中的位置, 用于按需读取 Raw
+	length int
+}
+
+// Query 检索条件, 零值字段表示不限制
+type Query struct {
+	Text         string
+	Conversation string
+	Sender       string
+	Since        time.Time
+	Until        time.Time
+	Limit        int
+}
+
+// Conversation 会话概要
+type Conversation struct {
+	Name     string    `json:"name"`
+	IsGroup  bool      `json:"isGroup"`
+	Count    int       `json:"count"`
+	LastTime time.Time `json:"lastTime"`
+}
+
+// Store 本地消息归档
+// 消息以追加的方式写入 messages.jsonl, 启动时读入内存并建立倒排索引
+// 原始消息(Raw)体积较大, 内存中只保存它在文件中的位置, 需要时再从文件读取
+// 修改消息时追加一条新的记录, Sweep 时删除过期的消息并重写文件
+type Store struct {
+	mu      sync.RWMutex
+	path    string
+	file    *os.File
+	size    int64 // 文件的长度, 即下一条记录的位置
+	records []*Record
+	byID    map[string]int
+	index   map[string][]int
+	lines   int // 文件中的记录数, 包括已经被覆盖的记录
+
+	maxAge     time.Duration
+	maxRecords int
+}
+
+// Open 打开(或创建)指定目录下的归档
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	filename := filepath.Join(dir, archiveFileName)
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		path:  filename,
+		file:  file,
+		byID:  make(map[string]int),
+		index: make(map[string][]int),
+	}
+	if err = s.load(); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("load archive %s: %w", filename, err)
+	}
+	return s, nil
+}
+
+// load 重放归档文件, 同一个 MsgId 后出现的记录覆盖之前的记录
+func (s *Store) load() error {
+	reader := bufio.NewReaderSize(s.file, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		offset := s.size
+		s.size += int64(len(line))
+		if len(line) > 0 && line[0] != '\n' {
+			var record Record
+			// 最后一行可能因为进程退出而写了一半, 跳过即可
+			if json.Unmarshal(line, &record) == nil {
+				record.offset, record.length = offset, len(line)
+				s.lines++
+				s.put(&record)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// put 将记录放入内存并更新索引, 调用方需持有写锁
+// 内存中不保存 Raw
+func (s *Store) put(record *Record) {
+	record.Raw = nil
+	if pos, exist := s.byID[record.MsgId]; exist && record.MsgId != "" {
+		s.unindexRecord(pos, s.records[pos])
+		s.records[pos] = record
+		s.indexRecord(pos, record)
+		return
+	}
+	pos := len(s.records)
+	s.records = append(s.records, record)
+	if record.MsgId != "" {
+		s.byID[record.MsgId] = pos
+	}
+	s.indexRecord(pos, record)
+}
+
+func (s *Store) indexRecord(pos int, record *Record) {
+	for _, token := range tokenize(record.Content, true) {
+		postings := s.index[token]
+		if n := len(postings); n > 0 && postings[n-1] >= pos {
+			// 记录被更新时可能已经索引过了
+			if containsInt(postings, pos) {
+				continue
+			}
+			postings = append(postings, pos)
+			sort.Ints(postings)
+		} else {
+			postings = append(postings, pos)
+		}
+		s.index[token] = postings
+	}
+}
+
+// unindexRecord 删除记录旧内容的索引, 避免修改后仍然能搜到旧的内容
+func (s *Store) unindexRecord(pos int, record *Record) {
+	for _, token := range tokenize(record.Content, true) {
+		postings := s.index[token]
+		i := sort.SearchInts(postings, pos)
+		if i == len(postings) || postings[i] != pos {
+			continue
+		}
+		if len(postings) == 1 {
+			delete(s.index, token)
+			continue
+		}
+		s.index[token] = append(postings[:i:i], postings[i+1:]...)
+	}
+}
+
+// write 将记录追加到文件并记下它的位置, 调用方需持有写锁
+func (s *Store) write(record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err = s.file.Write(data); err != nil {
+		return err
+	}
+	record.offset, record.length = s.size, len(data)
+	s.size += int64(len(data))
+	s.lines++
+	return nil
+}
+
+// readRaw 从文件中读取记录的原始消息, 调用方需持有锁
+func (s *Store) readRaw(record *Record) (json.RawMessage, error) {
+	if record.length == 0 {
+		return nil, nil
+	}
+	line := make([]byte, record.length)
+	if _, err := s.file.ReadAt(line, record.offset); err != nil {
+		return nil, err
+	}
+	var stored struct {
+		MsgId string          `json:"msgId"`
+		Raw   json.RawMessage `json:"raw"`
+	}
+	if err := json.Unmarshal(line, &stored); err != nil {
+		return nil, err
+	}
+	if stored.MsgId != record.MsgId {
+		return nil, fmt.Errorf("archive record %s moved", record.MsgId)
+	}
+	return stored.Raw, nil
+}
+
+// Add 归档一条消息
+func (s *Store) Add(record *Record) error {
+	if record == nil {
+		return errors.New("nil record")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := *record
+	if err := s.write(&r); err != nil {
+		return err
+	}
+	s.put(&r)
+	return nil
+}
+
+// Update 修改已归档的消息, 比如补充下载好的媒体或标记为已撤回
+func (s *Store) Update(msgId string, update func(record *Record)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pos, exist := s.byID[msgId]
+	if !exist {
+		return fmt.Errorf("message %s not found", msgId)
+	}
+	record := *s.records[pos]
+	raw, err := s.readRaw(&record)
+	if err != nil {
+		return err
+	}
+	record.Raw = raw
+	update(&record)
+	if err = s.write(&record); err != nil {
+		return err
+	}
+	s.put(&record)
+	return nil
+}
+
+// Get 根据 MsgId 获取归档的消息, 包括原始消息
+func (s *Store) Get(msgId string) (*Record, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pos, exist := s.byID[msgId]
+	if !exist {
+		return nil, false
+	}
+	record := *s.records[pos]
+	raw, err := s.readRaw(&record)
+	if err != nil {
+		logger.Warn("读取原始消息失败", "msg_id", msgId, "error", err)
+	}
+	record.Raw = raw
+	return &record, true
+}
+
+// Raw 从文件中读取消息的原始内容, 没有归档时返回 false
+func (s *Store) Raw(msgId string) (json.RawMessage, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pos, exist := s.byID[msgId]
+	if !exist {
+		return nil, false
+	}
+	raw, err := s.readRaw(s.records[pos])
+	if err != nil {
+		logger.Warn("读取原始消息失败", "msg_id", msgId, "error", err)
+		return nil, false
+	}
+	return raw, true
+}
+
+// Search 检索消息, 结果按时间倒序排列, 不包括原始消息
+func (s *Store) Search(q Query) []*Record {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var candidates []int
+	text := strings.ToLower(strings.TrimSpace(q.Text))
+	if text != "" {
+		candidates = s.lookup(tokenize(text, false))
+	} else {
+		candidates = make([]int, len(s.records))
+		for i := range candidates {
+			candidates[i] = i
+		}
+	}
+
+	var results []*Record
+	for i := len(candidates) - 1; i >= 0; i-- {
+		record := s.records[candidates[i]]
+		if !q.match(record, text) {
+			continue
+		}
+		r := *record
+		results = append(results, &r)
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].CreateTime.After(results[j].CreateTime) })
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	return results
+}
+
+// lookup 对所有 token 的倒排列表取交集
+func (s *Store) lookup(tokens []string) []int {
+	if len(tokens) == 0 {
+		return nil
+	}
+	result := s.index[tokens[0]]
+	for _, token := range tokens[1:] {
+		result = intersect(result, s.index[token])
+		if len(result) == 0 {
+			break
+		}
+	}
+	return result
+}
+
+func (q Query) match(record *Record, text string) bool {
+	if q.Conversation != "" && record.Conversation != q.Conversation {
+		return false
+	}
+	if q.Sender != "" && !strings.Contains(record.Sender, q.Sender) {
+		return false
+	}
+	if !q.Since.IsZero() && record.CreateTime.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !record.CreateTime.Before(q.Until) {
+		return false
+	}
+	// 倒排索引只能保证所有的词都出现过, 这里再确认一下每个关键词都是连续出现的
+	content := strings.ToLower(record.Content)
+	for _, keyword := range strings.Fields(text) {
+		if !strings.Contains(content, keyword) {
+			return false
+		}
+	}
+	return true
+}
+
+// Timeline 获取某个会话在 MsgId 为 before 的消息之前的消息, 按时间正序排列
+// before 为空时从最新的消息开始, 找不到 before 时返回空
+// 同一秒内的消息按归档的先后排序, 因此翻页时不会漏掉和上一页第一条消息同一秒的消息
+func (s *Store) Timeline(conversation, before string, limit int) []*Record {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	end := len(s.records)
+	var cursor *Record
+	if before != "" {
+		pos, exist := s.byID[before]
+		if !exist {
+			return nil
+		}
+		end, cursor = pos, s.records[pos]
+	}
+	earlier := func(i, j int) bool {
+		a, b := s.records[i], s.records[j]
+		if a.CreateTime.Equal(b.CreateTime) {
+			return i < j
+		}
+		return a.CreateTime.Before(b.CreateTime)
+	}
+	var positions []int
+	for pos, record := range s.records {
+		if record.Conversation != conversation || pos == end {
+			continue
+		}
+		if cursor != nil && !earlier(pos, end) {
+			continue
+		}
+		positions = append(positions, pos)
+	}
+	sort.Slice(positions, func(i, j int) bool { return earlier(positions[i], positions[j]) })
+	if limit > 0 && len(positions) > limit {
+		positions = positions[len(positions)-limit:]
+	}
+	results := make([]*Record, len(positions))
+	for i, pos := range positions {
+		r := *s.records[pos]
+		results[i] = &r
+	}
+	return results
+}
+
+// Conversations 获取所有的会话, 按最后一条消息的时间倒序排列
+func (s *Store) Conversations() []Conversation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var (
+		positions     = make(map[string]int)
+		conversations []Conversation
+	)
+	for _, record := range s.records {
+		pos, exist := positions[record.Conversation]
+		if !exist {
+			pos = len(conversations)
+			positions[record.Conversation] = pos
+			conversations = append(conversations, Conversation{Name: record.Conversation, IsGroup: record.IsGroup})
+		}
+		c := &conversations[pos]
+		c.Count++
+		if record.CreateTime.After(c.LastTime) {
+			c.LastTime = record.CreateTime
+		}
+	}
+	sort.Slice(conversations, func(i, j int) bool { return conversations[i].LastTime.After(conversations[j].LastTime) })
+	return conversations
+}
+
+// Len 返回归档的消息数量
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.records)
+}
+
+// SetRetention 设置消息的保留时间和最多保留的数量, 小于等于0表示不限制, 在 Sweep 时生效
+func (s *Store) SetRetention(maxAge time.Duration, maxRecords int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxAge, s.maxRecords = maxAge, maxRecords
+}
+
+// Sweep 删除超过保留时间或者数量的消息, 返回删除的消息数量
+// 有消息被删除或者文件中被覆盖的记录过多时重写归档文件
+func (s *Store) Sweep(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keep := make([]*Record, 0, len(s.records))
+	for _, record := range s.records {
+		if s.maxAge > 0 && record.CreateTime.Before(now.Add(-s.maxAge)) {
+			continue
+		}
+		keep = append(keep, record)
+	}
+	if s.maxRecords > 0 && len(keep) > s.maxRecords {
+		sort.SliceStable(keep, func(i, j int) bool { return keep[i].CreateTime.Before(keep[j].CreateTime) })
+		keep = keep[len(keep)-s.maxRecords:]
+	}
+	removed := len(s.records) - len(keep)
+	if removed == 0 && s.lines <= 2*len(s.records)+compactSlack {
+		return 0, nil
+	}
+	if err := s.rewrite(keep); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// 被覆盖的记录超过有效记录数加上这个数量时重写文件
+const compactSlack = 1000
+
+// rewrite 只保留 records 重写归档文件并重建索引, 调用方需持有写锁
+func (s *Store) rewrite(records []*Record) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), archiveFileName+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	writer := bufio.NewWriter(tmp)
+	// 新文件中每条记录的位置, 重写成功后再更新
+	offsets := make([]int64, len(records))
+	lengths := make([]int, len(records))
+	var size int64
+	for i, record := range records {
+		r := *record
+		if r.Raw, err = s.readRaw(record); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		data, err := json.Marshal(&r)
+		if err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		data = append(data, '\n')
+		if _, err = writer.Write(data); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		offsets[i], lengths[i] = size, len(data)
+		size += int64(len(data))
+	}
+	if err = writer.Flush(); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_ = s.file.Close()
+	s.file = file
+	s.size = size
+	s.records = nil
+	s.byID = make(map[string]int)
+	s.index = make(map[string][]int)
+	for i, record := range records {
+		record.offset, record.length = offsets[i], lengths[i]
+		s.put(record)
+	}
+	s.lines = len(records)
+	return nil
+}
+
+// RunSweeper 定期清理过期的消息, 直到 ctx 结束
+func (s *Store) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			removed, err := s.Sweep(now)
+			if err != nil {
+				logger.Error("清理归档消息失败", "error", err)
+			} else if removed > 0 {
+				logger.Info("已清理过期的归档消息", "removed", removed)
+			}
+		}
+	}
+}
+
+// Close 关闭归档文件
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+func containsInt(list []int, v int) bool {
+	i := sort.SearchInts(list, v)
+	return i < len(list) && list[i] == v
+}
+
+// intersect 求两个有序列表的交集
+func intersect(a, b []int) []int {
+	var result []int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			result = append(result, a[i])
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return result
+}
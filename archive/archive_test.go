@@ -0,0 +1,267 @@
+package archive
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreSearch(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	records := []*Record{
+		{MsgId: "1", Conversation: "运维群", IsGroup: true, Sender: "张三", Content: "数据库出现故障了", CreateTime: now.Add(-3 * time.Hour)},
+		{MsgId: "2", Conversation: "运维群", IsGroup: true, Sender: "李四", Content: "Outage resolved", CreateTime: now.Add(-2 * time.Hour)},
+		{MsgId: "3", Conversation: "王五", Sender: "王五", Content: "晚上一起吃饭", CreateTime: now.Add(-time.Hour)},
+	}
+	for _, record := range records {
+		if err = store.Add(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"chinese bigram", Query{Text: "故障"}, []string{"1"}},
+		{"single han", Query{Text: "饭"}, []string{"3"}},
+		{"case insensitive", Query{Text: "outage"}, []string{"2"}},
+		{"not contiguous", Query{Text: "故库"}, nil},
+		{"by group", Query{Conversation: "运维群"}, []string{"2", "1"}},
+		{"by sender", Query{Sender: "李"}, []string{"2"}},
+		{"since", Query{Since: now.Add(-90 * time.Minute)}, []string{"3"}},
+		{"limit", Query{Limit: 1}, []string{"3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := store.Search(tt.query)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d records, want %d", len(got), len(tt.want))
+			}
+			for i, record := range got {
+				if record.MsgId != tt.want[i] {
+					t.Errorf("result[%d] = %s, want %s", i, record.MsgId, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Add(&Record{MsgId: "1", Conversation: "运维群", Content: "服务重启", CreateTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Update("1", func(record *Record) { record.Recalled = true }); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if store.Len() != 1 {
+		t.Fatalf("got %d records after reload, want 1", store.Len())
+	}
+	record, ok := store.Get("1")
+	if !ok || !record.Recalled {
+		t.Errorf("record not reloaded with update: %+v", record)
+	}
+	if got := store.Search(Query{Text: "重启"}); len(got) != 1 {
+		t.Errorf("search after reload got %d records, want 1", len(got))
+	}
+}
+
+func TestStoreUpdateReindex(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err = store.Add(&Record{MsgId: "1", Content: "语音消息", CreateTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Update("1", func(record *Record) { record.Content = "[转写] 明天开会" }); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.Search(Query{Text: "语音"}); len(got) != 0 {
+		t.Errorf("old content still indexed: %d records", len(got))
+	}
+	if got := store.Search(Query{Text: "开会"}); len(got) != 1 {
+		t.Errorf("new content not indexed: %d records", len(got))
+	}
+}
+
+func TestStoreSweep(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	for i, id := range []string{"a", "b", "c", "d"} {
+		record := &Record{MsgId: id, Content: "消息" + id, CreateTime: now.Add(-time.Duration(4-i) * 24 * time.Hour)}
+		if err = store.Add(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = store.Update("d", func(record *Record) { record.Recalled = true }); err != nil {
+		t.Fatal(err)
+	}
+	// a 超过保留时间, 剩下的 b、c、d 中只保留最新的两条
+	store.SetRetention(3*24*time.Hour+time.Minute, 2)
+	removed, err := store.Sweep(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 || store.Len() != 2 {
+		t.Fatalf("removed %d, left %d", removed, store.Len())
+	}
+	if got := store.Search(Query{Text: "消息"}); len(got) != 2 || got[0].MsgId != "d" || got[1].MsgId != "c" {
+		t.Errorf("search after sweep: %v", got)
+	}
+	if err = store.Add(&Record{MsgId: "e", Content: "消息e", CreateTime: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 文件被重写, 只剩下保留的记录和之后追加的记录
+	file, err := os.Open(filepath.Join(dir, archiveFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var lines int
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		lines++
+	}
+	if lines != 3 {
+		t.Errorf("archive file has %d lines, want 3", lines)
+	}
+	store, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if record, ok := store.Get("d"); !ok || !record.Recalled {
+		t.Errorf("record d after reload: %+v", record)
+	}
+	if _, ok := store.Get("a"); ok {
+		t.Error("expired record reloaded")
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	base := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	for i, content := range []string{"a", "b", "c", "d"} {
+		record := &Record{MsgId: content, Conversation: "group", Content: content, CreateTime: base.Add(time.Duration(i) * time.Minute)}
+		if err = store.Add(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := store.Timeline("group", "d", 2)
+	if len(got) != 2 || got[0].MsgId != "b" || got[1].MsgId != "c" {
+		t.Errorf("unexpected timeline: %v", got)
+	}
+}
+
+// 同一秒内的消息翻页时不能漏掉
+func TestTimelineSameSecond(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	now := time.Unix(time.Now().Unix(), 0)
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		if err = store.Add(&Record{MsgId: id, Conversation: "group", Content: id, CreateTime: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var ids []string
+	before := ""
+	for {
+		page := store.Timeline("group", before, 2)
+		if len(page) == 0 {
+			break
+		}
+		for i := len(page) - 1; i >= 0; i-- {
+			ids = append(ids, page[i].MsgId)
+		}
+		before = page[0].MsgId
+	}
+	if strings.Join(ids, "") != "edcba" {
+		t.Errorf("got %v, want e d c b a", ids)
+	}
+}
+
+// 原始消息只保存在文件中, 修改、重启和重写文件后仍然可以读取
+func TestStoreRawOnDisk(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := json.RawMessage(`{"MsgId":"1","Content":"hello"}`)
+	if err = store.Add(&Record{MsgId: "1", Content: "hello", CreateTime: time.Now(), Raw: raw}); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Add(&Record{MsgId: "2", Content: "world", CreateTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if results := store.Search(Query{Text: "hello"}); len(results) != 1 || results[0].Raw != nil {
+		t.Errorf("search should not return raw: %+v", results)
+	}
+	check := func(stage string) {
+		t.Helper()
+		record, ok := store.Get("1")
+		if !ok || string(record.Raw) != string(raw) {
+			t.Errorf("%s: got %+v", stage, record)
+		}
+	}
+	check("add")
+	if err = store.Update("1", func(record *Record) { record.Recalled = true }); err != nil {
+		t.Fatal(err)
+	}
+	check("update")
+	if err = store.rewrite(store.records); err != nil {
+		t.Fatal(err)
+	}
+	check("rewrite")
+	if err = store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if store, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	check("reload")
+	if raw, ok := store.Raw("2"); !ok || raw != nil {
+		t.Errorf("record without raw: %s %v", raw, ok)
+	}
+}
@@ -0,0 +1,67 @@
+package archive
+
+import (
+	"strings"
+	"unicode"
+)
+
+// tokenize 将文本切分为索引用的词
+// 英文和数字按单词切分, 中日韩文字按二元组切分
+// 建立索引时额外保留单字, 这样单个汉字的检索也能命中
+func tokenize(text string, indexing bool) []string {
+	var (
+		tokens []string
+		word   []rune
+		han    []rune
+		seen   = make(map[string]struct{})
+	)
+	add := func(token string) {
+		if _, exist := seen[token]; exist {
+			return
+		}
+		seen[token] = struct{}{}
+		tokens = append(tokens, token)
+	}
+	flushWord := func() {
+		if len(word) > 0 {
+			add(string(word))
+			word = word[:0]
+		}
+	}
+	flushHan := func() {
+		switch {
+		case len(han) == 1:
+			add(string(han))
+		case len(han) > 1:
+			for i := 0; i < len(han)-1; i++ {
+				add(string(han[i : i+2]))
+			}
+			if indexing {
+				for _, r := range han {
+					add(string(r))
+				}
+			}
+		}
+		han = han[:0]
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case isCJK(r):
+			flushWord()
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushHan()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushHan()
+		}
+	}
+	flushWord()
+	flushHan()
+	return tokens
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bestrui/wechatpush/logging"
+	"bestrui/wechatpush/mail"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bestrui/wechatpush/openwechat"
+)
+
+var commandLog = logging.For("commands")
+
+// 通过命令屏蔽的群, 按群的 UserName 索引, 群名可能重复
+var groupMutes = newMuteList("")
+
+// 屏蔽的群, Until 为零值表示一直屏蔽
+type muteEntry struct {
+	UserName string    `json:"userName"`
+	Name     string    `json:"name"`
+	Until    time.Time `json:"until"`
+	// 重新登录后群的 UserName 会变化, 没有对应到本次登录的群之前按群名匹配
+	bound bool
+}
+
+type muteList struct {
+	mu      sync.Mutex
+	path    string // 为空时不保存
+	entries map[string]*muteEntry
+}
+
+func newMuteList(path string) *muteList {
+	return &muteList{path: path, entries: make(map[string]*muteEntry)}
+}
+
+// 读取保存的屏蔽列表, 文件不存在时返回空列表
+func openMuteList(path string) (*muteList, error) {
+	l := newMuteList(path)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*muteEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("解析屏蔽列表失败: %w", err)
+	}
+	for _, entry := range entries {
+		l.entries[entry.UserName] = entry
+	}
+	return l, nil
+}
+
+// 屏蔽群消息, until 为零值时一直屏蔽
+func (l *muteList) Mute(userName, name string, until time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[userName] = &muteEntry{UserName: userName, Name: name, Until: until, bound: true}
+	return l.save()
+}
+
+// 取消屏蔽, 没有屏蔽时返回 false
+// userName 为空时取消所有名为 name 的群, 用于找不到群的情况
+func (l *muteList) Unmute(userName, name string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.lookup(userName, name)
+	if ok {
+		delete(l.entries, entry.UserName)
+	}
+	if userName == "" {
+		for key, entry := range l.entries {
+			if entry.Name == name {
+				delete(l.entries, key)
+				ok = true
+			}
+		}
+	}
+	if !ok {
+		return false, nil
+	}
+	return true, l.save()
+}
+
+// 判断群是否被屏蔽, 过期的屏蔽会被清除
+func (l *muteList) Muted(userName, name string, now time.Time) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.lookup(userName, name)
+	if !ok {
+		return time.Time{}, false
+	}
+	if !entry.Until.IsZero() && !now.Before(entry.Until) {
+		delete(l.entries, entry.UserName)
+		l.saveOrLog()
+		return time.Time{}, false
+	}
+	if !entry.bound && userName != "" {
+		// 对应到本次登录的群
+		delete(l.entries, entry.UserName)
+		entry.UserName = userName
+		entry.bound = true
+		l.entries[userName] = entry
+		l.saveOrLog()
+	}
+	return entry.Until, true
+}
+
+// 查找屏蔽的群, 先按 UserName 查找, 再按群名查找还没有对应到本次登录的群
+func (l *muteList) lookup(userName, name string) (*muteEntry, bool) {
+	if entry, ok := l.entries[userName]; ok && userName != "" {
+		return entry, true
+	}
+	for _, entry := range l.entries {
+		if !entry.bound && entry.Name == name {
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// 重新登录后群的 UserName 会变化, 所有屏蔽的群重新按群名对应
+func (l *muteList) Unbind() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, entry := range l.entries {
+		entry.bound = false
+	}
+}
+
+// 所有正在屏蔽的群, 按群名排序
+func (l *muteList) List(now time.Time) []muteEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := make([]muteEntry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		if entry.Until.IsZero() || now.Before(entry.Until) {
+			list = append(list, *entry)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (l *muteList) save() error {
+	if l.path == "" {
+		return nil
+	}
+	entries := make([]*muteEntry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), ".mutes-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), l.path)
+}
+
+func (l *muteList) saveOrLog() {
+	if err := l.save(); err != nil {
+		commandLog.Error("保存屏蔽列表失败", "error", err)
+	}
+}
+
+// 屏蔽列表文件, 默认保存在归档目录旁边
+func mutesFileFromEnv() string {
+	if path := os.Getenv("MUTES_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join(filepath.Dir(archiveDirFromEnv()), "mutes.json")
+}
+
+// 读取上次保存的屏蔽列表, 读取失败时不覆盖原文件, 屏蔽的群只在本次运行中有效
+func initMutes() {
+	mutes, err := openMuteList(mutesFileFromEnv())
+	if err != nil {
+		commandLog.Error("读取屏蔽列表失败", "error", err)
+		return
+	}
+	groupMutes = mutes
+	if n := len(mutes.entries); n > 0 {
+		commandLog.Info("已加载屏蔽的群", "groups", n)
+	}
+}
+
+// 创建聊天命令路由, COMMAND_ALLOW 为允许执行命令的好友备注名或昵称, 逗号分隔, 自己总是可以执行
+// 设置了备注名的好友只按备注名匹配, 没有备注名时才按昵称匹配
+func newCommandRouter() *openwechat.CommandRouter {
+	router := openwechat.NewCommandRouter("/")
+	if value := os.Getenv("COMMAND_ALLOW"); value != "" {
+		var names []string
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		router.Allow = openwechat.AllowFriends(names...)
+	}
+	router.Handle("mute", "[群名] [时长] 屏蔽群消息, 如 /mute 2h, 不写时长则一直屏蔽", commandMute)
+	router.Handle("unmute", "[群名] 取消屏蔽群消息", commandUnmute)
+	router.Handle("status", "显示转发状态", commandStatus)
+	router.Handle("testmail", "发送一封测试邮件", commandTestMail)
+	return router
+}
+
+// 解析命令中的群名和时长, 群名为空表示命令所在的群
+func parseMuteArgs(cmd *openwechat.Command) (string, time.Duration, error) {
+	args := cmd.Args
+	var duration time.Duration
+	if len(args) > 0 {
+		if d, err := time.ParseDuration(args[len(args)-1]); err == nil {
+			if d <= 0 {
+				return "", 0, errors.New("时长必须大于0")
+			}
+			duration = d
+			args = args[:len(args)-1]
+		}
+	}
+	return strings.Join(args, " "), duration, nil
+}
+
+// 获取命令要操作的群, 没有写群名时使用命令所在的群
+func commandTarget(msg *openwechat.Message, name string) (userName, groupName string, err error) {
+	if name == "" {
+		userName, name, ok := commandGroup(msg)
+		if !ok {
+			return "", "", errors.New("请指定群名")
+		}
+		return userName, name, nil
+	}
+	var found []string
+	for _, group := range contactCache.Groups() {
+		if group.NickName == name {
+			found = append(found, group.UserName)
+		}
+	}
+	if len(found) == 0 {
+		if members, err := msg.Owner().Members(); err == nil {
+			for _, member := range members {
+				if member.IsGroup() && openwechat.FormatEmoji(member.NickName) == name {
+					found = append(found, member.UserName)
+				}
+			}
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", name, fmt.Errorf("找不到群 %s", name)
+	case 1:
+		return found[0], name, nil
+	default:
+		return "", name, fmt.Errorf("有 %d 个名为 %s 的群, 请在群里发送命令", len(found), name)
+	}
+}
+
+// 获取命令所在群的 UserName 和群名, 不在群里时返回 false
+func commandGroup(msg *openwechat.Message) (userName, name string, ok bool) {
+	if !msg.IsComeFromGroup() {
+		return "", "", false
+	}
+	userName = msg.FromUserName
+	if msg.IsSendBySelf() {
+		userName = msg.ToUserName
+	}
+	if contact, ok := contactCache.Get(userName); ok {
+		return userName, contact.NickName, true
+	}
+	members, err := msg.Owner().Members()
+	if err != nil {
+		return "", "", false
+	}
+	group, ok := members.GetByUserName(userName)
+	if !ok {
+		return "", "", false
+	}
+	return userName, openwechat.FormatEmoji(group.NickName), true
+}
+
+func commandMute(cmd *openwechat.Command) (string, error) {
+	name, duration, err := parseMuteArgs(cmd)
+	if err != nil {
+		return "", err
+	}
+	userName, name, err := commandTarget(cmd.Message, name)
+	if err != nil {
+		return "", err
+	}
+	var until time.Time
+	if duration > 0 {
+		until = time.Now().Add(duration)
+	}
+	if err = groupMutes.Mute(userName, name, until); err != nil {
+		commandLog.Error("保存屏蔽列表失败", "error", err)
+	}
+	if until.IsZero() {
+		commandLog.Info("已屏蔽群", "group", name)
+		return fmt.Sprintf("已屏蔽群 %s, 发送 /unmute 取消", name), nil
+	}
+	commandLog.Info("已屏蔽群", "group", name, "until", until)
+	return fmt.Sprintf("已屏蔽群 %s 直到 %s", name, until.Format("2006-01-02 15:04")), nil
+}
+
+func commandUnmute(cmd *openwechat.Command) (string, error) {
+	name, _, err := parseMuteArgs(cmd)
+	if err != nil {
+		return "", err
+	}
+	userName, name, err := commandTarget(cmd.Message, name)
+	if err != nil && name == "" {
+		return "", err
+	}
+	// 找不到群时按群名取消, 群可能已经被删除
+	ok, err := groupMutes.Unmute(userName, name)
+	if err != nil {
+		commandLog.Error("保存屏蔽列表失败", "error", err)
+	}
+	if !ok {
+		return fmt.Sprintf("群 %s 没有被屏蔽", name), nil
+	}
+	commandLog.Info("已取消屏蔽群", "group", name)
+	return fmt.Sprintf("已取消屏蔽群 %s", name), nil
+}
+
+func commandStatus(cmd *openwechat.Command) (string, error) {
+	now := time.Now()
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "转发通讯录中 %d 个群的所有消息, 其他群只转发@我和@所有人的消息", len(contactCache.Groups()))
+	if stats, ok := cmd.Bot().WorkerPoolStats(); ok {
+		fmt.Fprintf(&sb, "\n待处理消息: %d 条", stats.Pending)
+	}
+	if muted := groupMutes.List(now); len(muted) > 0 {
+		sb.WriteString("\n已屏蔽的群:")
+		for _, entry := range muted {
+			sb.WriteString("\n  " + entry.Name + muteUntilText(entry.Until))
+		}
+	}
+	if userName, name, ok := commandGroup(cmd.Message); ok {
+		sb.WriteString("\n当前群: " + groupForwardState(userName, name, now))
+	}
+	return sb.String(), nil
+}
+
+// 当前群的转发状态
+func groupForwardState(userName, name string, now time.Time) string {
+	if until, ok := groupMutes.Muted(userName, name, now); ok {
+		return "已屏蔽" + muteUntilText(until)
+	}
+	if _, ok := contactCache.Get(userName); ok {
+		return "转发所有消息"
+	}
+	return "只转发@我和@所有人的消息"
+}
+
+func muteUntilText(until time.Time) string {
+	if until.IsZero() {
+		return ""
+	}
+	return " (直到 " + until.Format("2006-01-02 15:04") + ")"
+}
+
+func commandTestMail(cmd *openwechat.Command) (string, error) {
+	content := "这是一封测试邮件, 发送于 " + time.Now().Format("2006-01-02 15:04:05")
+	if err := timedSend(func() error { return mail.SendEmail("测试", content) }); err != nil {
+		return "", fmt.Errorf("发送测试邮件失败: %w", err)
+	}
+	return "测试邮件已发送", nil
+}
+
+// 判断群消息是否被命令屏蔽, name 用于对应重新登录前屏蔽的群
+func isGroupMuted(userName, name string) bool {
+	_, ok := groupMutes.Muted(userName, openwechat.FormatEmoji(name), time.Now())
+	return ok
+}
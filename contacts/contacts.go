@@ -0,0 +1,139 @@
+package contacts
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bestrui/wechatpush/openwechat"
+)
+
+// Contact 缓存的联系人信息
+type Contact struct {
+	UserName    string `json:"userName"`
+	NickName    string `json:"nickName"`
+	RemarkName  string `json:"remarkName,omitempty"`
+	IsGroup     bool   `json:"isGroup"`
+	MemberCount int    `json:"memberCount,omitempty"`
+}
+
+// Name 显示名称, 优先使用备注名
+func (c Contact) Name() string {
+	if c.RemarkName != "" {
+		return c.RemarkName
+	}
+	return c.NickName
+}
+
+// FromUser 将 openwechat.User 转换为 Contact
+func FromUser(user *openwechat.User) Contact {
+	return Contact{
+		UserName:    user.UserName,
+		NickName:    openwechat.FormatEmoji(user.NickName),
+		RemarkName:  openwechat.FormatEmoji(user.RemarkName),
+		IsGroup:     strings.HasPrefix(user.UserName, "@@"),
+		MemberCount: user.MemberCount,
+	}
+}
+
+// Cache 按 UserName 索引的联系人缓存, 可以并发访问
+// 群名可能重复, 因此不能用 NickName 作为索引
+type Cache struct {
+	mu         sync.RWMutex
+	byUserName map[string]Contact
+	updatedAt  time.Time
+}
+
+// NewCache 创建空的联系人缓存
+func NewCache() *Cache {
+	return &Cache{byUserName: make(map[string]Contact)}
+}
+
+// Replace 使用完整的联系人列表替换缓存
+func (c *Cache) Replace(contacts []Contact) {
+	byUserName := make(map[string]Contact, len(contacts))
+	for _, contact := range contacts {
+		byUserName[contact.UserName] = contact
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byUserName = byUserName
+	c.updatedAt = time.Now()
+}
+
+// Update 更新已经在缓存中的联系人, 返回更新的数量
+// 变更列表中也会出现没有保存到通讯录的群, 这些群不会被添加
+func (c *Cache) Update(contacts ...Contact) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	updated := 0
+	for _, contact := range contacts {
+		if _, ok := c.byUserName[contact.UserName]; ok {
+			c.byUserName[contact.UserName] = contact
+			updated++
+		}
+	}
+	if updated > 0 {
+		c.updatedAt = time.Now()
+	}
+	return updated
+}
+
+// Put 添加或者更新联系人
+func (c *Cache) Put(contacts ...Contact) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, contact := range contacts {
+		c.byUserName[contact.UserName] = contact
+	}
+	c.updatedAt = time.Now()
+}
+
+// Remove 删除联系人
+func (c *Cache) Remove(userName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.byUserName, userName)
+}
+
+// Get 根据 UserName 获取联系人
+func (c *Cache) Get(userName string) (Contact, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	contact, ok := c.byUserName[userName]
+	return contact, ok
+}
+
+// Groups 返回所有的群, 按名称排序
+func (c *Cache) Groups() []Contact {
+	c.mu.RLock()
+	groups := make([]Contact, 0, len(c.byUserName))
+	for _, contact := range c.byUserName {
+		if contact.IsGroup {
+			groups = append(groups, contact)
+		}
+	}
+	c.mu.RUnlock()
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Name() != groups[j].Name() {
+			return groups[i].Name() < groups[j].Name()
+		}
+		return groups[i].UserName < groups[j].UserName
+	})
+	return groups
+}
+
+// Len 返回缓存的联系人数量
+func (c *Cache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.byUserName)
+}
+
+// UpdatedAt 返回缓存最近一次更新的时间
+func (c *Cache) UpdatedAt() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.updatedAt
+}
@@ -0,0 +1,60 @@
+package contacts
+
+import (
+	"sync"
+	"testing"
+
+	"bestrui/wechatpush/openwechat"
+)
+
+func TestCacheSameNickName(t *testing.T) {
+	cache := NewCache()
+	cache.Replace([]Contact{
+		FromUser(&openwechat.User{UserName: "@@a", NickName: "家长群"}),
+		FromUser(&openwechat.User{UserName: "@@b", NickName: "家长群"}),
+		FromUser(&openwechat.User{UserName: "@friend", NickName: "张三"}),
+	})
+	if groups := cache.Groups(); len(groups) != 2 || groups[0].UserName != "@@a" || groups[1].UserName != "@@b" {
+		t.Fatalf("groups = %+v", groups)
+	}
+
+	// 只更新已经缓存的联系人
+	if n := cache.Update(
+		Contact{UserName: "@@a", NickName: "一年级家长群", IsGroup: true},
+		Contact{UserName: "@@unsaved", NickName: "临时群", IsGroup: true},
+	); n != 1 {
+		t.Errorf("updated = %d", n)
+	}
+	if contact, _ := cache.Get("@@a"); contact.Name() != "一年级家长群" {
+		t.Errorf("contact = %+v", contact)
+	}
+	if _, ok := cache.Get("@@unsaved"); ok {
+		t.Error("unsaved group should not be added")
+	}
+	cache.Put(Contact{UserName: "@@unsaved", NickName: "临时群", IsGroup: true})
+	if _, ok := cache.Get("@@unsaved"); !ok {
+		t.Error("put should add the group")
+	}
+	cache.Remove("@@b")
+	if cache.Len() != 3 {
+		t.Errorf("len = %d", cache.Len())
+	}
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	cache := NewCache()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				cache.Replace([]Contact{{UserName: "@@a", IsGroup: true}})
+				cache.Update(Contact{UserName: "@@a", NickName: "群", IsGroup: true})
+				cache.Get("@@a")
+				cache.Groups()
+			}
+		}()
+	}
+	wg.Wait()
+}
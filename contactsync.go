@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bestrui/wechatpush/contacts"
+	"bestrui/wechatpush/logging"
+
+	"bestrui/wechatpush/openwechat"
+)
+
+var contactLog = logging.For("contacts")
+
+// 通讯录中的联系人和群, 按 UserName 索引
+var contactCache = contacts.NewCache()
+
+// 注册联系人同步的回调, 机器人应用 webwxsync 中的联系人变更后直接更新缓存, 不需要重新拉取通讯录
+func registerContactSync(bot *openwechat.Bot) {
+	bot.ContactChangeHandler = func(change openwechat.ContactChange) {
+		user := change.User
+		switch change.Type {
+		case openwechat.ContactAdded:
+			// 变更列表中也会出现没有保存到通讯录的群, 只缓存通讯录中的群
+			if user.IsGroup() && user.ContactFlag&contactFlagContact != 0 {
+				contactLog.Info("新增群", "name", user.NickName)
+				contactCache.Put(contacts.FromUser(user))
+			}
+		case openwechat.ContactRenamed:
+			contactLog.Info("联系人改名", "old", change.OldNickName, "new", user.NickName)
+			contactCache.Update(contacts.FromUser(user))
+		case openwechat.ContactModified:
+			contactCache.Update(contacts.FromUser(user))
+		case openwechat.ContactRemoved:
+			contactLog.Info("联系人已删除", "name", user.NickName)
+			contactCache.Remove(user.UserName)
+		case openwechat.GroupMemberJoined, openwechat.GroupMemberLeft:
+			action := "加入"
+			if change.Type == openwechat.GroupMemberLeft {
+				action = "退出"
+			}
+			for _, member := range change.Members {
+				contactLog.Info(action+"了群", "member", member.NickName, "group", user.NickName)
+			}
+			contactCache.Update(contacts.FromUser(user))
+		}
+	}
+}
+
+// ContactFlag 中表示已经保存到通讯录的位
+const contactFlagContact = 1
+
+// 从服务器获取通讯录中的群组列表
+func refreshGroupList(bot *openwechat.Bot) {
+	self, err := bot.GetCurrentUser()
+	if err != nil {
+		return
+	}
+	groups, err := self.Groups(true)
+	if err != nil {
+		contactLog.Error("获取群组列表失败", "error", err)
+		return
+	}
+	list := make([]contacts.Contact, 0, len(groups))
+	for _, group := range groups {
+		list = append(list, contacts.FromUser(group.User))
+	}
+	contactCache.Replace(list)
+	contactLog.Info("已更新群组列表", "groups", len(list))
+}
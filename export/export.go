@@ -0,0 +1,258 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"bestrui/wechatpush/archive"
+
+	"bestrui/wechatpush/openwechat"
+)
+
+// Format 导出格式
+type Format string
+
+const (
+	HTML     Format = "html"
+	Markdown Format = "markdown"
+	JSONL    Format = "jsonl"
+)
+
+// 内嵌到 HTML 中的单张图片的最大大小
+const maxEmbedSize = 10 << 20
+
+// ParseFormat 解析导出格式
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "", "html":
+		return HTML, nil
+	case "md", "markdown":
+		return Markdown, nil
+	case "jsonl":
+		return JSONL, nil
+	}
+	return "", fmt.Errorf("unsupported export format: %s", s)
+}
+
+// ContentType 导出文件的 Content-Type
+func (f Format) ContentType() string {
+	switch f {
+	case Markdown:
+		return "text/markdown; charset=utf-8"
+	case JSONL:
+		return "application/x-ndjson; charset=utf-8"
+	default:
+		return "text/html; charset=utf-8"
+	}
+}
+
+// Ext 导出文件的扩展名
+func (f Format) Ext() string {
+	switch f {
+	case Markdown:
+		return ".md"
+	case JSONL:
+		return ".jsonl"
+	default:
+		return ".html"
+	}
+}
+
+// MediaOpener 根据哈希打开媒体文件
+type MediaOpener func(hash string) (io.ReadCloser, error)
+
+// Options 导出选项
+type Options struct {
+	Title string
+	// Media 用于将图片内嵌到 HTML 中, 为 nil 时只输出媒体的链接
+	Media MediaOpener
+	// MediaURL 媒体文件的链接前缀
+	MediaURL string
+	// Location 导出的时间所使用的时区, 默认为 time.Local
+	Location *time.Location
+	// Raw 读取消息的原始内容, 写入 JSONL, 为 nil 时只导出记录中已有的内容
+	Raw func(msgId string) (json.RawMessage, bool)
+}
+
+// Write 将消息按照指定的格式写入 writer, records 需要按时间正序排列
+func Write(w io.Writer, format Format, records []*archive.Record, opt Options) error {
+	if opt.Location == nil {
+		opt.Location = time.Local
+	}
+	switch format {
+	case HTML:
+		return writeHTML(w, records, opt)
+	case Markdown:
+		return writeMarkdown(w, records, opt)
+	case JSONL:
+		return writeJSONL(w, records, opt)
+	}
+	return fmt.Errorf("unsupported export format: %s", format)
+}
+
+func writeJSONL(w io.Writer, records []*archive.Record, opt Options) error {
+	buf := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	for _, record := range records {
+		r := *record
+		if r.Raw == nil && opt.Raw != nil {
+			r.Raw, _ = opt.Raw(r.MsgId)
+		}
+		r.Sender = openwechat.FormatEmoji(r.Sender)
+		r.Conversation = openwechat.FormatEmoji(r.Conversation)
+		r.Content = openwechat.FormatEmoji(r.Content)
+		if err := encoder.Encode(&r); err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
+
+func writeMarkdown(w io.Writer, records []*archive.Record, opt Options) error {
+	buf := bufio.NewWriter(w)
+	if opt.Title != "" {
+		fmt.Fprintf(buf, "# %s\n\n", opt.Title)
+	}
+	for _, record := range records {
+		fmt.Fprintf(buf, "**%s** %s", escapeMarkdown(openwechat.FormatEmoji(record.Sender)), record.CreateTime.In(opt.Location).Format("2006-01-02 15:04:05"))
+		if record.Recalled {
+			buf.WriteString(" (已撤回)")
+		}
+		buf.WriteString("\n\n")
+		for _, line := range strings.Split(openwechat.FormatEmoji(record.Content), "\n") {
+			fmt.Fprintf(buf, "> %s\n", line)
+		}
+		for _, hash := range record.Media {
+			fmt.Fprintf(buf, ">\n> [附件](%s/media/%s)\n", opt.MediaURL, hash)
+		}
+		buf.WriteString("\n")
+	}
+	return buf.Flush()
+}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `_`, `\_`, "`", "\\`", `[`, `\[`, `]`, `\]`)
+
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+type htmlMedia struct {
+	Hash    string
+	URL     template.URL
+	IsImage bool
+}
+
+type htmlMessage struct {
+	Sender   string
+	Time     string
+	Content  string
+	Recalled bool
+	Media    []htmlMedia
+}
+
+// htmlTemplate 分为 header、message 和 footer, 消息逐条渲染, 内嵌的图片不会同时留在内存中
+var htmlTemplate = template.Must(template.New("export").Parse(`{{define "header"}}<!DOCTYPE html>
+<html lang="zh">
+<head>
+<meta charset="UTF-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif; background-color: #f0f2f5; margin: 0; padding: 20px; }
+.container { background-color: #fff; max-width: 800px; margin: 0 auto; padding: 30px; border-radius: 8px; }
+h1 { color: #2c3e50; }
+.message { margin-bottom: 12px; padding: 8px; border-left: 3px solid #3498db; background-color: #f9f9f9; }
+.message.recalled { border-left-color: #e74c3c; }
+.meta { font-size: 0.85em; color: #7f8c8d; margin-bottom: 4px; }
+.content { white-space: pre-wrap; word-break: break-all; color: #34495e; }
+.message img { max-width: 100%; margin-top: 6px; }
+</style>
+</head>
+<body>
+<div class="container">
+<h1>{{.Title}}</h1>
+<p class="meta">导出时间: {{.ExportedAt}}, 共 {{.Count}} 条消息</p>
+{{end}}{{define "message"}}<div class="message{{if .Recalled}} recalled{{end}}">
+<div class="meta">{{.Sender}} {{.Time}}{{if .Recalled}} (已撤回){{end}}</div>
+<div class="content">{{.Content}}</div>
+{{range .Media}}{{if .IsImage}}<img src="{{.URL}}" alt="{{.Hash}}">{{else}}<div><a href="{{.URL}}">附件 {{.Hash}}</a></div>{{end}}
+{{end}}</div>
+{{end}}{{define "footer"}}</div>
+</body>
+</html>
+{{end}}`))
+
+func writeHTML(w io.Writer, records []*archive.Record, opt Options) error {
+	buf := bufio.NewWriter(w)
+	header := struct {
+		Title      string
+		ExportedAt string
+		Count      int
+	}{
+		Title:      opt.Title,
+		ExportedAt: time.Now().In(opt.Location).Format("2006-01-02 15:04:05"),
+		Count:      len(records),
+	}
+	if err := htmlTemplate.ExecuteTemplate(buf, "header", header); err != nil {
+		return err
+	}
+	for _, record := range records {
+		message := htmlMessage{
+			Sender:   openwechat.FormatEmoji(record.Sender),
+			Time:     record.CreateTime.In(opt.Location).Format("2006-01-02 15:04:05"),
+			Content:  openwechat.FormatEmoji(record.Content),
+			Recalled: record.Recalled,
+		}
+		for _, hash := range record.Media {
+			message.Media = append(message.Media, embedMedia(hash, opt))
+		}
+		if err := htmlTemplate.ExecuteTemplate(buf, "message", message); err != nil {
+			return err
+		}
+		// 含有图片的消息较大, 写完就交给 w
+		if len(message.Media) > 0 {
+			if err := buf.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := htmlTemplate.ExecuteTemplate(buf, "footer", nil); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+// embedMedia 将图片转换为 data URI, 其他类型的文件或读取失败时返回链接
+func embedMedia(hash string, opt Options) htmlMedia {
+	item := htmlMedia{Hash: hash, URL: template.URL(opt.MediaURL + "/media/" + hash)}
+	if opt.Media == nil {
+		return item
+	}
+	reader, err := opt.Media(hash)
+	if err != nil {
+		return item
+	}
+	defer func() { _ = reader.Close() }()
+	data, err := io.ReadAll(io.LimitReader(reader, maxEmbedSize+1))
+	if err != nil || len(data) > maxEmbedSize {
+		return item
+	}
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return item
+	}
+	var uri bytes.Buffer
+	uri.WriteString("data:" + contentType + ";base64,")
+	uri.WriteString(base64.StdEncoding.EncodeToString(data))
+	item.URL = template.URL(uri.String())
+	item.IsImage = true
+	return item
+}
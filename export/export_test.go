@@ -0,0 +1,113 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"bestrui/wechatpush/archive"
+)
+
+// 1x1 的 png 图片
+var pixel = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00\x1f\x15\xc4\x89")
+
+func testRecords() []*archive.Record {
+	created := time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)
+	return []*archive.Record{
+		{MsgId: "1", Conversation: "运维群", Sender: `张三<span class="emoji emoji1f604"></span>`, Content: "<b>服务</b>恢复了", CreateTime: created},
+		{MsgId: "2", Conversation: "运维群", Sender: "李四", Content: "[图片]", CreateTime: created.Add(time.Minute), Media: []string{"img"}, Recalled: true},
+	}
+}
+
+func testOptions() Options {
+	return Options{
+		Title:    "运维群",
+		Location: time.UTC,
+		Media: func(hash string) (io.ReadCloser, error) {
+			if hash == "img" {
+				return io.NopCloser(bytes.NewReader(pixel)), nil
+			}
+			return nil, errors.New("not found")
+		},
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, HTML, testRecords(), testOptions()); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{"张三😄", "&lt;b&gt;服务&lt;/b&gt;恢复了", "data:image/png;base64,", "(已撤回)", "2024-03-01 10:30:00"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("html output missing %q", want)
+		}
+	}
+	if want := fmt.Sprintf("共 %d 条消息", len(testRecords())); !strings.Contains(out, want) {
+		t.Errorf("html output missing %q", want)
+	}
+	if !strings.HasSuffix(out, "</html>\n") {
+		t.Error("html output not closed")
+	}
+}
+
+// chunkWriter 记录每次写入的内容
+type chunkWriter struct{ chunks []string }
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.chunks = append(w.chunks, string(p))
+	return len(p), nil
+}
+
+// 内嵌图片的消息写完就交给 writer, 不等到整个文件生成
+func TestWriteHTMLStreams(t *testing.T) {
+	var w chunkWriter
+	if err := Write(&w, HTML, testRecords(), testOptions()); err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range w.chunks {
+		if strings.Contains(chunk, "data:image/png;base64,") {
+			if strings.Contains(chunk, "</html>") {
+				t.Error("embedded image was written together with the footer")
+			}
+			return
+		}
+	}
+	t.Error("no embedded image written")
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, Markdown, testRecords(), testOptions()); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{"# 运维群", "**张三😄** 2024-03-01 10:30:00", "> [附件](/media/img)", "(已撤回)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("markdown output missing %q", want)
+		}
+	}
+}
+
+func TestWriteJSONL(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, JSONL, testRecords(), testOptions()); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	var record archive.Record
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Sender != "张三😄" {
+		t.Errorf("unexpected sender %q", record.Sender)
+	}
+}
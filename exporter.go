@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bestrui/wechatpush/archive"
+	"bestrui/wechatpush/export"
+	"bestrui/wechatpush/logging"
+	"bestrui/wechatpush/media"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var exportLog = logging.For("export")
+
+// 查询需要导出的消息, 按时间正序排列
+func exportRecords(store *archive.Store, conversation string, since, until time.Time) []*archive.Record {
+	records := store.Search(archive.Query{Conversation: conversation, Since: since, Until: until})
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	return records
+}
+
+func exportTitle(conversation string, since, until time.Time) string {
+	title := conversation
+	if title == "" {
+		title = "全部会话"
+	}
+	if !since.IsZero() || !until.IsZero() {
+		var from, to string
+		if !since.IsZero() {
+			from = since.Format("2006-01-02 15:04")
+		}
+		if !until.IsZero() {
+			to = until.Format("2006-01-02 15:04")
+		}
+		title = fmt.Sprintf("%s (%s ~ %s)", title, from, to)
+	}
+	return title
+}
+
+func mediaOpener(store *media.Store) export.MediaOpener {
+	if store == nil {
+		return nil
+	}
+	return func(hash string) (io.ReadCloser, error) { return store.Open(hash) }
+}
+
+// runExportCommand 命令行导出
+//
+//	./main export -group 运维群 -since 2024-01-01 -until 2024-02-01 -format html -o out.html
+func runExportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	var (
+		group      = flags.String("group", "", "会话名称, 为空时导出所有会话")
+		sinceFlag  = flags.String("since", "", "开始时间, 支持 RFC3339、日期和 Unix 时间戳")
+		untilFlag  = flags.String("until", "", "结束时间, 格式同 since")
+		formatFlag = flags.String("format", "html", "导出格式: html, markdown, jsonl")
+		output     = flags.String("o", "", "输出文件, 为空时输出到标准输出")
+		archiveDir = flags.String("archive", archiveDirFromEnv(), "消息归档目录")
+		mediaDir   = flags.String("media", mediaDirFromEnv(), "媒体文件存储目录")
+	)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	format, err := export.ParseFormat(*formatFlag)
+	if err != nil {
+		return err
+	}
+	since, err := parseQueryTime(*sinceFlag)
+	if err != nil {
+		return fmt.Errorf("invalid since: %w", err)
+	}
+	until, err := parseQueryTime(*untilFlag)
+	if err != nil {
+		return fmt.Errorf("invalid until: %w", err)
+	}
+
+	store, err := archive.Open(*archiveDir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = store.Close() }()
+
+	// 媒体文件目录不存在时只导出链接
+	var blobs *media.Store
+	if _, err = os.Stat(*mediaDir); err == nil {
+		if blobs, err = media.NewStore(*mediaDir, 0); err != nil {
+			return err
+		}
+	}
+
+	var writer io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = file.Close() }()
+		writer = file
+	}
+
+	records := exportRecords(store, *group, since, until)
+	opt := export.Options{
+		Title:    exportTitle(*group, since, until),
+		Media:    mediaOpener(blobs),
+		MediaURL: strings.TrimSuffix(os.Getenv("MEDIA_BASE_URL"), "/"),
+		Raw:      store.Raw,
+	}
+	if err = export.Write(writer, format, records, opt); err != nil {
+		return err
+	}
+	if *output != "" {
+		exportLog.Info("已导出消息", "records", len(records), "output", *output)
+	}
+	return nil
+}
+
+// serveExport 导出会话记录接口
+//
+//	GET /export?group=运维群&since=2024-01-01&until=2024-02-01&format=html
+func serveExport(w http.ResponseWriter, r *http.Request) {
+	if !checkPagePassword(w, r) {
+		return
+	}
+	if messageArchive == nil {
+		http.Error(w, "消息归档未启用", http.StatusServiceUnavailable)
+		return
+	}
+	params := r.URL.Query()
+	format, err := export.ParseFormat(params.Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	since, err := parseQueryTime(params.Get("since"))
+	if err != nil {
+		http.Error(w, "无效的 since 参数", http.StatusBadRequest)
+		return
+	}
+	until, err := parseQueryTime(params.Get("until"))
+	if err != nil {
+		http.Error(w, "无效的 until 参数", http.StatusBadRequest)
+		return
+	}
+	group := params.Get("group")
+	records := exportRecords(messageArchive, group, since, until)
+	opt := export.Options{
+		Title:    exportTitle(group, since, until),
+		Media:    mediaOpener(mediaStore),
+		MediaURL: strings.TrimSuffix(os.Getenv("MEDIA_BASE_URL"), "/"),
+		Raw:      messageArchive.Raw,
+	}
+
+	filename := group
+	if filename == "" {
+		filename = "messages"
+	}
+	filename += format.Ext()
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(filename))
+	if err = export.Write(w, format, records, opt); err != nil {
+		exportLog.Error("导出会话记录失败", "error", err)
+	}
+}
@@ -0,0 +1,152 @@
+package flood
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSeen(t *testing.T) {
+	seen := NewSeen(3)
+	if seen.Check("1", "n1") {
+		t.Fatal("first delivery should not be duplicate")
+	}
+	// 重新同步时 MsgId 或者 NewMsgId 任意一个相同即为重复
+	if !seen.Check("", "n1") {
+		t.Fatal("same NewMsgId should be duplicate")
+	}
+	seen.Check("2")
+	seen.Check("3")
+	if seen.Check("1") {
+		t.Error("1 should be evicted")
+	}
+}
+
+func TestSeenSameIds(t *testing.T) {
+	seen := NewSeen(10)
+	// 网页版微信的 MsgId 和 NewMsgId 相同
+	if seen.Check("5512345678901234567", "5512345678901234567") {
+		t.Fatal("first delivery should not be duplicate")
+	}
+	if !seen.Check("5512345678901234567", "5512345678901234567") {
+		t.Fatal("second delivery should be duplicate")
+	}
+	if seen.Len() != 1 {
+		t.Errorf("len = %d, want 1", seen.Len())
+	}
+}
+
+func TestSeenPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.json")
+	seen := NewSeen(3)
+	for i := 1; i <= 5; i++ {
+		seen.Check(strconv.Itoa(i))
+	}
+	if err := seen.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewSeen(2)
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 2 || !loaded.Check("5") || !loaded.Check("4") {
+		t.Error("latest ids should be loaded")
+	}
+	if loaded.Check("3") {
+		t.Error("3 should not fit in capacity")
+	}
+}
+
+func TestSeenSaveRetry(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	path := filepath.Join(dir, "seen.json")
+	seen := NewSeen(3)
+	seen.Check("1")
+	if err := seen.Save(path); err == nil {
+		t.Fatal("save into a missing directory should fail")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// 上一次保存失败, 没有新的修改也要再次保存
+	if err := seen.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewSeen(3)
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Check("1") {
+		t.Error("id was not saved after the failed attempt")
+	}
+}
+
+func TestLimiterRefund(t *testing.T) {
+	now := time.Date(2023, 9, 18, 10, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(1)
+	limiter.Now = func() time.Time { return now }
+
+	if !limiter.Allow("项目群") {
+		t.Fatal("burst should be allowed")
+	}
+	limiter.Refund("项目群")
+	if !limiter.Allow("项目群") {
+		t.Fatal("refunded token should be available")
+	}
+	// 退还的令牌不超过突发上限
+	limiter.Refund("项目群")
+	limiter.Refund("项目群")
+	if !limiter.Allow("项目群") || limiter.Allow("项目群") {
+		t.Fatal("refund should be capped at burst")
+	}
+	if suppressed := limiter.Flush(); len(suppressed) != 1 || suppressed[0].Count != 1 {
+		t.Errorf("suppressed = %+v", suppressed)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2023, 9, 18, 10, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(2)
+	limiter.Now = func() time.Time { return now }
+
+	if !limiter.Allow("项目群") || !limiter.Allow("项目群") {
+		t.Fatal("burst should be allowed")
+	}
+	for i := 0; i < 3; i++ {
+		if limiter.Allow("项目群") {
+			t.Fatal("should be throttled")
+		}
+	}
+	// 其他 key 不受影响
+	if !limiter.Allow("闲聊群") {
+		t.Fatal("other key should be allowed")
+	}
+	// 30 秒补充一个令牌
+	now = now.Add(30 * time.Second)
+	if !limiter.Allow("项目群") {
+		t.Fatal("token should be refilled")
+	}
+	if limiter.Allow("项目群") {
+		t.Fatal("should be throttled again")
+	}
+
+	suppressed := limiter.Flush()
+	if len(suppressed) != 1 || suppressed[0].Key != "项目群" || suppressed[0].Count != 4 {
+		t.Fatalf("suppressed = %+v", suppressed)
+	}
+	if !suppressed[0].Last.Equal(now) || !suppressed[0].First.Equal(now.Add(-30*time.Second)) {
+		t.Errorf("suppressed window = %s - %s", suppressed[0].First, suppressed[0].Last)
+	}
+	if len(limiter.Flush()) != 0 {
+		t.Error("flush should reset counters")
+	}
+
+	// 补满的令牌桶在 Flush 时被清理
+	now = now.Add(time.Hour)
+	limiter.Flush()
+	if len(limiter.buckets) != 0 {
+		t.Errorf("buckets = %d", len(limiter.buckets))
+	}
+}
@@ -0,0 +1,117 @@
+package flood
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Suppressed 某个限流对象在一段时间内被省略的消息
+type Suppressed struct {
+	Key   string
+	Count int
+	First time.Time
+	Last  time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter 按 key 的令牌桶限流, 并统计被省略的消息数量
+type Limiter struct {
+	mu         sync.Mutex
+	rate       float64 // 每秒补充的令牌数
+	burst      float64
+	buckets    map[string]*bucket
+	suppressed map[string]*Suppressed
+	// Now 返回当前时间, 测试时可以替换
+	Now func() time.Time
+}
+
+// NewLimiter 创建每个 key 每分钟最多 perMinute 条消息的限流器, 突发上限也为 perMinute
+func NewLimiter(perMinute int) *Limiter {
+	if perMinute <= 0 {
+		perMinute = 1
+	}
+	return &Limiter{
+		rate:       float64(perMinute) / 60,
+		burst:      float64(perMinute),
+		buckets:    make(map[string]*bucket),
+		suppressed: make(map[string]*Suppressed),
+		Now:        time.Now,
+	}
+}
+
+// Allow 有令牌时消耗一个令牌并返回 true, 否则将消息计入省略数量
+func (l *Limiter) Allow(key string) bool {
+	now := l.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.refill(key, now)
+	if b.tokens < 1 {
+		l.suppress(key, now)
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Refund 退还 Allow 消耗的令牌, 用于消息最终因为其他限制没有发送的情况
+func (l *Limiter) Refund(key string) {
+	now := l.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.refill(key, now)
+	if b.tokens++; b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+}
+
+func (l *Limiter) refill(key string, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+		return b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * l.rate
+		if b.tokens > l.burst {
+			b.tokens = l.burst
+		}
+	}
+	b.last = now
+	return b
+}
+
+func (l *Limiter) suppress(key string, now time.Time) {
+	s, ok := l.suppressed[key]
+	if !ok {
+		s = &Suppressed{Key: key, First: now}
+		l.suppressed[key] = s
+	}
+	s.Count++
+	s.Last = now
+}
+
+// Flush 返回并清空所有被省略的消息统计, 按 key 排序
+// 同时清理已经补满的令牌桶, 避免长期运行时占用内存
+func (l *Limiter) Flush() []Suppressed {
+	now := l.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	result := make([]Suppressed, 0, len(l.suppressed))
+	for _, s := range l.suppressed {
+		result = append(result, *s)
+	}
+	l.suppressed = make(map[string]*Suppressed)
+	for key := range l.buckets {
+		if l.refill(key, now).tokens >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
@@ -0,0 +1,137 @@
+package flood
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Seen 记录最近处理过的消息 id, 超过容量时淘汰最早的 id
+type Seen struct {
+	mu       sync.Mutex
+	capacity int
+	ring     []string
+	next     int
+	ids      map[string]struct{}
+	version  uint64     // 每次修改加一
+	saved    uint64     // 已经保存到文件的 version
+	saveMu   sync.Mutex // 保证保存按顺序进行
+}
+
+// NewSeen 创建容量为 capacity 的 Seen
+func NewSeen(capacity int) *Seen {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &Seen{
+		capacity: capacity,
+		ring:     make([]string, 0, capacity),
+		ids:      make(map[string]struct{}, capacity),
+	}
+}
+
+// Check 判断消息是否已经处理过, 并记录所有的 id
+// 同一条消息的任意一个 id 在之前出现过即视为重复, 空的 id 会被忽略
+// 网页版微信的 MsgId 和 NewMsgId 通常相同, 同一次调用中相同的 id 不算重复
+func (s *Seen) Check(ids ...string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	duplicate := false
+	for _, id := range ids {
+		if _, ok := s.ids[id]; ok && id != "" {
+			duplicate = true
+		}
+	}
+	for _, id := range ids {
+		if _, ok := s.ids[id]; !ok && id != "" {
+			s.add(id)
+		}
+	}
+	return duplicate
+}
+
+func (s *Seen) add(id string) {
+	if len(s.ring) < s.capacity {
+		s.ring = append(s.ring, id)
+	} else {
+		delete(s.ids, s.ring[s.next])
+		s.ring[s.next] = id
+		s.next = (s.next + 1) % s.capacity
+	}
+	s.ids[id] = struct{}{}
+	s.version++
+}
+
+// Len 返回记录的 id 数量
+func (s *Seen) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.ring)
+}
+
+// Save 将记录的 id 按从旧到新的顺序保存到文件, 没有变化时跳过
+func (s *Seen) Save(path string) error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.Lock()
+	if s.version == s.saved {
+		s.mu.Unlock()
+		return nil
+	}
+	version := s.version
+	ids := make([]string, 0, len(s.ring))
+	ids = append(ids, s.ring[s.next:]...)
+	ids = append(ids, s.ring[:s.next]...)
+	s.mu.Unlock()
+
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".seen-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.saved = version
+	s.mu.Unlock()
+	return nil
+}
+
+// Load 从文件加载记录的 id, 文件不存在时不返回错误
+func (s *Seen) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var ids []string
+	if err = json.Unmarshal(data, &ids); err != nil {
+		return fmt.Errorf("解析 %s 失败: %w", path, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		if _, ok := s.ids[id]; !ok {
+			s.add(id)
+		}
+	}
+	s.saved = s.version
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bestrui/wechatpush/flood"
+	"bestrui/wechatpush/logging"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"bestrui/wechatpush/openwechat"
+)
+
+var floodLog = logging.For("flood")
+
+// 最近处理过的消息 id, 同步重试时同一条消息可能被收到两次
+var seenMessages = flood.NewSeen(10000)
+
+var groupLimiter *flood.Limiter  // 每个群的通知限流, 为 nil 时不限制
+var senderLimiter *flood.Limiter // 每个发送者的通知限流, 为 nil 时不限制
+
+// 已处理消息 id 的保存文件, 默认保存在归档目录旁边
+func seenFileFromEnv() string {
+	if path := os.Getenv("SEEN_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join(filepath.Dir(archiveDirFromEnv()), "seen.json")
+}
+
+// 读取每分钟的通知数量限制, 0 表示不限制
+func limiterFromEnv(key string, fallback int) *flood.Limiter {
+	limit := fallback
+	if value := os.Getenv(key); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			floodLog.Warn("环境变量无效, 使用默认值", "key", key, "value", value, "default", fallback)
+		} else {
+			limit = n
+		}
+	}
+	if limit == 0 {
+		return nil
+	}
+	return flood.NewLimiter(limit)
+}
+
+// 初始化消息去重和限流, 每分钟保存已处理的消息 id 并发送省略消息的汇总
+func initFloodControl() {
+	if err := seenMessages.Load(seenFileFromEnv()); err != nil {
+		floodLog.Error("加载已处理的消息 id 失败", "error", err)
+	}
+	groupLimiter = limiterFromEnv("FLOOD_GROUP_LIMIT", 20)
+	senderLimiter = limiterFromEnv("FLOOD_SENDER_LIMIT", 10)
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			flushSeenMessages()
+			sendSuppressedSummary()
+		}
+	}()
+}
+
+// 保存已处理的消息 id, 没有变化时跳过
+func flushSeenMessages() {
+	if err := seenMessages.Save(seenFileFromEnv()); err != nil {
+		floodLog.Error("保存已处理的消息 id 失败", "error", err)
+	}
+}
+
+// 判断消息是否已经处理过, 使用 NewMsgId, 没有时使用 MsgId
+func isDuplicateMessage(msg *openwechat.Message) bool {
+	id := msg.MsgId
+	if msg.NewMsgId != 0 {
+		id = strconv.FormatInt(msg.NewMsgId, 10)
+	}
+	return seenMessages.Check(id)
+}
+
+// 判断是否超过群或者发送者的通知数量限制
+// 被群限流的消息退还发送者的令牌, 只计入群的省略数量
+func allowNotification(groupName, sender string) bool {
+	senderKey := groupName + " / " + sender
+	if senderLimiter != nil && !senderLimiter.Allow(senderKey) {
+		return false
+	}
+	if groupName != "" && groupLimiter != nil && !groupLimiter.Allow(groupName) {
+		if senderLimiter != nil {
+			senderLimiter.Refund(senderKey)
+		}
+		return false
+	}
+	return true
+}
+
+// 发送被省略消息的汇总通知
+func sendSuppressedSummary() {
+	for _, limiter := range []*flood.Limiter{groupLimiter, senderLimiter} {
+		if limiter == nil {
+			continue
+		}
+		for _, s := range limiter.Flush() {
+			content := fmt.Sprintf("[%s] %s 到 %s 之间还有 %d 条消息因发送过快未转发",
+				s.Key, s.First.Format("15:04:05"), s.Last.Format("15:04:05"), s.Count)
+			floodLog.Info("消息过多, 未转发", "key", s.Key, "count", s.Count, "first", s.First, "last", s.Last)
+			sendNotification("消息过多", content)
+		}
+	}
+}
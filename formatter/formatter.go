@@ -0,0 +1,295 @@
+package formatter
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"bestrui/wechatpush/openwechat"
+	"bestrui/wechatpush/recall"
+)
+
+// RecalledLookup 根据 MsgId 查找被撤回的原始消息, 返回可读的消息内容
+type RecalledLookup func(msgId string) (string, bool)
+
+// Formatter 将各种类型的消息转换为可读的文本
+type Formatter struct {
+	// Recalled 为 nil 时撤回通知不包含原始消息
+	Recalled RecalledLookup
+}
+
+// kind 一种消息类型的格式化方式
+type kind struct {
+	name   string
+	match  func(msg *openwechat.Message) bool
+	format func(f *Formatter, msg *openwechat.Message) (string, error)
+	// fallback 解析失败时使用的内容
+	fallback string
+}
+
+// 按顺序匹配, 越具体的类型越靠前
+var kinds = []kind{
+	{name: "text", match: (*openwechat.Message).IsText, format: formatText},
+	{name: "location", match: (*openwechat.Message).IsLocation, format: formatLocation, fallback: "[位置]"},
+	{name: "picture", match: (*openwechat.Message).IsPicture, format: constant("[图片]")},
+	{name: "voice", match: (*openwechat.Message).IsVoice, format: formatVoice},
+	{name: "video", match: (*openwechat.Message).IsVideo, format: formatVideo},
+	{name: "emoticon", match: (*openwechat.Message).IsEmoticon, format: constant("[动画表情]")},
+	{name: "card", match: (*openwechat.Message).IsCard, format: formatCard, fallback: "[名片]"},
+	{name: "friend_add", match: (*openwechat.Message).IsFriendAdd, format: formatFriendAdd, fallback: "[好友申请]"},
+	{name: "recalled", match: (*openwechat.Message).IsRecalled, format: formatRecalled, fallback: "[撤回了一条消息]"},
+	{name: "transfer", match: (*openwechat.Message).IsTransferAccounts, format: formatTransfer, fallback: "[转账]"},
+	{name: "app", match: (*openwechat.Message).IsMedia, format: formatApp, fallback: "[应用消息]"},
+	{name: "voip", match: (*openwechat.Message).IsVoipInvite, format: constant("[音视频通话邀请]")},
+	{name: "system", match: (*openwechat.Message).IsSystem, format: formatSystem},
+}
+
+// Kind 返回消息的类型名称, 未知类型返回空字符串
+func Kind(msg *openwechat.Message) string {
+	for _, k := range kinds {
+		if k.match(msg) {
+			return k.name
+		}
+	}
+	return ""
+}
+
+// Format 返回消息的可读内容, 第二个返回值为 false 表示未知类型的消息
+func (f *Formatter) Format(msg *openwechat.Message) (string, bool) {
+	for _, k := range kinds {
+		if !k.match(msg) {
+			continue
+		}
+		content, err := k.format(f, msg)
+		if err != nil || content == "" {
+			if k.fallback == "" {
+				return content, content != ""
+			}
+			return k.fallback, true
+		}
+		return content, true
+	}
+	return "", false
+}
+
+func constant(content string) func(*Formatter, *openwechat.Message) (string, error) {
+	return func(*Formatter, *openwechat.Message) (string, error) { return content, nil }
+}
+
+func formatText(_ *Formatter, msg *openwechat.Message) (string, error) {
+	return msg.Content, nil
+}
+
+func formatVoice(_ *Formatter, msg *openwechat.Message) (string, error) {
+	if msg.VoiceLength <= 0 {
+		return "[语音]", nil
+	}
+	seconds := (msg.VoiceLength + 999) / 1000
+	return fmt.Sprintf("[语音] %d秒", seconds), nil
+}
+
+func formatVideo(_ *Formatter, msg *openwechat.Message) (string, error) {
+	if msg.PlayLength <= 0 {
+		return "[视频]", nil
+	}
+	return fmt.Sprintf("[视频] %d秒", msg.PlayLength), nil
+}
+
+// locationContent 位置消息 OriContent 中的内容
+type locationContent struct {
+	XMLName  xml.Name `xml:"msg"`
+	Location struct {
+		X       string `xml:"x,attr"`
+		Y       string `xml:"y,attr"`
+		Label   string `xml:"label,attr"`
+		PoiName string `xml:"poiname,attr"`
+	} `xml:"location"`
+}
+
+func formatLocation(_ *Formatter, msg *openwechat.Message) (string, error) {
+	var name, label string
+	var loc locationContent
+	if msg.OriContent != "" && xml.Unmarshal([]byte(msg.OriContent), &loc) == nil {
+		name, label = loc.Location.PoiName, loc.Location.Label
+	}
+	if label == "" {
+		// Content 的格式为 "地址:\n/cgi-bin/mmwebwx-bin/webwxgetpubliclinkimg?..."
+		label = strings.TrimSuffix(strings.SplitN(msg.Content, "\n", 2)[0], ":")
+	}
+	var builder strings.Builder
+	builder.WriteString("[位置] ")
+	if name != "" && name != label {
+		builder.WriteString(name + " ")
+	}
+	builder.WriteString(label)
+	if msg.Url != "" {
+		builder.WriteString("\n" + msg.Url)
+	}
+	return builder.String(), nil
+}
+
+func formatCard(_ *Formatter, msg *openwechat.Message) (string, error) {
+	card, err := msg.Card()
+	if err != nil {
+		return "", err
+	}
+	content := "[名片] " + card.NickName
+	if card.Alias != "" {
+		content += " (微信号: " + card.Alias + ")"
+	}
+	if region := strings.TrimSpace(card.Province + " " + card.City); region != "" {
+		content += " " + region
+	}
+	return content, nil
+}
+
+func formatFriendAdd(_ *Formatter, msg *openwechat.Message) (string, error) {
+	friend, err := msg.FriendAddMessageContent()
+	if err != nil {
+		return "", err
+	}
+	content := "[好友申请] " + friend.FromNickName
+	if friend.Content != "" {
+		content += ": " + friend.Content
+	}
+	return content, nil
+}
+
+func formatRecalled(f *Formatter, msg *openwechat.Message) (string, error) {
+	revoke, err := msg.RevokeMsg()
+	if err != nil {
+		return "", err
+	}
+	notice := revoke.RevokeMsg.ReplaceMsg
+	if notice == "" {
+		notice = "撤回了一条消息"
+	}
+	content := "[撤回] " + notice
+	if f != nil && f.Recalled != nil {
+		for _, id := range recall.OriginalIDs(revoke) {
+			if original, ok := f.Recalled(id); ok {
+				content += "\n原消息: " + original
+				break
+			}
+		}
+	}
+	return content, nil
+}
+
+// transferContent 转账消息中的支付信息
+type transferContent struct {
+	XMLName xml.Name `xml:"msg"`
+	AppMsg  struct {
+		Title     string `xml:"title"`
+		Des       string `xml:"des"`
+		WcPayInfo struct {
+			PaySubType int    `xml:"paysubtype"`
+			FeeDesc    string `xml:"feedesc"`
+			PayMemo    string `xml:"pay_memo"`
+		} `xml:"wcpayinfo"`
+	} `xml:"appmsg"`
+}
+
+func formatTransfer(_ *Formatter, msg *openwechat.Message) (string, error) {
+	var transfer transferContent
+	if err := xml.Unmarshal([]byte(msg.Content), &transfer); err != nil {
+		return "", err
+	}
+	info := transfer.AppMsg.WcPayInfo
+	if info.FeeDesc == "" {
+		return "[转账] " + transfer.AppMsg.Des, nil
+	}
+	content := "[转账] " + info.FeeDesc
+	switch info.PaySubType {
+	case 3:
+		content += " (已收款)"
+	case 4:
+		content += " (已退还)"
+	}
+	if info.PayMemo != "" {
+		content += " 备注: " + info.PayMemo
+	}
+	return content, nil
+}
+
+func formatApp(_ *Formatter, msg *openwechat.Message) (string, error) {
+	data, err := msg.MediaData()
+	if err != nil {
+		return "", err
+	}
+	app := data.AppMsg
+	switch {
+	case app.Type == openwechat.AppMsgTypeAttach:
+		content := "[文件] " + app.Title
+		if size, err := strconv.ParseInt(app.AppAttach.TotalLen, 10, 64); err == nil && size > 0 {
+			content += " (" + humanSize(size) + ")"
+		}
+		return content, nil
+	case app.Type == openwechat.AppMsgTypeRedEnvelopes:
+		return "[红包] " + app.Title, nil
+	case app.Type == openwechat.AppMsgTypeRealtimeShareLocation:
+		return "[位置共享] " + app.Title, nil
+	case app.WeAppInfo.Appid != "" || app.WeAppInfo.Username != "":
+		return "[小程序] " + app.Title, nil
+	case app.Type == openwechat.AppMsgTypeUrl:
+		return joinNonEmpty("[链接] "+app.Title, app.Des, app.URL), nil
+	case app.Type == openwechat.AppMsgTypeAudio:
+		return joinNonEmpty("[音乐] "+app.Title, app.Des, app.URL), nil
+	case app.Type == openwechat.AppMsgTypeVideo:
+		return joinNonEmpty("[视频链接] "+app.Title, app.URL), nil
+	case app.Type == openwechat.AppMsgTypeEmoji || app.Type == openwechat.AppMsgTypeEmotion:
+		return "[动画表情]", nil
+	}
+	if app.Title == "" {
+		return "", nil
+	}
+	return joinNonEmpty("[应用消息] "+app.Title, app.URL), nil
+}
+
+func formatSystem(_ *Formatter, msg *openwechat.Message) (string, error) {
+	switch {
+	case msg.IsSendRedPacket(), msg.IsReceiveRedPacket():
+		return "[红包] " + msg.Content, nil
+	case isJoinGroup(msg):
+		return "[入群] " + msg.Content, nil
+	case msg.IsTickled():
+		return "[拍一拍] " + msg.Content, nil
+	case msg.IsRenameGroup():
+		return "[群名称修改] " + msg.Content, nil
+	case msg.IsRealtimeLocationStop():
+		return "[位置共享] " + msg.Content, nil
+	}
+	return "[系统消息] " + msg.Content, nil
+}
+
+// isJoinGroup 与 Message.IsJoinGroup 相同, 但是不依赖 Bot 判断发送者
+func isJoinGroup(msg *openwechat.Message) bool {
+	return strings.HasPrefix(msg.FromUserName, "@@") &&
+		(strings.Contains(msg.Content, "加入了群聊") || strings.Contains(msg.Content, "分享的二维码加入群聊"))
+}
+
+func joinNonEmpty(parts ...string) string {
+	var lines []string
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			lines = append(lines, part)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// humanSize 将字节数转换为可读的大小
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	// int64 最大约为 8EB, 循环结束时 exp 不会超过 5
+	return fmt.Sprintf("%.1f%cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
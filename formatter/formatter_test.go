@@ -0,0 +1,101 @@
+package formatter
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bestrui/wechatpush/openwechat"
+)
+
+var update = flag.Bool("update", false, "更新 testdata 中的 golden 文件")
+
+// TestGolden 使用 testdata 中录制的消息内容, 对比格式化结果与 golden 文件
+func TestGolden(t *testing.T) {
+	recalled := map[string]string{
+		"4601234567890123456": "李四: 今晚的会议改到明天",
+	}
+	f := &Formatter{Recalled: func(msgId string) (string, bool) {
+		content, ok := recalled[msgId]
+		return content, ok
+	}}
+	cases := []struct {
+		name string
+		msg  *openwechat.Message
+	}{
+		{name: "text", msg: &openwechat.Message{MsgType: openwechat.MsgTypeText, Content: "你好[微笑]"}},
+		{name: "picture", msg: &openwechat.Message{MsgType: openwechat.MsgTypeImage}},
+		{name: "voice", msg: &openwechat.Message{MsgType: openwechat.MsgTypeVoice, VoiceLength: 4300}},
+		{name: "video", msg: &openwechat.Message{MsgType: openwechat.MsgTypeVideo, PlayLength: 15}},
+		{name: "emoticon", msg: &openwechat.Message{MsgType: openwechat.MsgTypeEmoticon}},
+		{name: "article", msg: &openwechat.Message{MsgType: openwechat.MsgTypeApp, AppMsgType: openwechat.AppMsgTypeUrl}},
+		{name: "file", msg: &openwechat.Message{MsgType: openwechat.MsgTypeApp, AppMsgType: openwechat.AppMsgTypeAttach, FileName: "2023年度报告.pdf"}},
+		{name: "file_huge", msg: &openwechat.Message{MsgType: openwechat.MsgTypeApp, AppMsgType: openwechat.AppMsgTypeAttach, FileName: "huge.bin"}},
+		{name: "card", msg: &openwechat.Message{MsgType: openwechat.MsgTypeShareCard}},
+		{name: "location", msg: &openwechat.Message{
+			MsgType: openwechat.MsgTypeText,
+			Url:     "http://api.map.qq.com/uri/v1/geocoder?coord=39.908823,116.397470",
+			Content: "北京市东城区东长安街:\n/cgi-bin/mmwebwx-bin/webwxgetpubliclinkimg?url=xxx&msgid=4601234567890123457&pictype=location",
+		}},
+		{name: "recalled", msg: &openwechat.Message{MsgType: openwechat.MsgTypeRecalled}},
+		{name: "transfer", msg: &openwechat.Message{MsgType: openwechat.MsgTypeApp, AppMsgType: openwechat.AppMsgTypeTransfers, FileName: "微信转账"}},
+		{name: "redpacket", msg: &openwechat.Message{MsgType: openwechat.MsgTypeApp, AppMsgType: openwechat.AppMsgTypeRedEnvelopes}},
+		{name: "miniprogram", msg: &openwechat.Message{MsgType: openwechat.MsgTypeApp, AppMsgType: 33}},
+		{name: "friend_add", msg: &openwechat.Message{MsgType: openwechat.MsgTypeVerify, FromUserName: "fmessage"}},
+		{name: "join_group", msg: &openwechat.Message{MsgType: openwechat.MsgTypeSys, FromUserName: "@@0123456789abcdef", Content: "\"张三\"邀请\"王五\"加入了群聊"}},
+		{name: "tickle", msg: &openwechat.Message{MsgType: openwechat.MsgTypeSys, Content: "\"李四\" 拍了拍我"}},
+		{name: "receive_redpacket", msg: &openwechat.Message{MsgType: openwechat.MsgTypeSys, Content: "收到红包，请在手机上查看"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			msg := c.msg
+			payload, err := os.ReadFile(filepath.Join("testdata", c.name+".xml"))
+			switch {
+			case err == nil:
+				if msg.IsLocation() {
+					msg.OriContent = string(payload)
+				} else {
+					msg.Content = string(payload)
+				}
+			case !errors.Is(err, fs.ErrNotExist):
+				t.Fatal(err)
+			}
+			got, ok := f.Format(msg)
+			if !ok {
+				t.Fatalf("unknown message kind")
+			}
+			golden := filepath.Join("testdata", c.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got+"\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got+"\n" != string(want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestUnknown(t *testing.T) {
+	var f Formatter
+	if _, ok := f.Format(&openwechat.Message{MsgType: 51, StatusNotifyCode: 4}); ok {
+		t.Error("status notify should be unknown")
+	}
+}
+
+func TestMalformedFallback(t *testing.T) {
+	var f Formatter
+	got, ok := f.Format(&openwechat.Message{MsgType: openwechat.MsgTypeShareCard, Content: "<msg"})
+	if !ok || got != "[名片]" {
+		t.Errorf("got %q, %v", got, ok)
+	}
+}
@@ -4,4 +4,4 @@ go 1.21
 
 require github.com/joho/godotenv v1.5.1
 
-require github.com/eatmoreapple/openwechat v1.4.8
+require github.com/mozillazg/go-pinyin v0.21.0
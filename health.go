@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bestrui/wechatpush/mail"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"bestrui/wechatpush/openwechat"
+)
+
+const (
+	// 超过这个时间没有成功的 synccheck 视为和微信断开, 正常情况下每次长轮询约25秒
+	syncCheckStaleAfter = 2 * time.Minute
+	// SMTP 探测结果的缓存时间, 避免每次检查都连接邮件服务器
+	mailProbeInterval = time.Minute
+	mailProbeTimeout  = 5 * time.Second
+	// 待处理的消息和升级提醒超过这个数量时视为未就绪
+	maxReadyBacklog = 500
+)
+
+// 最近一次成功的 synccheck 时间
+var lastSyncCheck atomic.Int64
+
+// 记录成功的 synccheck
+func recordSyncCheck(resp openwechat.SyncCheckResponse) {
+	if resp.Success() {
+		lastSyncCheck.Store(time.Now().UnixNano())
+	}
+}
+
+// 缓存的 SMTP 探测结果
+var mailProbe struct {
+	mu      sync.Mutex
+	probing bool // 正在连接邮件服务器
+	checked time.Time
+	err     error
+}
+
+var errMailNotProbed = errors.New("正在检查 SMTP 服务器")
+
+// 探测 SMTP 服务器, 一分钟内返回上一次的结果
+// 连接时不持有锁, 其他请求在探测完成前直接返回上一次的结果
+func probeMail(now time.Time) (time.Time, error) {
+	mailProbe.mu.Lock()
+	if mailProbe.probing || (!mailProbe.checked.IsZero() && now.Sub(mailProbe.checked) < mailProbeInterval) {
+		defer mailProbe.mu.Unlock()
+		if mailProbe.checked.IsZero() {
+			return mailProbe.checked, errMailNotProbed
+		}
+		return mailProbe.checked, mailProbe.err
+	}
+	mailProbe.probing = true
+	mailProbe.mu.Unlock()
+
+	err := mail.Probe(mailProbeTimeout)
+
+	mailProbe.mu.Lock()
+	defer mailProbe.mu.Unlock()
+	mailProbe.probing = false
+	mailProbe.checked = now
+	mailProbe.err = err
+	return now, err
+}
+
+type readiness struct {
+	Ready bool `json:"ready"`
+	Bot   struct {
+		Alive bool `json:"alive"`
+	} `json:"bot"`
+	SyncCheck struct {
+		OK          bool       `json:"ok"`
+		LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+		SecondsAgo  float64    `json:"secondsAgo,omitempty"`
+	} `json:"syncCheck"`
+	Mail struct {
+		OK        bool      `json:"ok"`
+		CheckedAt time.Time `json:"checkedAt"`
+		Error     string    `json:"error,omitempty"`
+	} `json:"mail"`
+	Backlog struct {
+		OK          bool `json:"ok"`
+		Dispatch    int  `json:"dispatch"`
+		Escalations int  `json:"escalations"`
+	} `json:"backlog"`
+}
+
+// 进程在运行即返回200
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// bot 在线、synccheck 正常并且没有积压时返回200, 否则返回503, 用作容器的健康检查
+// 邮件服务器的状态只在结果中报告, 不影响状态码, 邮件服务器故障时重启容器没有用处
+func serveReadyz(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	var status readiness
+	status.Bot.Alive = isBotAlive()
+
+	if last := lastSyncCheck.Load(); last != 0 {
+		t := time.Unix(0, last)
+		status.SyncCheck.LastSuccess = &t
+		status.SyncCheck.SecondsAgo = now.Sub(t).Seconds()
+		status.SyncCheck.OK = now.Sub(t) < syncCheckStaleAfter
+	}
+
+	checked, err := probeMail(now)
+	status.Mail.CheckedAt = checked
+	status.Mail.OK = err == nil
+	if err != nil {
+		status.Mail.Error = err.Error()
+	}
+
+	if current := activeBot.Load(); current != nil {
+		if stats, ok := current.WorkerPoolStats(); ok {
+			status.Backlog.Dispatch = stats.Pending
+		}
+	}
+	if escalator != nil {
+		status.Backlog.Escalations = escalator.Pending()
+	}
+	status.Backlog.OK = status.Backlog.Dispatch+status.Backlog.Escalations <= maxReadyBacklog
+
+	status.Ready = status.Bot.Alive && status.SyncCheck.OK && status.Backlog.OK
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !status.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
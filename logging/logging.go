@@ -0,0 +1,174 @@
+// Package logging 基于 log/slog 的日志配置, 支持按组件区分日志和隐藏消息内容
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// Config 日志配置
+type Config struct {
+	Level   slog.Level // 最低输出的级别
+	Format  string     // json 或者 text, 默认为 json
+	Privacy bool       // 隐私模式, 不输出消息内容
+}
+
+// ConfigFromEnv 从 LOG_LEVEL、LOG_FORMAT 和 LOG_PRIVACY 读取配置
+func ConfigFromEnv() (Config, error) {
+	config := Config{Level: slog.LevelInfo, Format: "json"}
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		if err := config.Level.UnmarshalText([]byte(value)); err != nil {
+			return config, fmt.Errorf("LOG_LEVEL 无效: %s", value)
+		}
+	}
+	if value := strings.ToLower(os.Getenv("LOG_FORMAT")); value != "" {
+		if value != "json" && value != "text" {
+			return config, fmt.Errorf("LOG_FORMAT 无效: %s", value)
+		}
+		config.Format = value
+	}
+	switch strings.ToLower(os.Getenv("LOG_PRIVACY")) {
+	case "", "0", "false", "off":
+	case "1", "true", "on":
+		config.Privacy = true
+	default:
+		return config, fmt.Errorf("LOG_PRIVACY 无效: %s", os.Getenv("LOG_PRIVACY"))
+	}
+	return config, nil
+}
+
+var (
+	output  atomic.Pointer[slog.Handler]
+	privacy atomic.Bool
+)
+
+func init() {
+	var handler slog.Handler = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{ReplaceAttr: redactAttr})
+	output.Store(&handler)
+}
+
+// Setup 按配置输出到 w, 同时替换 slog.Default() 和标准库 log 的输出
+func Setup(w io.Writer, config Config) {
+	options := &slog.HandlerOptions{Level: config.Level, ReplaceAttr: redactAttr}
+	var handler slog.Handler
+	if config.Format == "text" {
+		handler = slog.NewTextHandler(w, options)
+	} else {
+		handler = slog.NewJSONHandler(w, options)
+	}
+	output.Store(&handler)
+	privacy.Store(config.Privacy)
+	slog.SetDefault(slog.New(&lazyHandler{}))
+}
+
+// For 返回某个组件的日志, 输出时带有 component 字段
+// 可以在 Setup 之前创建, 输出时使用最新的配置
+func For(component string) *slog.Logger {
+	return slog.New(&lazyHandler{}).With("component", component)
+}
+
+// lazyHandler 输出时使用当前配置的 handler, 再依次应用 With 和 WithGroup
+// 应用后的结果按配置缓存, Setup 之后的第一次输出重新生成
+type lazyHandler struct {
+	ops   []func(slog.Handler) slog.Handler
+	cache atomic.Pointer[derivedHandler]
+}
+
+// derivedHandler 某一次配置的 handler 应用 With 和 WithGroup 之后的结果
+type derivedHandler struct {
+	base    *slog.Handler
+	handler slog.Handler
+}
+
+func (h *lazyHandler) handler() slog.Handler {
+	base := output.Load()
+	if cached := h.cache.Load(); cached != nil && cached.base == base {
+		return cached.handler
+	}
+	handler := *base
+	for _, op := range h.ops {
+		handler = op(handler)
+	}
+	h.cache.Store(&derivedHandler{base: base, handler: handler})
+	return handler
+}
+
+func (h *lazyHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return (*output.Load()).Enabled(ctx, level)
+}
+
+func (h *lazyHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.handler().Handle(ctx, record)
+}
+
+func (h *lazyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (h *lazyHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+func (h *lazyHandler) with(op func(slog.Handler) slog.Handler) slog.Handler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &lazyHandler{ops: append(ops, op)}
+}
+
+// secretKeys 这些字段的值总是被隐藏
+var secretKeys = map[string]bool{
+	"password":          true,
+	"passwd":            true,
+	"secret":            true,
+	"token":             true,
+	"skey":              true,
+	"pass_ticket":       true,
+	"webwx_data_ticket": true,
+	"cookie":            true,
+}
+
+func redactAttr(_ []string, attr slog.Attr) slog.Attr {
+	if secretKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, Mask(attr.Value.String()))
+	}
+	return attr
+}
+
+// Mask 隐藏密码等敏感信息, 空值保持为空, 方便区分没有配置的情况
+func Mask(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "****"
+}
+
+// Secret 总是隐藏值的字段
+func Secret(key, value string) slog.Attr {
+	return slog.String(key, Mask(value))
+}
+
+// content 消息内容, 隐私模式下只输出长度
+type content string
+
+func (c content) LogValue() slog.Value {
+	if privacy.Load() {
+		return slog.StringValue(fmt.Sprintf("[隐藏 %d 字]", utf8.RuneCountInString(string(c))))
+	}
+	return slog.StringValue(string(c))
+}
+
+// Content 消息内容字段, 隐私模式下不输出内容
+func Content(key, value string) slog.Attr {
+	return slog.Any(key, content(value))
+}
+
+// Privacy 是否开启了隐私模式
+func Privacy() bool {
+	return privacy.Load()
+}
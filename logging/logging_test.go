@@ -0,0 +1,103 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestComponentLogger(t *testing.T) {
+	// 在 Setup 之前创建的 logger 也使用新的配置
+	logger := For("mail").With("server", "smtp.example.com")
+	var buf bytes.Buffer
+	Setup(&buf, Config{Level: slog.LevelInfo})
+	defer Setup(&bytes.Buffer{}, Config{})
+
+	logger.Debug("不输出")
+	logger.Info("发送邮件", "password", "abcdefgh123", Secret("auth", "xyz"))
+	lines := decodeLines(t, &buf)
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines: %s", len(lines), buf.String())
+	}
+	entry := lines[0]
+	if entry["component"] != "mail" || entry["server"] != "smtp.example.com" || entry["msg"] != "发送邮件" {
+		t.Errorf("got %v", entry)
+	}
+	if entry["password"] != "****" || entry["auth"] != "****" {
+		t.Errorf("secrets not masked: %v", entry)
+	}
+}
+
+func TestLazyHandlerCache(t *testing.T) {
+	var derived int
+	handler := &lazyHandler{ops: []func(slog.Handler) slog.Handler{func(h slog.Handler) slog.Handler {
+		derived++
+		return h
+	}}}
+	Setup(&bytes.Buffer{}, Config{Level: slog.LevelInfo})
+	defer Setup(&bytes.Buffer{}, Config{})
+
+	logger := slog.New(handler)
+	logger.Info("1")
+	logger.Info("2")
+	if derived != 1 {
+		t.Errorf("derived %d times before Setup, want 1", derived)
+	}
+	// 重新配置后使用新的输出
+	var buf bytes.Buffer
+	Setup(&buf, Config{Level: slog.LevelInfo})
+	logger.Info("3")
+	if derived != 2 || !strings.Contains(buf.String(), `"msg":"3"`) {
+		t.Errorf("derived %d times, output %s", derived, buf.String())
+	}
+}
+
+func TestPrivacyMode(t *testing.T) {
+	var buf bytes.Buffer
+	Setup(&buf, Config{Level: slog.LevelInfo, Privacy: true})
+	defer Setup(&bytes.Buffer{}, Config{})
+
+	For("message").Info("收到消息", Content("content", "周五下午开会"))
+	Setup(&buf, Config{Level: slog.LevelInfo})
+	For("message").Info("收到消息", Content("content", "周五下午开会"))
+
+	lines := decodeLines(t, &buf)
+	if len(lines) != 2 || lines[0]["content"] != "[隐藏 6 字]" || lines[1]["content"] != "周五下午开会" {
+		t.Errorf("got %v", lines)
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("LOG_FORMAT", "TEXT")
+	t.Setenv("LOG_PRIVACY", "true")
+	config, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Level != slog.LevelDebug || config.Format != "text" || !config.Privacy {
+		t.Errorf("got %+v", config)
+	}
+	t.Setenv("LOG_LEVEL", "verbose")
+	if _, err = ConfigFromEnv(); err == nil {
+		t.Error("expected error for invalid LOG_LEVEL")
+	}
+}
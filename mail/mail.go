@@ -3,15 +3,20 @@ package mail
 import (
 	"crypto/tls"
 	"fmt"
-	"log"
+	"net"
 	"net/mail"
 	"net/smtp"
 	"os"
 	"path/filepath"
+	"time"
+
+	"bestrui/wechatpush/logging"
 
 	"github.com/joho/godotenv"
 )
 
+var logger = logging.For("mail")
+
 var (
 	from       mail.Address
 	to         mail.Address
@@ -29,14 +34,14 @@ func init() {
 	for _, path := range envPaths {
 		absPath, _ := filepath.Abs(path)
 		if err := godotenv.Load(absPath); err == nil {
-			log.Printf("成功加载 .env 文件: %s", absPath)
+			logger.Info("成功加载 .env 文件", "path", absPath)
 			envLoaded = true
 			break
 		}
 	}
 
 	if !envLoaded {
-		log.Println("警告: 无法加载 .env 文件，将使用环境变量")
+		logger.Warn("无法加载 .env 文件，将使用环境变量")
 	}
 
 	from = mail.Address{
@@ -52,17 +57,16 @@ func init() {
 	username = getEnv("FROM_ADDRESS", "") // 使用FROM_ADDRESS作为username
 	password = getEnv("PASSWORD", "")
 
-	// 添加调试日志
-	log.Printf("调试: 从环境变量读取的值:")
-	log.Printf("FROM_ADDRESS: %s", from.Address)
-	log.Printf("TO_ADDRESS: %s", to.Address)
-	log.Printf("SMTP_SERVER: %s", smtpServer)
-	log.Printf("SMTP_PORT: %s", smtpPort)
-	log.Printf("USERNAME: %s", username)
-	log.Printf("PASSWORD: %s", password[:4]+"****") // 只显示密码的前四个字符
+	// 密码不输出, 只记录是否设置
+	logger.Debug("邮件配置",
+		"from", from.Address,
+		"to", to.Address,
+		"smtp_server", smtpServer,
+		"smtp_port", smtpPort,
+		"password_set", password != "")
 
 	if from.Address == "" || to.Address == "" || smtpServer == "" || username == "" || password == "" {
-		log.Println("警告: 一些必要的环境变量未设置")
+		logger.Warn("一些必要的环境变量未设置")
 	}
 }
 
@@ -74,16 +78,44 @@ func getEnv(key, fallback string) string {
 }
 
 func SendEmail(name string, content string) error {
-	// 添加调试日志
-	log.Printf("调试: 发送邮件时使用的值:")
-	log.Printf("SMTP_SERVER: %s", smtpServer)
-	log.Printf("SMTP_PORT: %s", smtpPort)
-	log.Printf("USERNAME: %s", username)
-	log.Printf("PASSWORD: %s", password[:4]+"****") // 只显示密码的前四个字符
+	return send(to, name, content)
+}
+
+// SendEmailTo 发送邮件到指定的收件人, address 可以是 "名字 <地址>" 或者只有地址
+func SendEmailTo(address string, name string, content string) error {
+	recipient, err := mail.ParseAddress(address)
+	if err != nil {
+		return fmt.Errorf("收件人地址 %q 无效: %v", address, err)
+	}
+	return send(*recipient, name, content)
+}
+
+// Probe 检查能否连接到SMTP服务器并收到欢迎信息, 不进行认证和发送
+func Probe(timeout time.Duration) error {
+	if smtpServer == "" {
+		return fmt.Errorf("SMTP_SERVER 未设置")
+	}
+	addr := fmt.Sprintf("%s:%s", smtpServer, smtpPort)
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: smtpServer})
+	if err != nil {
+		return fmt.Errorf("无法建立SSL连接: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	client, err := smtp.NewClient(conn, smtpServer)
+	if err != nil {
+		return fmt.Errorf("创建SMTP客户端失败: %v", err)
+	}
+	defer client.Close()
+	return client.Quit()
+}
 
+func send(to mail.Address, name string, content string) error {
 	// 连接到服务器
 	addr := fmt.Sprintf("%s:%s", smtpServer, smtpPort)
-	log.Printf("尝试连接到SMTP服务器: %s", addr)
+	logger.Debug("连接SMTP服务器", "addr", addr)
 
 	// 建立SSL连接
 	tlsConfig := &tls.Config{ServerName: smtpServer}
@@ -129,6 +161,6 @@ func SendEmail(name string, content string) error {
 		return fmt.Errorf("写入邮件内容失败: %v", err)
 	}
 
-	log.Println("邮件发送成功")
+	logger.Debug("邮件发送成功", "to", to.Address)
 	return nil
 }
@@ -1,17 +1,23 @@
 package main
 
 import (
+	"bestrui/wechatpush/archive"
+	"bestrui/wechatpush/formatter"
+	"bestrui/wechatpush/logging"
 	"bestrui/wechatpush/mail"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/eatmoreapple/openwechat"
+	"bestrui/wechatpush/openwechat"
 )
 
 // 定义配置结构体
@@ -20,30 +26,80 @@ type Config struct {
 }
 
 var config Config
-var allGroups map[string]bool
-var bot *openwechat.Bot     // 将 bot 声明为全局变量
-var qrCodeUUID string       // 用于存储二维码 UUID
-var qrCodeUrl string        // 用于存储二维码 URL
-var loginSuccess bool       // 用于标记是否登录成功
-var loginMutex sync.Mutex   // 用于保护 loginSuccess 变量
-var botInitialized bool     // 用于标记 bot 是否初始化完成
-var botInitMutex sync.Mutex // 用于保护 botInitialized 变量
+
+// 当前的 bot, 重新登录时会被替换, HTTP 处理函数和指标会并发读取
+var activeBot atomic.Pointer[openwechat.Bot]
+
+var qrCodeUUID string             // 用于存储二维码 UUID
+var qrCodeUrl string              // 用于存储二维码 URL
+var loginSuccess bool             // 用于标记是否登录成功
+var loginMutex sync.Mutex         // 用于保护 loginSuccess 变量
+var botInitialized bool           // 用于标记 bot 是否初始化完成
+var botInitMutex sync.Mutex       // 用于保护 botInitialized 变量
+var messageArchive *archive.Store // 本地消息归档, 打开失败时为 nil
+
+// 无法识别的消息类型, 只记录不发送邮件
+const unknownContent = "[未知类型消息]"
+
+// messageFormatter 将各种类型的消息转换为可读的文本, 撤回消息从缓存和归档中查找原始内容
+var messageFormatter = &formatter.Formatter{Recalled: recalledContent}
+
+var (
+	mainLog    = logging.For("main")
+	botLog     = logging.For("bot")
+	forwardLog = logging.For("forward")
+	httpLog    = logging.For("http")
+)
 
 func main() {
+	// 设置日志输出, 配置无效时使用默认值
+	logConfig, err := logging.ConfigFromEnv()
+	logging.Setup(os.Stdout, logConfig)
+	if err != nil {
+		mainLog.Warn("日志配置无效, 使用默认值", "error", err)
+	}
+
+	// 导出命令, 不启动 bot 和 HTTP 服务器
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExportCommand(os.Args[2:]); err != nil {
+			mainLog.Error("导出失败", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// 检查 /app/static/index.html 文件是否存在
 	if _, err := os.Stat("/app/static/index.html"); os.IsNotExist(err) {
-		log.Fatalf("文件 /app/static/index.html 不存在")
-	} else {
-		log.Printf("文件 /app/static/index.html 存在")
+		mainLog.Error("文件 /app/static/index.html 不存在")
+		os.Exit(1)
 	}
 
-	// 设置日志输出
-	log.SetOutput(os.Stdout)
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-
 	// 从环境变量加载配置
 	loadConfigFromEnv()
 
+	// 打开本地消息归档
+	archiveDir := archiveDirFromEnv()
+	store, err := archive.Open(archiveDir)
+	if err != nil {
+		mainLog.Error("打开消息归档失败, 将不会保存消息", "error", err)
+	} else {
+		messageArchive = store
+		initArchiveRetention(store)
+		mainLog.Info("已打开消息归档", "dir", archiveDir, "records", store.Len())
+	}
+
+	// 打开媒体文件存储
+	initMediaStore()
+	initTranscriber()
+	initRecallCache()
+	initNotifyPolicy()
+	// 收到退出信号时保存内存中的数据后退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	initSubscriptions(ctx)
+	initMutes()
+	initFloodControl()
+
 	// 初始化 bot 和二维码
 	go initBotAndQRCode()
 
@@ -51,23 +107,83 @@ func main() {
 	go startHTTPServer()
 
 	// 阻塞主程序
-	select {}
+	<-ctx.Done()
+	mainLog.Info("正在退出")
+	flushSubscriptions()
+	flushRecallCache()
+	flushSeenMessages()
+}
+
+// 处理一条消息时请求微信接口的超时时间
+const messageTimeout = 2 * time.Minute
+
+// 读取消息并发处理的配置, 并发数为0时在拉取消息的goroutine中依次处理
+func botPreparersFromEnv() []openwechat.BotPreparer {
+	preparers := []openwechat.BotPreparer{openwechat.Desktop, openwechat.WithLogger(logging.For("wechat"))}
+	workers := 4
+	if value := os.Getenv("DISPATCH_WORKERS"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			botLog.Warn("环境变量 DISPATCH_WORKERS 无效, 使用默认值", "value", value, "default", workers)
+		} else {
+			workers = n
+		}
+	}
+	if workers > 0 {
+		// 每个并发最多排队100条消息, 队列满时暂停拉取消息
+		preparers = append(preparers, openwechat.WithWorkerPool(workers, 100))
+	}
+	// 记录和微信服务器之间的请求, 用于排查协议变化, 登录凭证会被脱敏
+	// 隐私模式下不记录消息和联系人的内容
+	if file := openTrafficRecordFile(); file != nil {
+		if logging.Privacy() {
+			preparers = append(preparers, openwechat.WithPrivateTrafficRecorder(file))
+		} else {
+			preparers = append(preparers, openwechat.WithTrafficRecorder(file))
+		}
+	}
+	return preparers
 }
 
+// 打开请求记录文件, 重新登录时继续写入同一个文件, 打开失败或者没有配置时返回 nil
+var openTrafficRecordFile = sync.OnceValue(func() *os.File {
+	path := os.Getenv("TRAFFIC_RECORD_FILE")
+	if path == "" {
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		botLog.Error("打开请求记录文件失败", "error", err)
+		return nil
+	}
+	botLog.Info("请求记录将写入文件", "path", path)
+	return file
+})
+
 func initBotAndQRCode() {
 	// 创建一个新的机器人实例
-	bot = openwechat.DefaultBot(openwechat.Desktop) // 初始化全局 bot 变量
+	bot := openwechat.DefaultBot(botPreparersFromEnv()...)
+	activeBot.Store(bot)
+
+	// 注册消息处理函数, 处理消息时panic不会影响后续的消息
+	dispatcher := openwechat.NewMessageMatchDispatcher()
+	dispatcher.Use(openwechat.RecoveryMiddleware(nil), openwechat.TimeoutMiddleware(messageTimeout), archiveMiddleware)
+	// 聊天中的命令, 执行后不再转发
+	newCommandRouter().Register(dispatcher)
+	dispatcher.RegisterHandler(func(*openwechat.Message) bool { return true }, func(ctx *openwechat.MessageContext) {
+		handleMessage(bot, ctx.Message)
+	})
+	bot.MessageHandler = dispatcher.AsMessageHandler()
 
-	// 注册消息处理函数
-	bot.MessageHandler = func(msg *openwechat.Message) {
-		handleMessage(bot, msg)
-	}
+	// 注册联系人同步
+	registerContactSync(bot)
+	registerBotMetrics(bot)
 
 	// 注册登录事件
 	bot.UUIDCallback = func(uuid string) {
-		log.Printf("访问下面网址扫描二维码登录: https://login.weixin.qq.com/qrcode/%s", uuid)
 		qrCodeUUID = uuid // 保存 UUID
 		qrCodeUrl = fmt.Sprintf("https://login.weixin.qq.com/qrcode/%s", qrCodeUUID)
+		botLog.Info("访问下面网址扫描二维码登录", "url", qrCodeUrl)
 	}
 
 	// 注册登录成功事件
@@ -76,7 +192,9 @@ func initBotAndQRCode() {
 		loginSuccess = true
 		qrCodeUrl = "" // 清除二维码URL
 		loginMutex.Unlock()
-		log.Println("登录成功")
+		markLogin()
+		groupMutes.Unbind()
+		botLog.Info("登录成功")
 	}
 
 	// 注册登出事件
@@ -84,7 +202,7 @@ func initBotAndQRCode() {
 		loginMutex.Lock()
 		loginSuccess = false
 		loginMutex.Unlock()
-		log.Println("已登出")
+		botLog.Info("已登出")
 		// 重新初始化bot
 		go initBotAndQRCode()
 	}
@@ -92,7 +210,7 @@ func initBotAndQRCode() {
 	// 登录
 	err := bot.Login()
 	if err != nil {
-		log.Printf("登录失败: %v", err)
+		botLog.Error("登录失败", "error", err)
 		loginMutex.Lock()
 		loginSuccess = false
 		loginMutex.Unlock()
@@ -102,99 +220,99 @@ func initBotAndQRCode() {
 	// 获取登陆的用户
 	self, err := bot.GetCurrentUser()
 	if err != nil {
-		log.Printf("获取当前用户失败: %v", err)
+		botLog.Error("获取当前用户失败", "error", err)
 		return
 	}
-	log.Printf("登录成功: %s", self.NickName)
-
-	// 初始化群组列表
-	allGroups = make(map[string]bool)
-	updateGroupList(bot, self)
+	botLog.Info("当前用户", "name", self.NickName)
 
-	// 启动定时任务，每隔一段时间更新群组列表
-	go func() {
-		ticker := time.NewTicker(5 * time.Minute) // 每5分钟更新一次
-		defer ticker.Stop()
-		for range ticker.C {
-			updateGroupList(bot, self)
-		}
-	}()
+	// 初始化群组列表, 之后根据联系人变更更新
+	refreshGroupList(bot)
 
 	botInitMutex.Lock()
 	botInitialized = true
 	botInitMutex.Unlock()
 }
 
+// 所有消息都先统计和归档, 包括聊天命令、自己发送的、重复的和公众号的消息
+func archiveMiddleware(ctx *openwechat.MessageContext) {
+	countMessage(ctx.Message)
+	info := describeMessage(ctx.Message)
+	archiveMessage(ctx.Message, info.conversation, info.sender, info.content)
+	ctx.Next()
+}
+
 func handleMessage(bot *openwechat.Bot, msg *openwechat.Message) {
+	info := describeMessage(msg)
+	conversation, sender, partiesErr := info.conversation, info.sender, info.partiesErr
+	content, known := info.content, info.known
+
 	if msg.IsSendBySelf() {
+		// 自己在会话中回复了消息, 视为已经确认
+		if escalator != nil {
+			escalator.AckConversation(msg.ToUserName)
+		}
+		forwardDecisions.Inc("self")
+		return
+	}
+	if isDuplicateMessage(msg) {
+		forwardLog.Debug("忽略重复的消息", "msg_id", msg.MsgId)
+		forwardDecisions.Inc("duplicate")
+		return
+	}
+	if msg.IsRecalled() {
+		handleRecall(msg)
+		forwardDecisions.Inc("recall")
+		return
+	}
+	if !msg.IsSendByFriend() && !msg.IsSendByGroup() {
+		forwardLog.Debug("未知的消息发送者类型,视为公众号消息,屏蔽", "msg_id", msg.MsgId)
+		forwardDecisions.Inc("blocked_sender")
+		return
+	}
+	if partiesErr != nil {
+		forwardLog.Error("获取发送者信息失败", "msg_id", msg.MsgId, "error", partiesErr)
+		forwardDecisions.Inc("sender_error")
 		return
 	}
 
-	var sender string
-	var content string
 	var groupName string
+	if msg.IsSendByGroup() {
+		groupName = conversation // 获取群名
+	}
 
-	if msg.IsSendByFriend() {
-		friendSender, err := msg.Sender()
-		if err != nil {
-			log.Printf("获取发送者信息失败: %v", err)
-			return
-		}
-		sender = friendSender.RemarkName
-		if sender == "" {
-			sender = friendSender.NickName
-		}
-	} else if msg.IsSendByGroup() {
-		group, err := msg.Sender()
-		if err != nil {
-			log.Printf("获取群聊信息失败: %v", err)
-			return
-		}
-		groupName = group.NickName // 获取群名
+	forwardLog.Info("收到消息", "msg_id", msg.MsgId, "group", groupName, "sender", sender, logging.Content("content", content))
 
-		groupSender, err := msg.SenderInGroup()
-		if err != nil {
-			log.Printf("获取群聊发送者信息失败: %v", err)
-			return
+	// 下载媒体文件, 失败时仍然发送文字通知
+	var mediaHashes []string
+	if blob := downloadMedia(msg); blob != nil {
+		mediaHashes = append(mediaHashes, blob.Hash)
+	}
+	// 语音转文字, 识别结果同时写入归档方便搜索
+	if msg.IsVoice() {
+		hash := ""
+		if len(mediaHashes) > 0 {
+			hash = mediaHashes[0]
 		}
-		// 优先使用群成员的备注名
-		sender = groupSender.RemarkName
-		if sender == "" {
-			sender = groupSender.NickName
+		if text := transcribeVoice(msg, hash); text != "" {
+			content += "\n[转写] " + text
 		}
-	} else {
-		log.Println("未知的消息发送者类型,视为公众号消息,屏蔽")
-		return
 	}
-
-	switch {
-	case msg.IsText():
-		content = msg.Content
-	case msg.IsPicture():
-		content = "[图片]"
-	case msg.IsVoice():
-		content = "[语音]"
-	case msg.IsVideo():
-		content = "[视频]"
-	case msg.IsEmoticon():
-		content = "[动画表情]"
-	default:
-		content = "[未知类型消息]"
-	}
-
-	log.Printf("%s: %s", sender, content)
+	notification := content
+	for _, hash := range mediaHashes {
+		notification += "\n" + mediaLink(hash)
+	}
 
 	// 判断是否发送邮件
 	shouldSendEmail := false
 	if msg.IsSendByGroup() {
 		// 检查群组是否在通讯录中
-		_, ok := allGroups[groupName]
+		_, ok := contactCache.Get(msg.FromUserName)
 		if ok {
 			// 如果群组在通讯录中，所有消息都发送邮件
 			shouldSendEmail = true
 		} else {
-			// 如果群组不在通讯录中，只在@所有人的情况下发送邮件
-			if msg.IsText() && strings.Contains(msg.Content, "@所有人") {
+			// 如果群组不在通讯录中，只在@所有人或者@我的情况下发送邮件
+			if mentions := msg.Mentions(); mentions.All() || mentions.Me() {
 				shouldSendEmail = true
 			}
 		}
@@ -203,17 +321,213 @@ func handleMessage(bot *openwechat.Bot, msg *openwechat.Message) {
 		shouldSendEmail = true
 	}
 
-	if shouldSendEmail && content != "[未知类型消息]" {
-		for i := 0; i < 3; i++ { // 重试3次
-			if err := mail.SendEmail(sender, content); err != nil {
-				log.Printf("发送邮件失败 (尝试 %d/3): %v", i+1, err)
-				time.Sleep(time.Second * 2) // 等待2秒后重试
-			} else {
-				log.Printf("邮件发送成功: %s - %s", sender, content)
-				return
-			}
+	// 通过 /mute 命令屏蔽的群
+	muted := msg.IsSendByGroup() && isGroupMuted(msg.FromUserName, groupName)
+	if muted {
+		shouldSendEmail = false
+	}
+
+	// 记录是否转发及原因
+	decision := "email"
+	switch {
+	case !known:
+		decision = "unknown_type"
+	case muted:
+		decision = "muted"
+	case !shouldSendEmail:
+		decision = "not_mentioned"
+	}
+	// 根据免打扰时间、工作时间等规则再次判断
+	mailNotification := notification
+	if shouldSendEmail && known {
+		shouldSendEmail, mailNotification = applyNotifyPolicy(msg, conversation, sender, notification)
+		if !shouldSendEmail {
+			decision = "policy"
 		}
-		log.Printf("发送邮件失败，已达到最大重试次数")
+	}
+	// 限流, @我的消息不限制, 超出的消息汇总后再通知
+	if shouldSendEmail && known && !msg.Mentions().Me() && !allowNotification(groupName, sender) {
+		shouldSendEmail = false
+		decision = "flood_limited"
+	}
+	forwardDecisions.Inc(decision)
+
+	rememberMessage(msg, groupName, sender, content, mediaHashes, shouldSendEmail && known)
+	updateArchivedMessage(msg, content, mediaHashes, shouldSendEmail && known)
+
+	if shouldSendEmail && known {
+		sendNotification(sender, mailNotification)
+	}
+
+	// 关键词订阅与是否转发整个群无关
+	notifySubscribers(msg, conversation, sender, notification)
+}
+
+// 发送邮件通知, 失败时重试
+func sendNotification(sender, notification string) {
+	if err := retrySend(func() error { return mail.SendEmail(sender, notification) }); err != nil {
+		forwardLog.Error("发送邮件失败，已达到最大重试次数", "sender", sender, "error", err)
+		return
+	}
+	forwardLog.Info("邮件发送成功", "sender", sender, logging.Content("content", notification))
+}
+
+// 发送邮件, 失败时等待2秒后重试, 最多3次
+func retrySend(send func() error) error {
+	var err error
+	for i := 0; i < 3; i++ { // 重试3次
+		if err = timedSend(send); err == nil {
+			return nil
+		}
+		forwardLog.Warn("发送邮件失败", "attempt", i+1, "max_attempts", 3, "error", err)
+		if i < 2 {
+			time.Sleep(time.Second * 2) // 等待2秒后重试
+		}
+	}
+	return err
+}
+
+// 消息归档目录
+func archiveDirFromEnv() string {
+	if dir := os.Getenv("ARCHIVE_DIR"); dir != "" {
+		return dir
+	}
+	return "/app/data/archive"
+}
+
+// 读取归档的保留时间和数量, 启动时清理一次, 之后每小时清理一次
+func initArchiveRetention(store *archive.Store) {
+	maxAge := 365 * 24 * time.Hour
+	if value := os.Getenv("ARCHIVE_RETENTION"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			mainLog.Warn("解析环境变量 ARCHIVE_RETENTION 失败, 使用默认值", "default", maxAge.String(), "error", err)
+		} else {
+			maxAge = d
+		}
+	}
+	maxRecords := 200000
+	if value := os.Getenv("ARCHIVE_MAX_RECORDS"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			mainLog.Warn("环境变量 ARCHIVE_MAX_RECORDS 无效, 使用默认值", "value", value, "default", maxRecords)
+		} else {
+			maxRecords = n
+		}
+	}
+	store.SetRetention(maxAge, maxRecords)
+	if removed, err := store.Sweep(time.Now()); err != nil {
+		mainLog.Error("清理归档消息失败", "error", err)
+	} else if removed > 0 {
+		mainLog.Info("已清理过期的归档消息", "removed", removed)
+	}
+	go store.RunSweeper(context.Background(), time.Hour)
+}
+
+// 获取用户的显示名称, 优先使用备注名
+func displayName(user *openwechat.User) string {
+	name := user.RemarkName
+	if name == "" {
+		name = user.NickName
+	}
+	return openwechat.FormatEmoji(name)
+}
+
+const messageInfoKey = "wechatpush.messageInfo"
+
+// 消息的会话、发送者和格式化后的内容, 归档和转发共用
+type messageInfo struct {
+	conversation string
+	sender       string
+	partiesErr   error
+	content      string
+	known        bool
+}
+
+// 获取消息的会话、发送者和内容, 结果保存在消息上, 同一条消息只计算一次
+func describeMessage(msg *openwechat.Message) *messageInfo {
+	if value, ok := msg.Get(messageInfoKey); ok {
+		return value.(*messageInfo)
+	}
+	info := &messageInfo{}
+	info.conversation, info.sender, info.partiesErr = messageParties(msg)
+	info.content, info.known = messageFormatter.Format(msg)
+	if !info.known {
+		info.content = unknownContent
+	}
+	msg.Set(messageInfoKey, info)
+	return info
+}
+
+// 获取消息所在的会话和发送者的名称, 群消息的会话为群名
+// 出错时仍然返回用 UserName 代替的名称, 用于归档
+func messageParties(msg *openwechat.Message) (conversation, sender string, err error) {
+	if msg.IsSendBySelf() {
+		sender = displayName(msg.Owner().User)
+		receiver, err := msg.Receiver()
+		if err != nil {
+			return msg.ToUserName, sender, err
+		}
+		if msg.IsSendByGroup() {
+			return receiver.NickName, sender, nil
+		}
+		return displayName(receiver), sender, nil
+	}
+	user, err := msg.Sender()
+	if err != nil {
+		return msg.FromUserName, msg.FromUserName, err
+	}
+	if !msg.IsSendByGroup() {
+		name := displayName(user)
+		return name, name, nil
+	}
+	member, err := msg.SenderInGroup()
+	if err != nil {
+		return user.NickName, "", err
+	}
+	return user.NickName, displayName(member), nil
+}
+
+// 将消息保存到本地归档, 重复收到的消息只保存一次
+func archiveMessage(msg *openwechat.Message, conversation, sender, content string) {
+	if messageArchive == nil {
+		return
+	}
+	if _, exist := messageArchive.Get(msg.MsgId); exist {
+		return
+	}
+	record := &archive.Record{
+		MsgId:          msg.MsgId,
+		NewMsgId:       msg.NewMsgId,
+		ConversationID: msg.FromUserName,
+		Conversation:   conversation,
+		IsGroup:        msg.IsSendByGroup(),
+		Sender:         sender,
+		Type:           int(msg.MsgType),
+		Content:        content,
+		CreateTime:     time.Unix(msg.CreateTime, 0),
+		Raw:            json.RawMessage(msg.Raw),
+	}
+	if msg.IsSendBySelf() {
+		record.ConversationID = msg.ToUserName
+	}
+	if err := messageArchive.Add(record); err != nil {
+		forwardLog.Error("归档消息失败", "msg_id", msg.MsgId, "error", err)
+	}
+}
+
+// 补充归档消息下载好的媒体文件、语音转写和是否发送了通知
+func updateArchivedMessage(msg *openwechat.Message, content string, media []string, notified bool) {
+	if messageArchive == nil || len(media) == 0 && !msg.IsVoice() && !notified {
+		return
+	}
+	err := messageArchive.Update(msg.MsgId, func(record *archive.Record) {
+		record.Content = content
+		record.Media = media
+		record.Notified = notified
+	})
+	if err != nil {
+		forwardLog.Error("更新归档消息失败", "msg_id", msg.MsgId, "error", err)
 	}
 }
 
@@ -227,7 +541,8 @@ func loadConfigFromEnv() {
 
 	err := json.Unmarshal([]byte(blockedGroupsJSON), &config.BlockedGroups)
 	if err != nil {
-		log.Fatalf("解析环境变量 BLOCKED_GROUPS 失败: %v", err)
+		mainLog.Error("解析环境变量 BLOCKED_GROUPS 失败", "error", err)
+		os.Exit(1)
 	}
 }
 
@@ -235,41 +550,22 @@ func loadConfigFromEnv() {
 func saveConfigToEnv() {
 	blockedGroupsJSON, err := json.Marshal(config.BlockedGroups)
 	if err != nil {
-		log.Fatalf("序列化配置失败: %v", err)
+		mainLog.Error("序列化配置失败", "error", err)
+		os.Exit(1)
 	}
 
 	os.Setenv("BLOCKED_GROUPS", string(blockedGroupsJSON))
-	log.Printf("已将配置保存到环境变量 BLOCKED_GROUPS: %s", string(blockedGroupsJSON))
+	mainLog.Info("已将配置保存到环境变量 BLOCKED_GROUPS", "blocked_groups", config.BlockedGroups)
 }
 
 func startHTTPServer() {
-	var self *openwechat.Self
 	// 获取当前用户所在的群组列表的 API 接口
 	http.HandleFunc("/groups", func(w http.ResponseWriter, r *http.Request) {
-		if self == nil {
-			log.Printf("self 对象为空")
-			http.Error(w, "获取群组列表失败", http.StatusInternalServerError)
-			return
-		}
-
-		// 获取当前用户所在的群组列表, 强制更新群组列表
-		// 等待一段时间，以便让微信服务器有时间同步群组信息
-		time.Sleep(2 * time.Second)
-		groups, err := self.Groups(true)
-		if err != nil {
-			log.Printf("获取群组列表失败: %v", err)
-			http.Error(w, "获取群组列表失败", http.StatusInternalServerError)
-			return
-		}
-
-		log.Printf("获取到的群组列表: %v", groups)
-
 		groupList := make([]map[string]string, 0)
-		for _, group := range groups {
-			log.Printf("群组: %v", group)
+		for _, group := range contactCache.Groups() {
 			groupList = append(groupList, map[string]string{
-				"name": group.NickName,
-				"id":   group.NickName,
+				"name": group.Name(),
+				"id":   group.UserName,
 			})
 		}
 
@@ -280,8 +576,8 @@ func startHTTPServer() {
 	// 获取当前可以接收消息的群组列表
 	http.HandleFunc("/active-groups", func(w http.ResponseWriter, r *http.Request) {
 		activeGroups := make([]string, 0)
-		for groupName := range allGroups {
-			activeGroups = append(activeGroups, groupName)
+		for _, group := range contactCache.Groups() {
+			activeGroups = append(activeGroups, group.Name())
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -314,6 +610,7 @@ func startHTTPServer() {
 			return
 		}
 
+		bot := activeBot.Load()
 		if bot == nil {
 			json.NewEncoder(w).Encode(map[string]interface{}{
 				"isLogged":  false,
@@ -339,6 +636,93 @@ func startHTTPServer() {
 		})
 	})
 
+	// 检索归档的消息
+	http.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		if !checkPagePassword(w, r) {
+			return
+		}
+		if messageArchive == nil {
+			http.Error(w, "消息归档未启用", http.StatusServiceUnavailable)
+			return
+		}
+		params := r.URL.Query()
+		since, err := parseQueryTime(params.Get("since"))
+		if err != nil {
+			http.Error(w, "无效的 since 参数", http.StatusBadRequest)
+			return
+		}
+		limit, _ := strconv.Atoi(params.Get("limit"))
+		if limit <= 0 || limit > 500 {
+			limit = 100
+		}
+		records := messageArchive.Search(archive.Query{
+			Text:         params.Get("q"),
+			Conversation: params.Get("group"),
+			Sender:       params.Get("from"),
+			Since:        since,
+			Limit:        limit,
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(records)
+	})
+
+	// 获取某个会话的消息时间线
+	http.HandleFunc("/messages/timeline", func(w http.ResponseWriter, r *http.Request) {
+		if !checkPagePassword(w, r) {
+			return
+		}
+		if messageArchive == nil {
+			http.Error(w, "消息归档未启用", http.StatusServiceUnavailable)
+			return
+		}
+		params := r.URL.Query()
+		conversation := params.Get("conversation")
+		if conversation == "" {
+			http.Error(w, "缺少 conversation 参数", http.StatusBadRequest)
+			return
+		}
+		before := params.Get("before") // 上一页第一条消息的 MsgId
+		limit, _ := strconv.Atoi(params.Get("limit"))
+		if limit <= 0 || limit > 500 {
+			limit = 50
+		}
+		records := messageArchive.Timeline(conversation, before, limit)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(records)
+	})
+
+	// 获取归档中的所有会话
+	http.HandleFunc("/conversations", func(w http.ResponseWriter, r *http.Request) {
+		if !checkPagePassword(w, r) {
+			return
+		}
+		if messageArchive == nil {
+			http.Error(w, "消息归档未启用", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(messageArchive.Conversations())
+	})
+
+	// 下载媒体文件
+	http.HandleFunc("/media/", serveMedia)
+
+	// 导出会话记录
+	http.HandleFunc("/export", serveExport)
+
+	// 确认通知, 停止升级提醒
+	http.HandleFunc("/ack", serveAck)
+
+	// 管理关键词订阅
+	http.HandleFunc("/subscriptions", serveSubscriptions)
+
+	// Prometheus 指标
+	http.Handle("/metrics", metricsRegistry.Handler())
+
+	// 健康检查, /healthz 表示进程在运行, /readyz 表示 bot 在线并且可以转发消息
+	http.HandleFunc("/healthz", serveHealthz)
+	http.HandleFunc("/readyz", serveReadyz)
+
 	// 获取当前的配置信息
 	http.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -356,7 +740,7 @@ func startHTTPServer() {
 	// 验证密码接口
 	http.HandleFunc("/verify-password", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		
+
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -397,13 +781,13 @@ func startHTTPServer() {
 			// 验证密码
 			password := os.Getenv("PAGE_PASSWORD")
 			if password == "" {
-				log.Println("环境变量 PAGE_PASSWORD 未设置")
+				httpLog.Error("环境变量 PAGE_PASSWORD 未设置")
 				http.Error(w, "服务器错误", http.StatusInternalServerError)
 				return
 			}
 			inputPassword := r.URL.Query().Get("password")
 			if inputPassword != password {
-				log.Printf("密码错误")
+				httpLog.Warn("密码错误", "path", r.URL.Path, "remote", r.RemoteAddr)
 				http.Error(w, "{\"error\": \"密码错误\"}", http.StatusUnauthorized)
 				return
 			}
@@ -430,25 +814,46 @@ func startHTTPServer() {
 		port = "8080"
 	}
 
-	log.Printf("启动HTTP服务器在端口 %s", port)
+	httpLog.Info("启动HTTP服务器", "port", port)
 	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatal("HTTP服务器启动失败:", err)
+		httpLog.Error("HTTP服务器启动失败", "error", err)
+		os.Exit(1)
 	}
 }
 
-func updateGroupList(bot *openwechat.Bot, self *openwechat.Self) {
-	// 获取当前用户所在的群组列表, 强制更新群组列表
-	// 等待一段时间，以便让微信服务器有时间同步群组信息
-	time.Sleep(2 * time.Second)
-	groups, err := self.Groups(true)
-	if err != nil {
-		log.Printf("获取群组列表失败: %v", err)
-		return
+// 校验页面密码, 密码通过 password 查询参数或 X-Page-Password 请求头传入
+func checkPagePassword(w http.ResponseWriter, r *http.Request) bool {
+	password := os.Getenv("PAGE_PASSWORD")
+	if password == "" {
+		httpLog.Error("环境变量 PAGE_PASSWORD 未设置")
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return false
+	}
+	inputPassword := r.Header.Get("X-Page-Password")
+	if inputPassword == "" {
+		inputPassword = r.URL.Query().Get("password")
+	}
+	if inputPassword != password {
+		http.Error(w, "{\"error\": \"密码错误\"}", http.StatusUnauthorized)
+		return false
 	}
+	return true
+}
 
-	allGroups = make(map[string]bool) // 清空之前的群组列表
-	for _, group := range groups {
-		allGroups[group.NickName] = true
+// 解析查询参数中的时间, 支持 RFC3339、日期和 Unix 时间戳
+func parseQueryTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	unix, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
 	}
-	log.Printf("已更新群组列表: %v", allGroups)
+	return time.Unix(unix, 0), nil
 }
@@ -0,0 +1,170 @@
+package media
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"bestrui/wechatpush/logging"
+)
+
+var logger = logging.For("media")
+
+// ErrInvalidHash 非法的文件哈希
+var ErrInvalidHash = errors.New("invalid media hash")
+
+// ErrTooLarge 文件超过大小限制
+var ErrTooLarge = errors.New("media too large")
+
+// Blob 已保存的媒体文件
+type Blob struct {
+	Hash string // 文件内容的 sha256
+	Size int64
+	Path string
+}
+
+// Store 按内容寻址的媒体文件存储
+// 文件保存在 dir/ab/cd/abcd... 下, 相同内容的文件只会保存一份
+type Store struct {
+	dir       string
+	retention time.Duration
+}
+
+// NewStore 创建媒体存储, retention 为文件的保留时间, 小于等于0表示永久保留
+func NewStore(dir string, retention time.Duration) (*Store, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, retention: retention}, nil
+}
+
+// Path 返回哈希对应的文件路径
+func (s *Store) Path(hash string) (string, error) {
+	if !validHash(hash) {
+		return "", ErrInvalidHash
+	}
+	return filepath.Join(s.dir, hash[0:2], hash[2:4], hash), nil
+}
+
+// Put 保存文件内容, 如果已经存在相同内容的文件则直接复用
+func (s *Store) Put(reader io.Reader) (*Blob, error) {
+	return s.PutLimit(reader, 0)
+}
+
+// PutLimit 和 Put 相同, 但是内容超过 limit 字节时不保存并返回 ErrTooLarge, limit 小于等于0表示不限制
+func (s *Store) PutLimit(reader io.Reader, limit int64) (*Blob, error) {
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, "tmp"), "blob-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if limit > 0 {
+		// 多读一个字节用来判断是否超过限制
+		reader = io.LimitReader(reader, limit+1)
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), reader)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && size > limit {
+		return nil, ErrTooLarge
+	}
+	if size == 0 {
+		return nil, errors.New("empty media content")
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	path, _ := s.Path(hash)
+	blob := &Blob{Hash: hash, Size: size, Path: path}
+
+	// 已经存在, 刷新一下修改时间, 让它重新计算保留时间
+	if _, err = os.Stat(path); err == nil {
+		now := time.Now()
+		return blob, os.Chtimes(path, now, now)
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// Open 打开哈希对应的文件
+func (s *Store) Open(hash string) (*os.File, error) {
+	path, err := s.Path(hash)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Sweep 删除超过保留时间的文件, 返回删除的文件数量
+func (s *Store) Sweep(now time.Time) (int, error) {
+	if s.retention <= 0 {
+		return 0, nil
+	}
+	deadline := now.Add(-s.retention)
+	var removed int
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Before(deadline) {
+			if err = os.Remove(path); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// RunSweeper 定期清理过期的文件, 直到 ctx 结束
+func (s *Store) RunSweeper(ctx context.Context, interval time.Duration) {
+	if s.retention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			removed, err := s.Sweep(now)
+			if err != nil {
+				logger.Error("清理过期媒体文件失败", "error", err)
+			} else if removed > 0 {
+				logger.Info("已清理过期媒体文件", "removed", removed)
+			}
+		}
+	}
+}
+
+func validHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
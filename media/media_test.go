@@ -0,0 +1,85 @@
+package media
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStorePutDeduplicates(t *testing.T) {
+	store, err := NewStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := store.Put(strings.NewReader("picture"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.Put(strings.NewReader("picture"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Hash != second.Hash || first.Path != second.Path {
+		t.Errorf("same content stored twice: %v %v", first, second)
+	}
+	file, err := store.Open(first.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	data, _ := io.ReadAll(file)
+	if string(data) != "picture" {
+		t.Errorf("unexpected content %q", data)
+	}
+	if _, err = store.Open("../../etc/passwd"); err != ErrInvalidHash {
+		t.Errorf("expected ErrInvalidHash, got %v", err)
+	}
+}
+
+func TestStorePutLimit(t *testing.T) {
+	store, err := NewStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.PutLimit(strings.NewReader("12345"), 5); err != nil {
+		t.Errorf("content at the limit: %v", err)
+	}
+	if _, err = store.PutLimit(strings.NewReader("123456"), 5); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("got %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestStoreSweep(t *testing.T) {
+	store, err := NewStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := store.Put(strings.NewReader("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := store.Put(strings.NewReader("fresh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-2 * time.Hour)
+	if err = os.Chtimes(old.Path, past, past); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := store.Sweep(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("removed %d files, want 1", removed)
+	}
+	if _, err = os.Stat(old.Path); !os.IsNotExist(err) {
+		t.Error("expired blob still exists")
+	}
+	if _, err = os.Stat(fresh.Path); err != nil {
+		t.Error("fresh blob removed")
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bestrui/wechatpush/logging"
+	"bestrui/wechatpush/media"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"bestrui/wechatpush/openwechat"
+)
+
+var mediaLog = logging.For("media")
+
+var mediaStore *media.Store // 媒体文件存储, 打开失败时为 nil
+
+// 下载单个媒体文件的超时时间, 避免阻塞后续的邮件通知
+const mediaDownloadTimeout = 30 * time.Second
+
+// 超过这个大小的媒体文件不保存, 邮件中只有文字通知
+const maxMediaSize = 100 << 20
+
+// 媒体文件存储目录
+func mediaDirFromEnv() string {
+	if dir := os.Getenv("MEDIA_DIR"); dir != "" {
+		return dir
+	}
+	return "/app/data/media"
+}
+
+// 初始化媒体文件存储
+func initMediaStore() {
+	mediaDir := mediaDirFromEnv()
+	retention := 30 * 24 * time.Hour
+	if value := os.Getenv("MEDIA_RETENTION"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			mediaLog.Warn("解析环境变量 MEDIA_RETENTION 失败, 使用默认值", "default", retention.String(), "error", err)
+		} else {
+			retention = d
+		}
+	}
+	store, err := media.NewStore(mediaDir, retention)
+	if err != nil {
+		mediaLog.Error("打开媒体文件存储失败, 将不会下载媒体文件", "error", err)
+		return
+	}
+	mediaStore = store
+	go store.RunSweeper(context.Background(), time.Hour)
+	mediaLog.Info("已打开媒体文件存储", "dir", mediaDir, "retention", retention.String())
+}
+
+// 下载消息中的媒体文件, 失败时返回 nil
+func downloadMedia(msg *openwechat.Message) *media.Blob {
+	if mediaStore == nil || !msg.HasFile() {
+		return nil
+	}
+	// 只在下载时使用超时的 context, 返回时恢复, 之后的处理仍然可以使用消息原来的 context
+	parent := msg.Context()
+	ctx, cancel := context.WithTimeout(parent, mediaDownloadTimeout)
+	defer cancel()
+	msg.WithContext(ctx)
+	defer msg.WithContext(parent)
+
+	var (
+		resp *http.Response
+		err  error
+	)
+	switch {
+	case msg.IsPicture() || msg.IsEmoticon():
+		resp, err = msg.GetPicture()
+	case msg.IsVoice():
+		resp, err = msg.GetVoice()
+	case msg.IsVideo():
+		resp, err = msg.GetVideo()
+	default:
+		resp, err = msg.GetFile()
+	}
+	if err == nil && resp.StatusCode >= http.StatusBadRequest {
+		_ = resp.Body.Close()
+		err = errors.New(resp.Status)
+	}
+	if err != nil {
+		mediaLog.Error("下载媒体文件失败", "msg_id", msg.MsgId, "error", err)
+		return nil
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	blob, err := mediaStore.PutLimit(resp.Body, maxMediaSize)
+	if errors.Is(err, media.ErrTooLarge) {
+		mediaLog.Warn("媒体文件过大, 不保存", "msg_id", msg.MsgId, "limit", maxMediaSize)
+		return nil
+	}
+	if err != nil {
+		mediaLog.Error("保存媒体文件失败", "msg_id", msg.MsgId, "error", err)
+		return nil
+	}
+	return blob
+}
+
+// 媒体文件的访问链接
+func mediaLink(hash string) string {
+	return publicLink("/media/" + hash)
+}
+
+// 邮件中的链接, 配置了 MEDIA_BASE_URL 时返回完整的链接
+func publicLink(path string) string {
+	base := strings.TrimSuffix(os.Getenv("MEDIA_BASE_URL"), "/")
+	return base + path
+}
+
+// 提供媒体文件下载
+// 文件按 sha256 寻址, 链接本身难以猜测, 因此不要求页面密码, 方便直接从邮件中打开
+func serveMedia(w http.ResponseWriter, r *http.Request) {
+	if mediaStore == nil {
+		http.NotFound(w, r)
+		return
+	}
+	hash := strings.TrimPrefix(r.URL.Path, "/media/")
+	file, err := mediaStore.Open(hash)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer func() { _ = file.Close() }()
+	stat, err := file.Stat()
+	if err != nil {
+		http.Error(w, "读取媒体文件失败", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, hash, stat.ModTime(), file)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bestrui/wechatpush/metrics"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"bestrui/wechatpush/openwechat"
+)
+
+// 通过 /metrics 输出的指标
+var metricsRegistry = metrics.NewRegistry()
+
+var (
+	syncCheckDuration = metricsRegistry.NewHistogram("wechat_synccheck_duration_seconds",
+		"synccheck 长轮询的耗时", []float64{0.1, 0.5, 1, 5, 10, 20, 25, 30, 35})
+	syncCheckResults = metricsRegistry.NewCounter("wechat_synccheck_total",
+		"synccheck 的返回结果", "retcode", "selector")
+	messagesReceived = metricsRegistry.NewCounter("wechat_messages_received_total",
+		"收到的消息数量, 按消息类型区分", "type")
+	forwardDecisions = metricsRegistry.NewCounter("wechat_forward_decisions_total",
+		"消息是否转发到邮件及原因", "decision")
+	httpRetries = metricsRegistry.NewCounter("wechat_http_retries_total",
+		"请求微信接口失败后的重试次数", "endpoint")
+	mailSendDuration = metricsRegistry.NewHistogram("mail_send_duration_seconds",
+		"每次发送邮件的耗时, 包含失败的尝试", nil, "result")
+	mailSendFailures = metricsRegistry.NewCounter("mail_send_failures_total",
+		"发送邮件失败的次数, 每次尝试都会计数")
+)
+
+// 最近一次登录成功的时间, 未登录时为0
+var loginTime atomic.Int64
+
+func init() {
+	metricsRegistry.NewGaugeFunc("wechat_logged_in", "是否已经登录, 1为已登录", func() float64 {
+		if isBotAlive() {
+			return 1
+		}
+		return 0
+	})
+	metricsRegistry.NewGaugeFunc("wechat_session_age_seconds", "本次登录已经持续的时间", func() float64 {
+		since := loginTime.Load()
+		if since == 0 || !isBotAlive() {
+			return 0
+		}
+		return time.Since(time.Unix(0, since)).Seconds()
+	})
+	metricsRegistry.NewGaugeFunc("wechat_dispatch_queue_depth", "等待处理的消息数量", func() float64 {
+		if current := activeBot.Load(); current != nil {
+			if stats, ok := current.WorkerPoolStats(); ok {
+				return float64(stats.Pending)
+			}
+		}
+		return 0
+	})
+}
+
+// 判断 bot 是否已经登录并且在线
+func isBotAlive() bool {
+	loginMutex.Lock()
+	logged := loginSuccess
+	loginMutex.Unlock()
+	current := activeBot.Load()
+	return logged && current != nil && current.Alive()
+}
+
+// 登录成功时记录时间, 用于计算会话时长
+func markLogin() {
+	loginTime.Store(time.Now().UnixNano())
+}
+
+// 统计 synccheck 耗时和重试次数的请求钩子
+type metricsHook struct {
+	syncCheckStart atomic.Int64 // 同一时间只有一个 synccheck 请求
+}
+
+func (h *metricsHook) BeforeRequest(req *http.Request) {
+	if strings.HasSuffix(req.URL.Path, "/synccheck") {
+		h.syncCheckStart.Store(time.Now().UnixNano())
+	}
+}
+
+func (h *metricsHook) AfterRequest(*http.Response, error) {}
+
+func (h *metricsHook) OnRetry(req *http.Request, _ int, _ time.Duration, _ *http.Response, _ error) {
+	httpRetries.Inc(req.URL.Path)
+}
+
+// 注册 synccheck 和重试的统计, 需要在其他心跳回调之后注册
+func registerBotMetrics(bot *openwechat.Bot) {
+	hook := &metricsHook{}
+	bot.Caller.Client.AddHttpHook(hook)
+	// 保留联系人同步等已经注册的心跳回调
+	next := bot.SyncCheckCallback
+	bot.SyncCheckCallback = func(resp openwechat.SyncCheckResponse) {
+		if start := hook.syncCheckStart.Load(); start != 0 {
+			syncCheckDuration.Observe(time.Since(time.Unix(0, start)).Seconds())
+		}
+		syncCheckResults.Inc(resp.RetCode, string(resp.Selector))
+		recordSyncCheck(resp)
+		if next != nil {
+			next(resp)
+		}
+	}
+}
+
+// 统计收到的消息
+func countMessage(msg *openwechat.Message) {
+	messagesReceived.Inc(strconv.Itoa(int(msg.MsgType)))
+}
+
+// 发送一次邮件并统计耗时和失败次数
+func timedSend(send func() error) error {
+	start := time.Now()
+	err := send()
+	result := "success"
+	if err != nil {
+		result = "failure"
+		mailSendFailures.Inc()
+	}
+	mailSendDuration.Observe(time.Since(start).Seconds(), result)
+	return err
+}
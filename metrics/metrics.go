@@ -0,0 +1,282 @@
+// Package metrics 以 Prometheus 文本格式输出指标, 不依赖 Prometheus 客户端库
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets 默认的直方图区间, 单位为秒
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type metric interface {
+	write(w io.Writer, name string)
+}
+
+type family struct {
+	name   string
+	help   string
+	kind   string
+	metric metric
+}
+
+// Registry 保存所有指标, 按名称排序输出
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry 创建一个空的 Registry
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+func (r *Registry) register(name, help, kind string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exist := r.families[name]; exist {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.families[name] = &family{name: name, help: help, kind: kind, metric: m}
+}
+
+// NewCounter 注册计数器, labels 为标签名
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec: newVec(labels)}
+	r.register(name, help, "counter", c)
+	return c
+}
+
+// NewGauge 注册可以任意设置的值
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{vec: newVec(labels)}
+	r.register(name, help, "gauge", g)
+	return g
+}
+
+// NewGaugeFunc 注册在抓取时才计算的值, 如队列长度
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(name, help, "gauge", gaugeFunc(fn))
+}
+
+// NewHistogram 注册直方图, buckets 为空时使用 DefaultBuckets
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &Histogram{vec: newVec(labels), buckets: buckets}
+	r.register(name, help, "histogram", h)
+	return h
+}
+
+// Write 以 Prometheus 文本格式写入所有指标
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+	for _, f := range families {
+		fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+		f.metric.write(w, f.name)
+	}
+}
+
+// Handler 返回输出所有指标的 http.Handler, 用于 /metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// vec 按标签值保存一组数据
+type vec struct {
+	mu     sync.Mutex
+	labels []string
+	values map[string]any // 标签值用 \xff 连接 -> 数据
+}
+
+func newVec(labels []string) vec {
+	return vec{labels: labels, values: make(map[string]any)}
+}
+
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: expected %d label values, got %d", len(v.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// sortedKeys 按标签值排序, 保证每次输出的顺序相同
+func (v *vec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labelString 格式化标签, extra 为直方图的 le 标签
+func (v *vec) labelString(key string, extra ...string) string {
+	var pairs []string
+	if len(v.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, v.labels[i]+`="`+escapeLabel(value)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter 只增不减的计数器
+type Counter struct{ vec }
+
+// Inc 加1
+func (c *Counter) Inc(labels ...string) { c.Add(1, labels...) }
+
+// Add 增加 delta, delta 不能为负数
+func (c *Counter) Add(delta float64, labels ...string) {
+	if delta < 0 {
+		panic("metrics: counter can not decrease")
+	}
+	key := c.key(labels)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, _ := c.values[key].(float64)
+	c.values[key] = value + delta
+}
+
+// Value 返回当前的值
+func (c *Counter) Value(labels ...string) float64 {
+	key := c.key(labels)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, _ := c.values[key].(float64)
+	return value
+}
+
+func (c *Counter) write(w io.Writer, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", name)
+	}
+	for _, key := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", name, c.labelString(key), formatFloat(c.values[key].(float64)))
+	}
+}
+
+// Gauge 可以任意设置的值
+type Gauge struct{ vec }
+
+// Set 设置当前的值
+func (g *Gauge) Set(value float64, labels ...string) {
+	key := g.key(labels)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[key] = value
+}
+
+func (g *Gauge) write(w io.Writer, name string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.labels) == 0 && len(g.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", name)
+	}
+	for _, key := range g.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", name, g.labelString(key), formatFloat(g.values[key].(float64)))
+	}
+}
+
+type gaugeFunc func() float64
+
+func (f gaugeFunc) write(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(f()))
+}
+
+// Histogram 统计分布, 如请求耗时
+type Histogram struct {
+	vec
+	buckets []float64
+}
+
+type histogramData struct {
+	counts []uint64 // 每个区间的数量, 不累加
+	count  uint64
+	sum    float64
+}
+
+// Observe 记录一个值
+func (h *Histogram) Observe(value float64, labels ...string) {
+	key := h.key(labels)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	data, ok := h.values[key].(*histogramData)
+	if !ok {
+		data = &histogramData{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = data
+	}
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		data.counts[i]++
+	}
+	data.count++
+	data.sum += value
+}
+
+func (h *Histogram) write(w io.Writer, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.labels) == 0 && len(h.values) == 0 {
+		h.values[""] = &histogramData{counts: make([]uint64, len(h.buckets))}
+	}
+	for _, key := range h.sortedKeys() {
+		data := h.values[key].(*histogramData)
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += data.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, h.labelString(key, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, h.labelString(key, "le", "+Inf"), data.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, h.labelString(key), formatFloat(data.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, h.labelString(key), data.count)
+	}
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(value string) string { return labelEscaper.Replace(value) }
+
+func escapeHelp(help string) string { return helpEscaper.Replace(help) }
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, registry *Registry) string {
+	t.Helper()
+	server := httptest.NewServer(registry.Handler())
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type %s", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestRegistryScrape(t *testing.T) {
+	registry := NewRegistry()
+	messages := registry.NewCounter("messages_total", "收到的消息", "type")
+	messages.Inc("text")
+	messages.Add(2, "image")
+	messages.Inc("text")
+	registry.NewCounter("failures_total", "失败次数")
+	registry.NewGauge("logged_in", "是否登录").Set(1)
+	registry.NewGaugeFunc("queue_depth", "队列长度", func() float64 { return 7 })
+	latency := registry.NewHistogram("latency_seconds", "耗时", []float64{0.1, 1})
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(3)
+	registry.NewCounter("escaped_total", "转义", "name").Inc("a\"b\\c\nd")
+
+	want := `# HELP escaped_total 转义
+# TYPE escaped_total counter
+escaped_total{name="a\"b\\c\nd"} 1
+# HELP failures_total 失败次数
+# TYPE failures_total counter
+failures_total 0
+# HELP latency_seconds 耗时
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 3.55
+latency_seconds_count 3
+# HELP logged_in 是否登录
+# TYPE logged_in gauge
+logged_in 1
+# HELP messages_total 收到的消息
+# TYPE messages_total counter
+messages_total{type="image"} 2
+messages_total{type="text"} 2
+# HELP queue_depth 队列长度
+# TYPE queue_depth gauge
+queue_depth 7
+`
+	if got := scrape(t, registry); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramLabels(t *testing.T) {
+	registry := NewRegistry()
+	latency := registry.NewHistogram("send_seconds", "发送耗时", []float64{1}, "result")
+	latency.Observe(0.5, "ok")
+	latency.Observe(2, "error")
+	got := scrape(t, registry)
+	for _, line := range []string{
+		`send_seconds_bucket{result="error",le="1"} 0`,
+		`send_seconds_bucket{result="error",le="+Inf"} 1`,
+		`send_seconds_bucket{result="ok",le="1"} 1`,
+		`send_seconds_count{result="ok"} 1`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, got)
+		}
+	}
+}
+
+func TestDuplicateMetricPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	registry := NewRegistry()
+	registry.NewCounter("a_total", "a")
+	registry.NewGauge("a_total", "a")
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bestrui/wechatpush/logging"
+	"bestrui/wechatpush/mail"
+	"bestrui/wechatpush/policy"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"bestrui/wechatpush/openwechat"
+)
+
+var notifyLog = logging.For("notify")
+
+var notifyPolicy *policy.Engine // 通知规则, 包括免打扰时间和工作时间
+var escalator *policy.Escalator // 升级提醒, 未配置第二个通知渠道时为 nil
+
+// 初始化通知规则和升级提醒
+func initNotifyPolicy() {
+	rules, err := policy.ParseRules(os.Getenv("NOTIFY_RULES"))
+	if err != nil {
+		notifyLog.Error("解析环境变量 NOTIFY_RULES 失败", "error", err)
+		os.Exit(1)
+	}
+	var workHours []string
+	if value := os.Getenv("WORK_HOURS"); value != "" {
+		workHours = strings.Split(value, ";")
+	}
+	notifyPolicy, err = policy.NewEngine(rules, policy.DefaultLocation(), workHours)
+	if err != nil {
+		notifyLog.Error("加载通知规则失败", "error", err)
+		os.Exit(1)
+	}
+	notifyLog.Info("已加载通知规则", "rules", len(rules))
+
+	notifier := escalationNotifierFromEnv()
+	if notifier == nil {
+		return
+	}
+	escalator = policy.NewEscalator(notifier)
+	go escalator.Run(context.Background(), 30*time.Second)
+}
+
+// 升级提醒使用的通知渠道, 优先使用 ESCALATION_WEBHOOK
+func escalationNotifierFromEnv() policy.Notifier {
+	if url := os.Getenv("ESCALATION_WEBHOOK"); url != "" {
+		client := &http.Client{Timeout: 10 * time.Second}
+		return policy.NotifierFunc(func(title, content string) error {
+			body, _ := json.Marshal(map[string]string{"title": title, "content": content})
+			resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			defer func() { _ = resp.Body.Close() }()
+			if resp.StatusCode >= http.StatusBadRequest {
+				return fmt.Errorf("webhook 返回 %s", resp.Status)
+			}
+			return nil
+		})
+	}
+	if address := os.Getenv("ESCALATION_EMAIL"); address != "" {
+		return policy.NotifierFunc(func(title, content string) error {
+			return timedSend(func() error { return mail.SendEmailTo(address, title, content) })
+		})
+	}
+	return nil
+}
+
+// 根据通知规则决定是否发送通知, 需要升级提醒时在通知中附加确认链接
+func applyNotifyPolicy(msg *openwechat.Message, conversation, sender, notification string) (bool, string) {
+	if notifyPolicy == nil {
+		return true, notification
+	}
+	decision := notifyPolicy.Decide(policy.Message{
+		Conversation: conversation,
+		Sender:       sender,
+		MentionsMe:   msg.Mentions().Me(),
+	})
+	if !decision.Notify {
+		notifyLog.Info("不发送通知", "rule", decision.Rule, "reason", decision.Reason)
+		return false, notification
+	}
+	if decision.EscalateAfter > 0 && escalator != nil {
+		id := escalator.Track(msg.FromUserName, sender, notification, decision.EscalateAfter)
+		notification += "\n确认: " + publicLink("/ack?id="+id)
+	}
+	return true, notification
+}
+
+// 确认通知, 停止升级提醒
+// 确认 id 是随机生成的, 与媒体文件链接一样不要求页面密码
+func serveAck(w http.ResponseWriter, r *http.Request) {
+	if escalator == nil || !escalator.Ack(r.URL.Query().Get("id")) {
+		http.Error(w, "通知不存在或已确认", http.StatusNotFound)
+		return
+	}
+	fmt.Fprint(w, "已确认")
+}
@@ -5,30 +5,40 @@ import (
 	"context"
 	"errors"
 	"io"
-	"log"
+	"log/slog"
 	"net/url"
+	"sync"
+	"sync/atomic"
 )
 
 type Bot struct {
-	ScanCallBack        func(body CheckLoginResponse) // 扫码回调,可获取扫码用户的头像
-	LoginCallBack       func(body CheckLoginResponse) // 登陆回调
-	LogoutCallBack      func(bot *Bot)                // 退出回调
-	UUIDCallback        func(uuid string)             // 获取UUID的回调函数
-	SyncCheckCallback   func(resp SyncCheckResponse)  // 心跳回调
-	MessageHandler      MessageHandler                // 获取消息成功的handle
-	MessageErrorHandler MessageErrorHandler           // 获取消息发生错误的handle, 返回err == nil 则尝试继续监听
-	Serializer          Serializer                    // 序列化器, 默认为json
-	Caller              *Caller
-	Storage             *Session
-	err                 error
-	context             context.Context
-	cancel              func()
-	self                *Self
-	hotReloadStorage    HotReloadStorage
-	uuid                string
-	loginUUID           string
-	deviceId            string // 设备Id
-	loginOptionGroup    BotOptionGroup
+	ScanCallBack         func(body CheckLoginResponse) // 扫码回调,可获取扫码用户的头像
+	LoginCallBack        func(body CheckLoginResponse) // 登陆回调
+	LogoutCallBack       func(bot *Bot)                // 退出回调
+	UUIDCallback         func(uuid string)             // 获取UUID的回调函数
+	SyncCheckCallback    func(resp SyncCheckResponse)  // 心跳回调
+	WebWxSyncCallback    func(resp *WebWxSyncResponse) // 同步消息回调, 可以获取联系人的变更
+	ContactChangeHandler ContactChangeHandler          // 联系人变更的回调, 如群改名, 成员进出群, 删除好友
+	MessageHandler       MessageHandler                // 获取消息成功的handle
+	MessageErrorHandler  MessageErrorHandler           // 获取消息发生错误的handle, 返回err == nil 则尝试继续监听
+	Serializer           Serializer                    // 序列化器, 默认为json
+	Caller               *Caller
+	Storage              *Session
+	mu                   sync.Mutex // 保护 err
+	err                  error
+	context              context.Context
+	cancel               func()
+	self                 atomic.Pointer[Self] // 处理消息的 goroutine 和退出时都会访问
+	exitOnce             sync.Once
+	exiting              atomic.Bool
+	syncDone             chan struct{} // 轮询消息的 goroutine 退出后关闭
+	workerPool           *WorkerPool   // 并发处理消息的工作池, 为空时同步调用 MessageHandler
+	logger               *slog.Logger
+	hotReloadStorage     HotReloadStorage
+	uuid                 string
+	loginUUID            string
+	deviceId             string // 设备Id
+	loginOptionGroup     BotOptionGroup
 }
 
 // Alive 判断当前用户是否正常在线
@@ -37,7 +47,7 @@ func (b *Bot) Alive() bool {
 	case <-b.context.Done():
 		return false
 	default:
-		return b.self != nil
+		return !b.exiting.Load() && b.self.Load() != nil
 	}
 }
 
@@ -49,10 +59,11 @@ func (b *Bot) Alive() bool {
 //	}
 //	fmt.Println(self.NickName)
 func (b *Bot) GetCurrentUser() (*Self, error) {
-	if b.self == nil {
+	self := b.self.Load()
+	if self == nil {
 		return nil, errors.New("user not login")
 	}
-	return b.self, nil
+	return self, nil
 }
 
 // login 这里对进行一些对登录前后的hook
@@ -146,10 +157,11 @@ func (b *Bot) webInit() error {
 		return err
 	}
 	// 设置当前的用户
-	b.self = &Self{bot: b, User: resp.User}
-	b.self.formatEmoji()
-	b.self.self = b.self
-	resp.ContactList.init(b.self)
+	self := &Self{bot: b, User: resp.User}
+	self.formatEmoji()
+	self.self = self
+	resp.ContactList.init(self)
+	b.self.Store(self)
 	// 读取和装载SyncKey
 	if b.Storage.Response != nil {
 		resp.SyncKey = b.Storage.Response.SyncKey
@@ -174,12 +186,16 @@ func (b *Bot) webInit() error {
 	}
 	// 开启协程，轮询获取是否有新的消息返回
 
+	if b.MessageErrorHandler == nil {
+		b.MessageErrorHandler = defaultMessageErrorHandler
+	}
+	done := make(chan struct{})
+	b.syncDone = done
 	go func() {
-		if b.MessageErrorHandler == nil {
-			b.MessageErrorHandler = defaultMessageErrorHandler
-		}
-		for {
-			if err = b.syncCheck(); err != nil {
+		defer close(done)
+		// 退出后 syncCheck 会直接返回, 不再继续轮询
+		for b.Alive() {
+			if err := b.syncCheck(); err != nil {
 				// 判断是否继续, 如果不继续则退出
 				if err = b.MessageErrorHandler(err); err != nil {
 					b.ExitWith(err)
@@ -192,12 +208,13 @@ func (b *Bot) webInit() error {
 }
 
 func (b *Bot) updateGroups(msg *Message) {
-	if msg.IsSendByGroup() {
-		if msg.FromUserName == msg.bot.self.User.UserName {
+	self := b.self.Load()
+	if self != nil && msg.IsSendByGroup() {
+		if msg.FromUserName == self.User.UserName {
 			return
 		}
 		// 首先尝试从缓存里面查找, 如果没有找到则从服务器获取
-		members, err := msg.bot.self.Members()
+		members, err := self.Members()
 		if err != nil {
 			return
 		}
@@ -206,8 +223,7 @@ func (b *Bot) updateGroups(msg *Message) {
 			// 找不到, 从服务器获取
 			user := newUser(msg.Owner(), msg.FromUserName)
 			_ = user.Detail()
-			b.self.members = b.self.members.Append(user)
-			b.self.groups = b.self.members.Groups()
+			self.addMember(user)
 		}
 	}
 }
@@ -257,18 +273,39 @@ func (b *Bot) syncCheck() error {
 			}
 			for _, message := range messages {
 				message.init(b)
-				// 默认同步调用
+				// 默认同步调用, 可以通过 WithWorkerPool 设置工作池并发处理
 				// 如果异步调用则需自行处理
 				// 如配合 openwechat.MessageMatchDispatcher 使用
-				// NOTE: 请确保 MessageHandler 不会阻塞，否则会导致收不到后续的消息
+				// NOTE: 同步调用时请确保 MessageHandler 不会阻塞，否则会导致收不到后续的消息
 				b.updateGroups(message)
-				b.MessageHandler(message)
+				b.handleMessage(message)
 			}
 		}
 	}
 	return err
 }
 
+// handleMessage 将消息交给 MessageHandler 处理
+// 设置了工作池时同一个会话的消息按顺序处理, 不同会话的消息并行处理
+// 工作池已经关闭时说明正在退出, 丢弃消息
+func (b *Bot) handleMessage(message *Message) {
+	if b.workerPool == nil {
+		b.MessageHandler(message)
+		return
+	}
+	if !b.workerPool.Submit(message.FromUserName, func() { b.MessageHandler(message) }) {
+		b.Logger().Debug("正在退出, 丢弃消息", "msg_id", message.MsgId)
+	}
+}
+
+// WorkerPoolStats 获取消息工作池的运行统计, 没有设置工作池时返回 false
+func (b *Bot) WorkerPoolStats() (WorkerPoolStats, bool) {
+	if b.workerPool == nil {
+		return WorkerPoolStats{}, false
+	}
+	return b.workerPool.Stats(), true
+}
+
 // 获取新的消息
 func (b *Bot) syncMessage() ([]*Message, error) {
 	opt := CallerWebWxSyncOptions{
@@ -285,12 +322,24 @@ func (b *Bot) syncMessage() ([]*Message, error) {
 	if resp.SyncKey.Count > 0 {
 		b.Storage.Response.SyncKey = resp.SyncKey
 	}
+	if b.WebWxSyncCallback != nil {
+		b.WebWxSyncCallback(resp)
+	}
+	// 先更新联系人再处理消息, 保证消息拿到的是最新的联系人信息
+	if self := b.self.Load(); self != nil {
+		changes := self.applyContactChanges(resp.ModContactList, resp.DelContactList)
+		if b.ContactChangeHandler != nil {
+			for _, change := range changes {
+				b.ContactChangeHandler(change)
+			}
+		}
+	}
 	return resp.AddMsgList, nil
 }
 
 // Block 当消息同步发生了错误或者用户主动在手机上退出，该方法会立即返回，否则会一直阻塞
 func (b *Bot) Block() error {
-	if b.self == nil {
+	if b.self.Load() == nil {
 		return errors.New("`Block` must be called after user login")
 	}
 	<-b.Context().Done()
@@ -298,22 +347,61 @@ func (b *Bot) Block() error {
 }
 
 // Exit 主动退出，让 Block 不在阻塞
+// 设置了工作池时在后台等待已经提交的消息处理完再退出, 因此可以在消息处理函数中调用
+// 只有第一次调用有效
 func (b *Bot) Exit() {
-	b.self = nil
+	b.exitWith(nil)
+}
+
+// ExitWith 主动退出并且设置退出原因, 可以通过 `CrashReason` 获取退出原因
+func (b *Bot) ExitWith(err error) {
+	b.exitWith(err)
+}
+
+func (b *Bot) exitWith(err error) {
+	b.exitOnce.Do(func() {
+		b.mu.Lock()
+		b.err = err
+		b.mu.Unlock()
+		// 停止同步消息
+		b.exiting.Store(true)
+		if b.workerPool == nil {
+			b.exit()
+			return
+		}
+		// 先等待工作池中的消息处理完, 处理消息时还需要用到当前用户和 context
+		// 在消息处理函数中调用时不能等待自己, 所以在后台等待
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), workerPoolDrainTimeout)
+			defer cancel()
+			if err := b.workerPool.Close(ctx); err != nil {
+				b.Logger().Warn("等待消息处理完成超时", "error", err)
+			}
+			b.exit()
+		}()
+	})
+}
+
+func (b *Bot) exit() {
+	b.self.Store(nil)
 	b.cancel()
 	if b.LogoutCallBack != nil {
 		b.LogoutCallBack(b)
 	}
 }
 
-// ExitWith 主动退出并且设置退出原因, 可以通过 `CrashReason` 获取退出原因
-func (b *Bot) ExitWith(err error) {
-	b.err = err
-	b.Exit()
+// Logger 返回 Bot 使用的日志, 没有通过 WithLogger 设置时使用 slog.Default()
+func (b *Bot) Logger() *slog.Logger {
+	if b.logger == nil {
+		return slog.Default()
+	}
+	return b.logger
 }
 
 // CrashReason 获取当前Bot崩溃的原因
 func (b *Bot) CrashReason() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	return b.err
 }
 
@@ -410,16 +498,16 @@ func DefaultBot(prepares ...BotPreparer) *Bot {
 	bot.UUIDCallback = PrintlnQrcodeUrl
 	// 扫码回调
 	bot.ScanCallBack = func(_ CheckLoginResponse) {
-		log.Println("扫码成功,请在手机上确认登录")
+		bot.Logger().Info("扫码成功,请在手机上确认登录")
 	}
 	// 登录回调
 	bot.LoginCallBack = func(_ CheckLoginResponse) {
-		log.Println("登录成功")
+		bot.Logger().Info("登录成功")
 	}
 	// 心跳回调函数
-	// 默认的行为打印SyncCheckResponse
+	// 默认的行为在 debug 级别打印SyncCheckResponse
 	bot.SyncCheckCallback = func(resp SyncCheckResponse) {
-		// log.Printf("RetCode:%s  Selector:%s", resp.RetCode, resp.Selector)
+		bot.Logger().Debug("synccheck", "retcode", resp.RetCode, "selector", resp.Selector)
 	}
 	for _, prepare := range prepares {
 		prepare.Prepare(bot)
@@ -433,13 +521,14 @@ func Default(prepares ...BotPreparer) *Bot {
 
 // GetQrcodeUrl 通过uuid获取登录二维码的url
 func GetQrcodeUrl(uuid string) string {
-	return qrcode + uuid
+	return qrcodeHost + qrcode + uuid
 }
 
 // PrintlnQrcodeUrl 打印登录二维码
 func PrintlnQrcodeUrl(uuid string) {
-	println("访问下面网址扫描二维码登录")
 	qrcodeUrl := GetQrcodeUrl(uuid)
-	mail.SendEmail("登录", qrcodeUrl)
-	println(qrcodeUrl)
+	if err := mail.SendEmail("登录", qrcodeUrl); err != nil {
+		slog.Warn("发送登录二维码邮件失败", "error", err)
+	}
+	slog.Info("访问下面网址扫描二维码登录", "url", qrcodeUrl)
 }
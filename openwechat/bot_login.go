@@ -2,6 +2,9 @@ package openwechat
 
 import (
 	"context"
+	"io"
+	"log/slog"
+	"runtime/debug"
 )
 
 // LoginCode 定义登录状态码
@@ -149,6 +152,41 @@ func WithDeviceID(deviceId string) BotPreparer {
 	return BotPreparerFunc(func(b *Bot) { b.deviceId = deviceId })
 }
 
+// WithWorkerPool 是一个 BotPreparerFunc，用于设置并发处理消息的工作池
+// workers 为并发处理的goroutine数量, queueSize 为每个goroutine排队的消息数量, 队列满时会暂停拉取消息
+// 同一个会话的消息按顺序处理, Bot 退出时会等待已经收到的消息处理完成
+func WithWorkerPool(workers, queueSize int) BotPreparer {
+	return BotPreparerFunc(func(b *Bot) {
+		b.workerPool = NewWorkerPool(workers, queueSize)
+		b.workerPool.PanicHandler = func(v any) {
+			b.Logger().Error("handle message panic", "panic", v, "stack", string(debug.Stack()))
+		}
+	})
+}
+
+// WithLogger 是一个 BotPreparerFunc，用于设置 Bot 和消息处理中间件使用的日志
+func WithLogger(logger *slog.Logger) BotPreparer {
+	return BotPreparerFunc(func(b *Bot) { b.logger = logger })
+}
+
+// WithTrafficRecorder 是一个 BotPreparerFunc，用于将脱敏后的请求和响应写入 w, 见 RecordTransport
+func WithTrafficRecorder(w io.Writer) BotPreparer {
+	return BotPreparerFunc(func(b *Bot) {
+		client := b.Caller.Client.HTTPClient()
+		client.Transport = NewRecordTransport(client.Transport, w)
+	})
+}
+
+// WithPrivateTrafficRecorder 和 WithTrafficRecorder 相同, 但是不记录消息和联系人的内容, 见 RecordTransport.OmitContent
+func WithPrivateTrafficRecorder(w io.Writer) BotPreparer {
+	return BotPreparerFunc(func(b *Bot) {
+		client := b.Caller.Client.HTTPClient()
+		transport := NewRecordTransport(client.Transport, w)
+		transport.OmitContent = true
+		client.Transport = transport
+	})
+}
+
 // BotLogin 定义了一个Login的接口
 type BotLogin interface {
 	Login(bot *Bot) error
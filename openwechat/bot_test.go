@@ -1,156 +1,297 @@
 package openwechat
 
 import (
-	"fmt"
+	"bytes"
+	"errors"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 func TestLogin(t *testing.T) {
-	bot := DefaultBot(Desktop)
-	bot.LoginCallBack = func(body CheckLoginResponse) {
-		t.Log("login")
-	}
+	server := newFakeWeChat(t)
+	bot := server.newBot()
+	var scanned, loggedIn bool
+	bot.ScanCallBack = func(body CheckLoginResponse) { scanned = true }
+	bot.LoginCallBack = func(body CheckLoginResponse) { loggedIn = true }
 	if err := bot.Login(); err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	if !scanned || !loggedIn {
+		t.Errorf("scanned %v, logged in %v", scanned, loggedIn)
+	}
+	self, err := bot.GetCurrentUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if self.NickName != "测试账号" || bot.Storage.LoginInfo.WxSid != "fake-sid" {
+		t.Errorf("self %s, sid %s", self.NickName, bot.Storage.LoginInfo.WxSid)
+	}
+}
+
+func TestLoginTimeout(t *testing.T) {
+	server := newFakeWeChat(t)
+	server.loginCodes = []LoginCode{LoginCodeWait, LoginCodeTimeout}
+	bot := server.newBot()
+	if err := bot.Login(); !errors.Is(err, ErrLoginTimeout) {
+		t.Errorf("got %v, want %v", err, ErrLoginTimeout)
+	}
+	if bot.Alive() {
+		t.Error("bot should not be alive")
 	}
 }
 
 func TestLogout(t *testing.T) {
-	bot := DefaultBot(Desktop)
-	bot.LoginCallBack = func(body CheckLoginResponse) {
-		t.Log("login")
+	server := newFakeWeChat(t)
+	bot := server.newBot()
+	var loggedOut atomic.Bool
+	bot.LogoutCallBack = func(bot *Bot) { loggedOut.Store(true) }
+	bot.MessageHandler = func(msg *Message) {
+		if msg.IsText() && msg.Content == "logout" {
+			_ = bot.Logout()
+		}
+	}
+	if err := bot.Login(); err != nil {
+		t.Fatal(err)
+	}
+	server.pushText("@self", "filehelper", "logout")
+	if err := bot.Block(); !errors.Is(err, ErrUserLogout) {
+		t.Errorf("got %v, want %v", err, ErrUserLogout)
 	}
-	bot.LogoutCallBack = func(bot *Bot) {
-		t.Log("logout")
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !loggedOut.Load() || !server.loggedOut {
+		t.Error("logout was not called")
 	}
+}
+
+func TestLogoutInWorkerPool(t *testing.T) {
+	server := newFakeWeChat(t)
+	bot := server.newBot(WithWorkerPool(2, 4))
 	bot.MessageHandler = func(msg *Message) {
 		if msg.IsText() && msg.Content == "logout" {
-			bot.Logout()
+			_ = bot.Logout()
 		}
 	}
 	if err := bot.Login(); err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
+	}
+	server.pushText("@self", "filehelper", "logout")
+	done := make(chan error, 1)
+	go func() { done <- bot.Block() }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrUserLogout) {
+			t.Errorf("got %v, want %v", err, ErrUserLogout)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("logout from a worker should not wait for the worker pool to drain")
+	}
+}
+
+func TestSyncCheckLoginFailed(t *testing.T) {
+	server := newFakeWeChat(t)
+	bot := server.login()
+	server.kick()
+	if err := bot.Block(); !errors.Is(err, failedLoginCheck) {
+		t.Errorf("got %v, want %v", err, failedLoginCheck)
 	}
-	bot.Block()
 }
 
 func TestMessageHandle(t *testing.T) {
-	bot := DefaultBot(Desktop)
+	server := newFakeWeChat(t)
+	server.contacts = Members{{UserName: "@friend", NickName: "小明"}}
+	bot := server.newBot()
 	bot.MessageHandler = func(msg *Message) {
 		if msg.IsText() && msg.Content == "ping" {
-			msg.ReplyText("pong")
+			if _, err := msg.ReplyText("pong"); err != nil {
+				t.Error(err)
+			}
 		}
 	}
 	if err := bot.Login(); err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
+	}
+	server.pushText("@friend", "@self", "hello")
+	server.pushText("@friend", "@self", "ping")
+	sent := server.waitSent(1)
+	if sent[0].Content != "pong" || sent[0].ToUserName != "@friend" || sent[0].FromUserName != "@self" {
+		t.Errorf("got %+v", sent[0])
 	}
-	bot.Block()
 }
 
-func TestFriends(t *testing.T) {
-	bot := DefaultBot(Desktop)
+func TestReplyToSelfSentMessage(t *testing.T) {
+	server := newFakeWeChat(t)
+	server.contacts = Members{{UserName: "@friend", NickName: "小明"}}
+	bot := server.newBot()
+	bot.MessageHandler = func(msg *Message) {
+		if msg.IsText() && msg.Content == "ping" {
+			if _, err := msg.ReplyText("pong"); err != nil {
+				t.Error(err)
+			}
+		}
+	}
 	if err := bot.Login(); err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
 	}
-	user, err := bot.GetCurrentUser()
-	if err != nil {
-		t.Error(err)
-		return
+	// 自己在和好友的聊天中发送的消息, 回复应该发给好友而不是自己
+	server.pushText("@self", "@friend", "ping")
+	sent := server.waitSent(1)
+	if sent[0].Content != "pong" || sent[0].ToUserName != "@friend" {
+		t.Errorf("got %+v", sent[0])
 	}
-	friends, err := user.Friends()
-	if err != nil {
-		t.Error(err)
-		return
-	}
-	t.Log(friends)
 }
 
-func TestGroups(t *testing.T) {
-	bot := DefaultBot(Desktop)
-	if err := bot.Login(); err != nil {
-		t.Error(err)
-		return
+func TestFriendsAndGroups(t *testing.T) {
+	server := newFakeWeChat(t)
+	server.contacts = Members{
+		{UserName: "@friend", NickName: "小明"},
+		{UserName: "@@group", NickName: "工作群"},
+		{UserName: "@mp", NickName: "公众号", VerifyFlag: 8},
+	}
+	bot := server.login()
+	self, err := bot.GetCurrentUser()
+	if err != nil {
+		t.Fatal(err)
 	}
-	user, err := bot.GetCurrentUser()
+	friends, err := self.Friends()
 	if err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
 	}
-	groups, err := user.Groups()
+	groups, err := self.Groups()
 	if err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
+	}
+	if friends.Count() != 1 || friends.First().NickName != "小明" {
+		t.Errorf("friends: %v", friends)
+	}
+	if groups.Count() != 1 || groups.First().NickName != "工作群" {
+		t.Errorf("groups: %v", groups)
+	}
+	// 已经获取过联系人, 不会重复请求
+	if _, err = self.Friends(); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.requestCount(webwxgetcontact); n != 1 {
+		t.Errorf("webwxgetcontact requested %d times", n)
 	}
-	t.Log(groups)
 }
 
 func TestPinUser(t *testing.T) {
-	bot := DefaultBot(Desktop)
-	if err := bot.Login(); err != nil {
-		t.Error(err)
-		return
-	}
-	user, err := bot.GetCurrentUser()
+	server := newFakeWeChat(t)
+	server.contacts = Members{{UserName: "@friend", NickName: "小明"}}
+	bot := server.login()
+	self, _ := bot.GetCurrentUser()
+	friends, err := self.Friends()
 	if err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
 	}
-	friends, err := user.Friends()
-	if err != nil {
-		t.Error(err)
-		return
+	f := friends.First()
+	if err = f.Pin(); err != nil {
+		t.Fatal(err)
 	}
-	if friends.Count() > 0 {
-		f := friends.First()
-		f.Pin()
-		time.Sleep(time.Second * 5)
-		f.UnPin()
+	if err = f.UnPin(); err != nil {
+		t.Fatal(err)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.oplogs) != 2 || server.oplogs[0]["OP"] != float64(1) || server.oplogs[1]["OP"] != float64(0) {
+		t.Errorf("oplogs: %v", server.oplogs)
 	}
 }
 
 func TestSender(t *testing.T) {
-	bot := DefaultBot(Desktop)
+	server := newFakeWeChat(t)
+	server.contacts = Members{
+		{UserName: "@friend", NickName: "小明"},
+		{UserName: "@@group", NickName: "工作群", MemberList: Members{{UserName: "@member", NickName: "小红"}}},
+	}
+	bot := server.newBot()
+	senders := make(chan string, 2)
 	bot.MessageHandler = func(msg *Message) {
+		var sender *User
+		var err error
 		if msg.IsSendByGroup() {
-			fmt.Println(msg.SenderInGroup())
+			sender, err = msg.SenderInGroup()
 		} else {
-			fmt.Println(msg.Sender())
+			sender, err = msg.Sender()
+		}
+		if err != nil {
+			t.Error(err)
+			senders <- ""
+			return
 		}
+		senders <- sender.NickName
 	}
 	if err := bot.Login(); err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
+	}
+	server.pushText("@@group", "@self", "@member:<br/>大家好")
+	server.pushText("@friend", "@self", "你好")
+	for _, want := range []string{"小红", "小明"} {
+		select {
+		case got := <-senders:
+			if got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for message")
+		}
+	}
+}
+
+func TestSendImage(t *testing.T) {
+	server := newFakeWeChat(t)
+	server.contacts = Members{{UserName: "@friend", NickName: "小明"}}
+	bot := server.login()
+	self, _ := bot.GetCurrentUser()
+	friends, err := self.Friends()
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := bytes.Repeat([]byte{0xff, 0xd8, 0xff}, 100)
+	if _, err = friends.First().SendImage(bytes.NewReader(image)); err != nil {
+		t.Fatal(err)
+	}
+	server.mu.Lock()
+	uploads := server.uploads
+	server.mu.Unlock()
+	if len(uploads) != 1 || !bytes.Equal(uploads[0].Data, image) {
+		t.Fatalf("uploads: %d", len(uploads))
+	}
+	sent := server.sentMessages()
+	if len(sent) != 1 || sent[0].Type != MsgTypeImage || !strings.HasPrefix(sent[0].MediaId, "@fake_media_") {
+		t.Errorf("sent: %+v", sent)
 	}
-	bot.Block()
 }
 
 // TestGetUUID
 // @description: 获取登录二维码(UUID)
 // @param t
 func TestGetUUID(t *testing.T) {
-	bot := DefaultBot(Desktop)
-
+	server := newFakeWeChat(t)
+	bot := server.newBot()
 	uuid, err := bot.Caller.GetLoginUUID(bot.Context())
 	if err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
+	}
+	if uuid != server.uuid {
+		t.Errorf("got %s, want %s", uuid, server.uuid)
 	}
-	t.Log(uuid)
 }
 
 // TestLoginWithUUID
 // @description: 使用UUID登录
 // @param t
 func TestLoginWithUUID(t *testing.T) {
-	uuid := "oZZsO0Qv8Q=="
-	bot := DefaultBot(Desktop, WithUUIDOption(uuid))
-	err := bot.Login()
-	if err != nil {
-		t.Errorf("登录失败: %v", err.Error())
-		return
+	server := newFakeWeChat(t)
+	server.uuid = "oZZsO0Qv8Q=="
+	bot := server.newBot(WithUUIDOption("oZZsO0Qv8Q=="))
+	if err := bot.Login(); err != nil {
+		t.Fatalf("登录失败: %v", err)
+	}
+	if n := server.requestCount("/jslogin"); n != 0 {
+		t.Errorf("jslogin requested %d times", n)
 	}
 }
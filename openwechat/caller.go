@@ -8,8 +8,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
-	"os"
 )
 
 // Caller 调用请求和解析请求
@@ -285,9 +285,10 @@ func (c *Caller) WebWxOplog(ctx context.Context, opt *CallerWebWxOplogOptions) e
 type CallerUploadMediaOptions struct {
 	FromUserName string
 	ToUserName   string
-	File         *os.File
+	File         *UploadFile
 	BaseRequest  *BaseRequest
 	LoginInfo    *LoginInfo
+	Progress     UploadProgress
 }
 
 func (c *Caller) UploadMedia(ctx context.Context, opt *CallerUploadMediaOptions) (*UploadResponse, error) {
@@ -298,8 +299,26 @@ func (c *Caller) UploadMedia(ctx context.Context, opt *CallerUploadMediaOptions)
 		File:         opt.File,
 		BaseRequest:  opt.BaseRequest,
 		LoginInfo:    opt.LoginInfo,
+		Progress:     opt.Progress,
+	}
+	resumeTimes := c.Client.UploadResumeTimes
+	if resumeTimes == 0 {
+		resumeTimes = DefaultUploadResumeTimes
+	}
+	var (
+		resp *http.Response
+		err  error
+	)
+	for attempt := 0; ; attempt++ {
+		resp, err = c.Client.WebWxUploadMediaByChunk(ctx, clientWebWxUploadMediaByChunkOpt)
+		// 部分分块上传失败时用同一个 UploadFile 继续上传, 已经成功的分块不再上传
+		if err == nil || attempt >= resumeTimes || ctx.Err() != nil || !opt.File.resumable() {
+			break
+		}
+		if err = sleepContext(ctx, uploadRetryPolicy.delay(attempt+1)); err != nil {
+			break
+		}
 	}
-	resp, err := c.Client.WebWxUploadMediaByChunk(ctx, clientWebWxUploadMediaByChunkOpt)
 	// 无错误上传成功之后获取请求结果，判断结果是否正常
 	if err != nil {
 		return nil, err
@@ -324,17 +343,24 @@ type CallerUploadMediaCommonOptions struct {
 	Reader       io.Reader
 	BaseRequest  *BaseRequest
 	LoginInfo    *LoginInfo
+	// 以下为可选项, Size 和 Md5 都提供时不需要先读取一遍文件
+	FileName string
+	Size     int64
+	Md5      string
+	Progress UploadProgress
+}
+
+// uploadFile 根据选项创建要上传的文件
+func (opt *CallerUploadMediaCommonOptions) uploadFile() *UploadFile {
+	return &UploadFile{Reader: opt.Reader, Name: opt.FileName, Size: opt.Size, Md5: opt.Md5}
 }
 
 type CallerWebWxSendImageMsgOptions CallerUploadMediaCommonOptions
 
 // WebWxSendImageMsg 发送图片消息接口
 func (c *Caller) WebWxSendImageMsg(ctx context.Context, opt *CallerWebWxSendImageMsgOptions) (*SentMessage, error) {
-	file, cb, err := readerToFile(opt.Reader)
-	if err != nil {
-		return nil, err
-	}
-	defer cb()
+	file := (*CallerUploadMediaCommonOptions)(opt).uploadFile()
+	defer func() { _ = file.Close() }()
 	// 首先尝试上传图片
 	var mediaId string
 	{
@@ -344,6 +370,7 @@ func (c *Caller) WebWxSendImageMsg(ctx context.Context, opt *CallerWebWxSendImag
 			File:         file,
 			BaseRequest:  opt.BaseRequest,
 			LoginInfo:    opt.LoginInfo,
+			Progress:     opt.Progress,
 		}
 		resp, err := c.UploadMedia(ctx, uploadMediaOption)
 		if err != nil {
@@ -371,26 +398,22 @@ func (c *Caller) WebWxSendImageMsg(ctx context.Context, opt *CallerWebWxSendImag
 type CallerWebWxSendFileOptions CallerUploadMediaCommonOptions
 
 func (c *Caller) WebWxSendFile(ctx context.Context, opt *CallerWebWxSendFileOptions) (*SentMessage, error) {
-	file, cb, err := readerToFile(opt.Reader)
-	if err != nil {
-		return nil, err
-	}
-	defer cb()
-
+	file := (*CallerUploadMediaCommonOptions)(opt).uploadFile()
+	defer func() { _ = file.Close() }()
 	uploadMediaOption := &CallerUploadMediaOptions{
 		FromUserName: opt.FromUserName,
 		ToUserName:   opt.ToUserName,
 		File:         file,
 		BaseRequest:  opt.BaseRequest,
 		LoginInfo:    opt.LoginInfo,
+		Progress:     opt.Progress,
 	}
 	resp, err := c.UploadMedia(ctx, uploadMediaOption)
 	if err != nil {
 		return nil, err
 	}
 	// 构造新的文件类型的信息
-	stat, _ := file.Stat()
-	appMsg := NewFileAppMessage(stat, resp.MediaId)
+	appMsg := NewFileAppMessage(file.Stat(), resp.MediaId)
 	content, err := appMsg.XmlByte()
 	if err != nil {
 		return nil, err
@@ -402,11 +425,8 @@ func (c *Caller) WebWxSendFile(ctx context.Context, opt *CallerWebWxSendFileOpti
 type CallerWebWxSendAppMsgOptions CallerUploadMediaCommonOptions
 
 func (c *Caller) WebWxSendVideoMsg(ctx context.Context, opt *CallerWebWxSendAppMsgOptions) (*SentMessage, error) {
-	file, cb, err := readerToFile(opt.Reader)
-	if err != nil {
-		return nil, err
-	}
-	defer cb()
+	file := (*CallerUploadMediaCommonOptions)(opt).uploadFile()
+	defer func() { _ = file.Close() }()
 	var mediaId string
 	{
 		uploadMediaOption := &CallerUploadMediaOptions{
@@ -415,6 +435,7 @@ func (c *Caller) WebWxSendVideoMsg(ctx context.Context, opt *CallerWebWxSendAppM
 			File:         file,
 			BaseRequest:  opt.BaseRequest,
 			LoginInfo:    opt.LoginInfo,
+			Progress:     opt.Progress,
 		}
 
 		resp, err := c.UploadMedia(ctx, uploadMediaOption)
@@ -721,29 +742,3 @@ func (p *MessageResponseParser) SentMessage(msg *SendMessage) (*SentMessage, err
 	}
 	return &SentMessage{MsgId: msgID, SendMessage: msg}, nil
 }
-
-func readerToFile(reader io.Reader) (file *os.File, cb func(), err error) {
-	var ok bool
-	if file, ok = reader.(*os.File); ok {
-		return file, func() {}, nil
-	}
-	file, err = os.CreateTemp("", "*")
-	if err != nil {
-		return nil, nil, err
-	}
-	cb = func() {
-		_ = file.Close()
-		_ = os.Remove(file.Name())
-	}
-	_, err = io.Copy(file, reader)
-	if err != nil {
-		cb()
-		return nil, nil, err
-	}
-	_, err = file.Seek(0, io.SeekStart)
-	if err != nil {
-		cb()
-		return nil, nil, err
-	}
-	return file, cb, nil
-}
@@ -3,19 +3,17 @@ package openwechat
 import (
 	"bytes"
 	"context"
-	"crypto/md5"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -85,6 +83,27 @@ type Client struct {
 
 	// MaxRetryTimes 最大重试次数
 	MaxRetryTimes int
+
+	// RetryPolicy 请求失败后的重试策略, 为空时使用 DefaultRetryPolicy
+	// 最多请求 MaxRetryTimes 次
+	RetryPolicy RetryPolicy
+
+	// EndpointResolver 解析接口的请求地址, 为空时使用 DefaultEndpointResolver
+	EndpointResolver EndpointResolver
+
+	// UploadParallelism 分块上传文件时同时上传的分块数, 为0时使用 DefaultUploadParallelism
+	UploadParallelism int
+
+	// UploadChunkRetryTimes 每个分块最多上传的次数, 为0时使用 DefaultUploadChunkRetryTimes
+	UploadChunkRetryTimes int
+
+	// UploadResumeTimes 发送文件时分块上传失败后继续上传没有成功的分块的次数
+	// 为0时使用 DefaultUploadResumeTimes, 为负数时不继续上传
+	UploadResumeTimes int
+
+	// endpoints 通过 SetEndpoint 单独设置的接口地址
+	endpoints   map[string]string
+	endpointsMu sync.RWMutex
 }
 
 // NewClient 创建一个新的客户端
@@ -116,6 +135,36 @@ func DefaultClient() *Client {
 	return client
 }
 
+// SetEndpoint 单独设置某个接口的请求地址, 优先于 EndpointResolver, 可以在请求过程中调用
+//
+//	client.SetEndpoint("/cgi-bin/mmwebwx-bin/synccheck", "http://127.0.0.1:8080/synccheck")
+func (c *Client) SetEndpoint(path, rawURL string) {
+	c.endpointsMu.Lock()
+	defer c.endpointsMu.Unlock()
+	if c.endpoints == nil {
+		c.endpoints = make(map[string]string)
+	}
+	c.endpoints[path] = rawURL
+}
+
+// endpoint 返回接口的完整请求地址
+func (c *Client) endpoint(path string) string {
+	c.endpointsMu.RLock()
+	rawURL, ok := c.endpoints[endpointName(path)]
+	c.endpointsMu.RUnlock()
+	if ok {
+		if name := endpointName(path); name != path {
+			return rawURL + strings.TrimPrefix(path, name)
+		}
+		return rawURL
+	}
+	resolver := c.EndpointResolver
+	if resolver == nil {
+		resolver = DefaultEndpointResolver
+	}
+	return resolver.ResolveEndpoint(c.Domain, path)
+}
+
 // AddHttpHook 添加一个请求上下文钩子
 func (c *Client) AddHttpHook(hooks ...HttpHook) {
 	c.HttpHooks = append(c.HttpHooks, hooks...)
@@ -127,30 +176,46 @@ func (c *Client) do(req *http.Request) (*http.Response, error) {
 		c.MaxRetryTimes = 1
 	}
 	var (
-		resp        *http.Response
-		err         error
-		requestBody *bytes.Reader
+		resp    *http.Response
+		err     error
+		rawBody []byte
 	)
 
 	c.HttpHooks.BeforeRequest(req)
 	defer func() { c.HttpHooks.AfterRequest(resp, err) }()
 	if req.Body != nil {
-		rawBody, err := io.ReadAll(req.Body)
-		if err != nil {
+		if rawBody, err = io.ReadAll(req.Body); err != nil {
 			return nil, fmt.Errorf("io.ReadAll: %w", err)
 		}
-		requestBody = bytes.NewReader(rawBody)
+		_ = req.Body.Close()
 	}
-	for i := 0; i < c.MaxRetryTimes; i++ {
-		if requestBody != nil {
-			_, err := requestBody.Seek(0, io.SeekStart)
-			if err != nil {
-				return nil, fmt.Errorf("requestBody.Seek: %w", err)
-			}
-			req.Body = io.NopCloser(requestBody)
+	policy := c.RetryPolicy
+	if override, ok := req.Context().Value(retryPolicyKey{}).(RetryPolicy); ok {
+		policy = override
+	}
+	if policy == nil {
+		policy = defaultRetryPolicy
+	}
+	for attempt := 1; ; attempt++ {
+		if req.Body != nil {
+			req.Body = io.NopCloser(bytes.NewReader(rawBody))
 		}
 		resp, err = c.client.Do(req)
-		if err == nil {
+		// 主动取消的请求不再重试
+		if attempt >= c.MaxRetryTimes || req.Context().Err() != nil {
+			break
+		}
+		delay, retry := policy.Retry(req, rawBody, attempt, resp, err)
+		if !retry {
+			break
+		}
+		c.HttpHooks.OnRetry(req, attempt, delay, resp, err)
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		if err = sleepContext(req.Context(), delay); err != nil {
+			resp = nil
 			break
 		}
 	}
@@ -190,7 +255,7 @@ func (c *Client) GetLoginUUID(ctx context.Context) (*http.Response, error) {
 
 // GetLoginQrcode 获取登录的二维吗
 func (c *Client) GetLoginQrcode(ctx context.Context, uuid string) (*http.Response, error) {
-	path := qrcode + uuid
+	path := c.endpoint(qrcode + uuid)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
 	if err != nil {
 		return nil, err
@@ -200,7 +265,7 @@ func (c *Client) GetLoginQrcode(ctx context.Context, uuid string) (*http.Respons
 
 // CheckLogin 检查是否登录
 func (c *Client) CheckLogin(ctx context.Context, uuid, tip string) (*http.Response, error) {
-	path, err := url.Parse(login)
+	path, err := url.Parse(c.endpoint(login))
 	if err != nil {
 		return nil, err
 	}
@@ -221,12 +286,24 @@ func (c *Client) CheckLogin(ctx context.Context, uuid, tip string) (*http.Respon
 
 // GetLoginInfo 请求获取LoginInfo
 func (c *Client) GetLoginInfo(ctx context.Context, path *url.URL) (*http.Response, error) {
-	return c.mode.GetLoginInfo(ctx, c, path.String())
+	return c.mode.GetLoginInfo(ctx, c, c.loginPageURL(path))
+}
+
+// loginPageURL 返回扫码后跳转的请求地址
+// 没有自定义接口地址时使用服务器返回的地址, 不同账号跳转的域名不同
+func (c *Client) loginPageURL(path *url.URL) string {
+	c.endpointsMu.RLock()
+	_, ok := c.endpoints[webwxnewloginpage]
+	c.endpointsMu.RUnlock()
+	if !ok && c.EndpointResolver == nil {
+		return path.String()
+	}
+	return c.endpoint(webwxnewloginpage + "?" + path.RawQuery)
 }
 
 // WebInit 请求获取初始化信息
 func (c *Client) WebInit(ctx context.Context, request *BaseRequest) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.BaseHost() + webwxinit)
+	path, err := url.Parse(c.endpoint(webwxinit))
 	if err != nil {
 		return nil, err
 	}
@@ -256,7 +333,7 @@ type ClientWebWxStatusNotifyOptions ClientCommonOptions
 
 // WebWxStatusNotify 通知手机已登录
 func (c *Client) WebWxStatusNotify(ctx context.Context, opt *ClientWebWxStatusNotifyOptions) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.BaseHost() + webwxstatusnotify)
+	path, err := url.Parse(c.endpoint(webwxstatusnotify))
 	if err != nil {
 		return nil, err
 	}
@@ -288,7 +365,7 @@ type ClientSyncCheckOptions ClientCommonOptions
 
 // SyncCheck 异步检查是否有新的消息返回
 func (c *Client) SyncCheck(ctx context.Context, opt *ClientSyncCheckOptions) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.SyncHost() + synccheck)
+	path, err := url.Parse(c.endpoint(synccheck))
 	if err != nil {
 		return nil, err
 	}
@@ -317,7 +394,7 @@ func (c *Client) SyncCheck(ctx context.Context, opt *ClientSyncCheckOptions) (*h
 
 // WebWxGetContact 获取联系人信息
 func (c *Client) WebWxGetContact(ctx context.Context, sKey string, reqs int64) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.BaseHost() + webwxgetcontact)
+	path, err := url.Parse(c.endpoint(webwxgetcontact))
 	if err != nil {
 		return nil, err
 	}
@@ -335,7 +412,7 @@ func (c *Client) WebWxGetContact(ctx context.Context, sKey string, reqs int64) (
 
 // WebWxBatchGetContact 获取联系人详情
 func (c *Client) WebWxBatchGetContact(ctx context.Context, members Members, request *BaseRequest) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.BaseHost() + webwxbatchgetcontact)
+	path, err := url.Parse(c.endpoint(webwxbatchgetcontact))
 	if err != nil {
 		return nil, err
 	}
@@ -365,7 +442,7 @@ type ClientWebWxSyncOptions ClientCommonOptions
 
 // WebWxSync 获取消息接口
 func (c *Client) WebWxSync(ctx context.Context, opt *ClientWebWxSyncOptions) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.BaseHost() + webwxsync)
+	path, err := url.Parse(c.endpoint(webwxsync))
 	if err != nil {
 		return nil, err
 	}
@@ -419,7 +496,7 @@ type ClientWebWxSendMsgOptions struct {
 // WebWxSendMsg 发送文本消息
 func (c *Client) WebWxSendMsg(ctx context.Context, opt *ClientWebWxSendMsgOptions) (*http.Response, error) {
 	opt.Message.Type = MsgTypeText
-	path, err := url.Parse(c.Domain.BaseHost() + webwxsendmsg)
+	path, err := url.Parse(c.endpoint(webwxsendmsg))
 	if err != nil {
 		return nil, err
 	}
@@ -434,7 +511,7 @@ func (c *Client) WebWxSendMsg(ctx context.Context, opt *ClientWebWxSendMsgOption
 func (c *Client) WebWxGetHeadImg(ctx context.Context, user *User) (*http.Response, error) {
 	var path string
 	if user.HeadImgUrl != "" {
-		path = c.Domain.BaseHost() + user.HeadImgUrl
+		path = c.endpoint(user.HeadImgUrl)
 	} else {
 		params := url.Values{}
 		params.Add("username", user.UserName)
@@ -442,7 +519,7 @@ func (c *Client) WebWxGetHeadImg(ctx context.Context, user *User) (*http.Respons
 		params.Add("type", "big")
 		params.Add("chatroomid", user.EncryChatRoomId)
 		params.Add("seq", "0")
-		URL, err := url.Parse(c.Domain.BaseHost() + webwxgeticon)
+		URL, err := url.Parse(c.endpoint(webwxgeticon))
 		if err != nil {
 			return nil, err
 		}
@@ -459,45 +536,57 @@ func (c *Client) WebWxGetHeadImg(ctx context.Context, user *User) (*http.Respons
 type ClientWebWxUploadMediaByChunkOptions struct {
 	FromUserName string
 	ToUserName   string
-	File         *os.File
+	File         *UploadFile
 	BaseRequest  *BaseRequest
 	LoginInfo    *LoginInfo
+	// Progress 每个分块上传成功后调用, 可以为空
+	Progress UploadProgress
 }
 
 // WebWxUploadMediaByChunk 分块上传文件
-// TODO 优化掉这个函数
+// 文件的大小和 md5 没有提供时会先读取一遍计算, 每个分块边读取边上传
+// 除最后一个分块外的分块会并发上传, 失败的分块单独重试, 最后一个分块在其他分块都成功后上传
+// 上传失败后使用同一个 UploadFile 再次上传时, 先通过 webwxcheckupload 检查文件是否已经上传, 然后只上传没有成功的分块
 func (c *Client) WebWxUploadMediaByChunk(ctx context.Context, opt *ClientWebWxUploadMediaByChunkOptions) (*http.Response, error) {
-	// 获取文件上传的类型
-	contentType, err := GetFileContentType(opt.File)
-	if err != nil {
+	file := opt.File
+	if err := file.prepare(ctx); err != nil {
 		return nil, err
 	}
-	if _, err = opt.File.Seek(io.SeekStart, 0); err != nil {
-		return nil, err
+	if file.Size <= 0 {
+		return nil, errors.New("upload: empty file")
 	}
 
-	// 获取文件的md5
-	h := md5.New()
-	if _, err = io.Copy(h, opt.File); err != nil {
-		return nil, err
-	}
-	fileMd5 := hex.EncodeToString(h.Sum(nil))
+	// 计算上传文件的次数
+	chunks := (file.Size + chunkSize - 1) / chunkSize
 
-	sate, err := opt.File.Stat()
-	if err != nil {
-		return nil, err
+	// 之前上传过一部分分块, 检查服务器上是否已经有这个文件
+	if file.begin(chunks) {
+		if file.readerAt == nil {
+			return nil, errors.New("upload: can not resume from a non-seekable reader")
+		}
+		resp, err := c.webWxCheckUpload(ctx, file.Stat(), opt.BaseRequest, file.Md5, opt.FromUserName, opt.ToUserName)
+		if err != nil {
+			return nil, err
+		}
+		uploaded, err := checkUploadedResponse(resp)
+		if err != nil {
+			return nil, err
+		}
+		if uploaded {
+			return resp, nil
+		}
 	}
-	filename := sate.Name()
 
+	filename := file.Name
 	if ext := filepath.Ext(filename); ext == "" {
-		names := strings.Split(contentType, "/")
+		names := strings.Split(strings.SplitN(file.ContentType, ";", 2)[0], "/")
 		filename = filename + "." + names[len(names)-1]
 	}
 
 	// 获取文件的类型
 	mediaType := getMessageType(filename)
 
-	path, err := url.Parse(c.Domain.FileHost() + webwxuploadmedia)
+	path, err := url.Parse(c.endpoint(webwxuploadmedia))
 	if err != nil {
 		return nil, err
 	}
@@ -516,14 +605,14 @@ func (c *Client) WebWxUploadMediaByChunk(ctx context.Context, opt *ClientWebWxUp
 	uploadMediaRequest := map[string]interface{}{
 		"UploadType":    2,
 		"BaseRequest":   opt.BaseRequest,
-		"ClientMediaId": time.Now().Unix() * 1e4,
-		"TotalLen":      sate.Size(),
+		"ClientMediaId": file.clientMediaId,
+		"TotalLen":      file.Size,
 		"StartPos":      0,
-		"DataLen":       sate.Size(),
+		"DataLen":       file.Size,
 		"MediaType":     4,
 		"FromUserName":  opt.FromUserName,
 		"ToUserName":    opt.ToUserName,
-		"FileMd5":       fileMd5,
+		"FileMd5":       file.Md5,
 	}
 
 	uploadMediaRequestByte, err := json.Marshal(uploadMediaRequest)
@@ -531,99 +620,86 @@ func (c *Client) WebWxUploadMediaByChunk(ctx context.Context, opt *ClientWebWxUp
 		return nil, err
 	}
 
-	// 计算上传文件的次数
-	chunks := (sate.Size() + chunkSize - 1) / chunkSize
-
-	var resp *http.Response
-
-	content := map[string]string{
-		"id":                "WU_FILE_0",
-		"name":              filename,
-		"type":              contentType,
-		"lastModifiedDate":  sate.ModTime().Format(TimeFormat),
-		"size":              strconv.FormatInt(sate.Size(), 10),
-		"mediatype":         mediaType,
-		"webwx_data_ticket": webWxDataTicket,
-		"pass_ticket":       opt.LoginInfo.PassTicket,
-	}
-
-	if chunks > 1 {
-		content["chunks"] = strconv.FormatInt(chunks, 10)
-	}
-
-	if _, err = opt.File.Seek(0, 0); err != nil {
-		return nil, err
+	retryTimes := c.UploadChunkRetryTimes
+	if retryTimes <= 0 {
+		retryTimes = DefaultUploadChunkRetryTimes
 	}
 
-	var chunkBuff = make([]byte, chunkSize)
-
-	var formBuffer = bytes.NewBuffer(nil)
-
-	// 分块上传
-	for chunk := 0; int64(chunk) < chunks; chunk++ {
-		if chunks > 1 {
-			content["chunk"] = strconv.Itoa(chunk)
-		}
-
-		formBuffer.Reset()
-
-		writer := multipart.NewWriter(formBuffer)
-
-		if err = writer.WriteField("uploadmediarequest", string(uploadMediaRequestByte)); err != nil {
-			return nil, err
-		}
-
-		for k, v := range content {
-			if err := writer.WriteField(k, v); err != nil {
-				return nil, err
-			}
-		}
-
-		w, err := writer.CreateFormFile("filename", opt.File.Name())
-		if err != nil {
-			return nil, err
-		}
-
-		n, err := opt.File.Read(chunkBuff)
-
-		if err != nil && err != io.EOF {
-			return nil, err
-		}
-		if _, err = w.Write(chunkBuff[:n]); err != nil {
-			return nil, err
+	// 上传一个分块, 失败时重试
+	uploadChunk := func(ctx context.Context, chunk int64) (*http.Response, error) {
+		content := map[string]string{
+			"id":                 "WU_FILE_0",
+			"name":               filename,
+			"type":               file.ContentType,
+			"lastModifiedDate":   file.ModTime.Format(TimeFormat),
+			"size":               strconv.FormatInt(file.Size, 10),
+			"mediatype":          mediaType,
+			"webwx_data_ticket":  webWxDataTicket,
+			"pass_ticket":        opt.LoginInfo.PassTicket,
+			"uploadmediarequest": string(uploadMediaRequestByte),
 		}
-		ct := writer.FormDataContentType()
-		if err = writer.Close(); err != nil {
-			return nil, err
-		}
-
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, path.String(), formBuffer)
-		if err != nil {
-			return nil, err
+		if chunks > 1 {
+			content["chunks"] = strconv.FormatInt(chunks, 10)
+			content["chunk"] = strconv.FormatInt(chunk, 10)
 		}
-		req.Header.Set("Content-Type", ct)
-
-		// 发送数据
-		resp, err = c.Do(req)
+		size := file.chunkLen(chunk)
+		body, contentType, err := multipartChunk(content, filename, file.chunk(chunk, size), size)
 		if err != nil {
 			return nil, err
 		}
-
-		isLastTime := int64(chunk)+1 == chunks
-		// 如果不是最后一次, 解析有没有错误
-		if !isLastTime {
-			parser := MessageResponseParser{Reader: resp.Body}
-			if err = parser.Err(); err != nil {
-				_ = resp.Body.Close()
+		// 分块在这里重试, Client 不再重试, 否则两层的重试次数会相乘
+		reqCtx := withRetryPolicy(ctx, NoRetry)
+		for attempt := 1; ; attempt++ {
+			req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, path.String(), bytes.NewReader(body))
+			if err != nil {
 				return nil, err
 			}
-			if err = resp.Body.Close(); err != nil {
+			req.Header.Set("Content-Type", contentType)
+
+			// 发送数据, 检查返回的结果
+			resp, err := c.Do(req)
+			if err == nil {
+				if err = checkUploadChunkResponse(resp); err == nil {
+					return resp, nil
+				}
+			}
+			if attempt >= retryTimes || ctx.Err() != nil {
+				return nil, fmt.Errorf("upload chunk %d: %w", chunk, err)
+			}
+			if err = sleepContext(ctx, uploadRetryPolicy.delay(attempt)); err != nil {
 				return nil, err
 			}
 		}
 	}
+
+	parallelism := c.UploadParallelism
+	if parallelism <= 0 {
+		parallelism = DefaultUploadParallelism
+	}
+	// 只能按顺序读取的文件依次上传
+	if file.readerAt == nil {
+		parallelism = 1
+	}
+	progress := newUploadProgress(file, opt.Progress)
+	if err = uploadChunks(ctx, file.pending(chunks-1), parallelism, func(ctx context.Context, chunk int64) error {
+		resp, err := uploadChunk(ctx, chunk)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		progress.done(chunk)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
 	// 将最后一次携带文件信息的response返回
-	return resp, err
+	resp, err := uploadChunk(ctx, chunks-1)
+	if err != nil {
+		return nil, err
+	}
+	progress.done(chunks - 1)
+	return resp, nil
 }
 
 // WebWxSendMsgImg 发送图片
@@ -631,7 +707,7 @@ func (c *Client) WebWxUploadMediaByChunk(ctx context.Context, opt *ClientWebWxUp
 // 发送的图片必须是已经成功上传的图片
 func (c *Client) WebWxSendMsgImg(ctx context.Context, opt *ClientWebWxSendMsgOptions) (*http.Response, error) {
 	opt.Message.Type = MsgTypeImage
-	path, err := url.Parse(c.Domain.BaseHost() + webwxsendmsgimg)
+	path, err := url.Parse(c.endpoint(webwxsendmsgimg))
 	if err != nil {
 		return nil, err
 	}
@@ -647,7 +723,7 @@ func (c *Client) WebWxSendMsgImg(ctx context.Context, opt *ClientWebWxSendMsgOpt
 // WebWxSendAppMsg 发送文件信息
 func (c *Client) WebWxSendAppMsg(ctx context.Context, msg *SendMessage, request *BaseRequest) (*http.Response, error) {
 	msg.Type = AppMessage
-	path, err := url.Parse(c.Domain.BaseHost() + webwxsendappmsg)
+	path, err := url.Parse(c.endpoint(webwxsendappmsg))
 	if err != nil {
 		return nil, err
 	}
@@ -666,7 +742,7 @@ type ClientWebWxOplogOption struct {
 
 // WebWxOplog 用户重命名接口
 func (c *Client) WebWxOplog(ctx context.Context, opt *ClientWebWxOplogOption) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.BaseHost() + webwxoplog)
+	path, err := url.Parse(c.endpoint(webwxoplog))
 	if err != nil {
 		return nil, err
 	}
@@ -701,7 +777,7 @@ type ClientWebWxVerifyUserOption struct {
 // WebWxVerifyUser 添加用户为好友接口
 func (c *Client) WebWxVerifyUser(ctx context.Context, opt *ClientWebWxVerifyUserOption) (*http.Response, error) {
 	loginInfo := opt.LoginInfo
-	path, err := url.Parse(c.Domain.BaseHost() + webwxverifyuser)
+	path, err := url.Parse(c.endpoint(webwxverifyuser))
 	if err != nil {
 		return nil, err
 	}
@@ -737,7 +813,7 @@ func (c *Client) WebWxVerifyUser(ctx context.Context, opt *ClientWebWxVerifyUser
 
 // WebWxGetMsgImg 获取图片消息的图片响应
 func (c *Client) WebWxGetMsgImg(ctx context.Context, msg *Message, info *LoginInfo) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.BaseHost() + webwxgetmsgimg)
+	path, err := url.Parse(c.endpoint(webwxgetmsgimg))
 	if err != nil {
 		return nil, err
 	}
@@ -755,7 +831,7 @@ func (c *Client) WebWxGetMsgImg(ctx context.Context, msg *Message, info *LoginIn
 
 // WebWxGetVoice 获取语音消息的语音响应
 func (c *Client) WebWxGetVoice(ctx context.Context, msg *Message, info *LoginInfo) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.BaseHost() + webwxgetvoice)
+	path, err := url.Parse(c.endpoint(webwxgetvoice))
 	if err != nil {
 		return nil, err
 	}
@@ -774,7 +850,7 @@ func (c *Client) WebWxGetVoice(ctx context.Context, msg *Message, info *LoginInf
 
 // WebWxGetVideo 获取视频消息的视频响应
 func (c *Client) WebWxGetVideo(ctx context.Context, msg *Message, info *LoginInfo) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.BaseHost() + webwxgetvideo)
+	path, err := url.Parse(c.endpoint(webwxgetvideo))
 	if err != nil {
 		return nil, err
 	}
@@ -793,7 +869,7 @@ func (c *Client) WebWxGetVideo(ctx context.Context, msg *Message, info *LoginInf
 
 // WebWxGetMedia 获取文件消息的文件响应
 func (c *Client) WebWxGetMedia(ctx context.Context, msg *Message, info *LoginInfo) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.FileHost() + webwxgetmedia)
+	path, err := url.Parse(c.endpoint(webwxgetmedia))
 	if err != nil {
 		return nil, err
 	}
@@ -820,7 +896,7 @@ func (c *Client) WebWxGetMedia(ctx context.Context, msg *Message, info *LoginInf
 
 // Logout 用户退出
 func (c *Client) Logout(ctx context.Context, info *LoginInfo) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.BaseHost() + webwxlogout)
+	path, err := url.Parse(c.endpoint(webwxlogout))
 	if err != nil {
 		return nil, err
 	}
@@ -854,7 +930,7 @@ func (c *Client) AddMemberIntoChatRoom(ctx context.Context, opt *ClientAddMember
 
 // addMemberIntoChatRoom 添加用户进群聊
 func (c *Client) addMemberIntoChatRoom(ctx context.Context, opt *ClientAddMemberIntoChatRoomOption) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.BaseHost() + webwxupdatechatroom)
+	path, err := url.Parse(c.endpoint(webwxupdatechatroom))
 	if err != nil {
 		return nil, err
 	}
@@ -882,7 +958,7 @@ func (c *Client) addMemberIntoChatRoom(ctx context.Context, opt *ClientAddMember
 
 // InviteMemberIntoChatRoom 邀请用户进群聊
 func (c *Client) InviteMemberIntoChatRoom(ctx context.Context, opt *ClientAddMemberIntoChatRoomOption) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.BaseHost() + webwxupdatechatroom)
+	path, err := url.Parse(c.endpoint(webwxupdatechatroom))
 	if err != nil {
 		return nil, err
 	}
@@ -917,7 +993,7 @@ type ClientRemoveMemberFromChatRoomOption struct {
 
 // RemoveMemberFromChatRoom 从群聊中移除用户
 func (c *Client) RemoveMemberFromChatRoom(ctx context.Context, opt *ClientRemoveMemberFromChatRoomOption) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.BaseHost() + webwxupdatechatroom)
+	path, err := url.Parse(c.endpoint(webwxupdatechatroom))
 	if err != nil {
 		return nil, err
 	}
@@ -954,7 +1030,7 @@ func (c *Client) WebWxRevokeMsg(ctx context.Context, msg *SentMessage, request *
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Domain.BaseHost()+webwxrevokemsg, buffer)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint(webwxrevokemsg), buffer)
 	if err != nil {
 		return nil, err
 	}
@@ -962,9 +1038,9 @@ func (c *Client) WebWxRevokeMsg(ctx context.Context, msg *SentMessage, request *
 	return c.Do(req)
 }
 
-// 校验上传文件
-func (c *Client) webWxCheckUpload(stat os.FileInfo, request *BaseRequest, fileMd5, fromUserName, toUserName string) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.BaseHost() + webwxcheckupload)
+// 校验上传文件, 文件已经在服务器上时返回 MediaId
+func (c *Client) webWxCheckUpload(ctx context.Context, stat os.FileInfo, request *BaseRequest, fileMd5, fromUserName, toUserName string) (*http.Response, error) {
+	path, err := url.Parse(c.endpoint(webwxcheckupload))
 	if err != nil {
 		return nil, err
 	}
@@ -981,7 +1057,7 @@ func (c *Client) webWxCheckUpload(stat os.FileInfo, request *BaseRequest, fileMd
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest(http.MethodPost, path.String(), body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, path.String(), body)
 	if err != nil {
 		return nil, err
 	}
@@ -996,7 +1072,7 @@ type ClientWebWxStatusAsReadOption struct {
 }
 
 func (c *Client) WebWxStatusAsRead(ctx context.Context, opt *ClientWebWxStatusAsReadOption) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.BaseHost() + webwxstatusnotify)
+	path, err := url.Parse(c.endpoint(webwxstatusnotify))
 	if err != nil {
 		return nil, err
 	}
@@ -1032,7 +1108,7 @@ type ClientWebWxRelationPinOption struct {
 
 // WebWxRelationPin 联系人置顶接口
 func (c *Client) WebWxRelationPin(ctx context.Context, opt *ClientWebWxRelationPinOption) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.BaseHost() + webwxoplog)
+	path, err := url.Parse(c.endpoint(webwxoplog))
 	if err != nil {
 		return nil, err
 	}
@@ -1062,7 +1138,7 @@ func (c *Client) WebWxPushLogin(ctx context.Context, uin int64) (*http.Response,
 
 // WebWxSendVideoMsg 发送视频消息接口
 func (c *Client) WebWxSendVideoMsg(ctx context.Context, request *BaseRequest, msg *SendMessage) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.BaseHost() + webwxsendvideomsg)
+	path, err := url.Parse(c.endpoint(webwxsendvideomsg))
 	if err != nil {
 		return nil, err
 	}
@@ -1084,7 +1160,7 @@ type ClientWebWxCreateChatRoomOption struct {
 
 // WebWxCreateChatRoom 创建群聊
 func (c *Client) WebWxCreateChatRoom(ctx context.Context, opt *ClientWebWxCreateChatRoomOption) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.BaseHost() + webwxcreatechatroom)
+	path, err := url.Parse(c.endpoint(webwxcreatechatroom))
 	if err != nil {
 		return nil, err
 	}
@@ -1124,7 +1200,7 @@ type ClientWebWxRenameChatRoomOption struct {
 
 // WebWxRenameChatRoom 群组重命名接口
 func (c *Client) WebWxRenameChatRoom(ctx context.Context, opt *ClientWebWxRenameChatRoomOption) (*http.Response, error) {
-	path, err := url.Parse(c.Domain.BaseHost() + webwxupdatechatroom)
+	path, err := url.Parse(c.endpoint(webwxupdatechatroom))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,173 @@
+package openwechat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Command 从消息中解析出的命令
+type Command struct {
+	Name string   // 命令名, 不含前缀, 统一为小写
+	Args []string // 按空白分隔的参数
+	*MessageContext
+}
+
+// CommandHandler 命令处理函数, 返回的内容会回复到发送命令的会话
+type CommandHandler func(cmd *Command) (string, error)
+
+type commandRoute struct {
+	usage   string
+	handler CommandHandler
+}
+
+// CommandRouter 在聊天中通过命令控制机器人
+//
+//	router := openwechat.NewCommandRouter("/")
+//	router.Handle("ping", "检查机器人是否在线", func(cmd *openwechat.Command) (string, error) {
+//		return "pong", nil
+//	})
+//	router.Register(dispatcher)
+type CommandRouter struct {
+	Prefix string                  // 命令前缀, 如 "/"
+	Allow  func(msg *Message) bool // 判断消息的发送者是否可以执行命令, 为空时只允许自己
+	routes map[string]*commandRoute
+	names  []string                              // 按注册顺序保存命令名, 用于输出帮助
+	reply  func(msg *Message, text string) error // 回复消息, 为空时使用 Message.ReplyText
+}
+
+// NewCommandRouter 创建命令路由, 内置 help 命令
+func NewCommandRouter(prefix string) *CommandRouter {
+	r := &CommandRouter{Prefix: prefix, routes: make(map[string]*commandRoute)}
+	r.Handle("help", "显示所有命令", func(*Command) (string, error) { return r.Help(), nil })
+	return r
+}
+
+// Handle 注册命令, usage 为帮助中显示的用法说明
+func (r *CommandRouter) Handle(name, usage string, handler CommandHandler) {
+	if handler == nil {
+		panic("CommandHandler can not be nil")
+	}
+	name = strings.ToLower(name)
+	if _, exist := r.routes[name]; !exist {
+		r.names = append(r.names, name)
+	}
+	r.routes[name] = &commandRoute{usage: usage, handler: handler}
+}
+
+// Parse 解析消息内容中的命令, 不是命令时返回 false
+func (r *CommandRouter) Parse(content string) (name string, args []string, ok bool) {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, r.Prefix) {
+		return "", nil, false
+	}
+	fields := strings.Fields(strings.TrimPrefix(content, r.Prefix))
+	if len(fields) == 0 {
+		return "", nil, false
+	}
+	return strings.ToLower(fields[0]), fields[1:], true
+}
+
+// Match 判断消息是否为已经注册的命令, 可以作为 MatchFunc 使用
+func (r *CommandRouter) Match(msg *Message) bool {
+	if !msg.IsText() {
+		return false
+	}
+	name, _, ok := r.Parse(msg.Content)
+	if !ok {
+		return false
+	}
+	_, exist := r.routes[name]
+	return exist
+}
+
+// Help 返回所有命令的帮助
+func (r *CommandRouter) Help() string {
+	var sb strings.Builder
+	sb.WriteString("可用命令:")
+	for _, name := range r.names {
+		fmt.Fprintf(&sb, "\n%s%s  %s", r.Prefix, name, r.routes[name].usage)
+	}
+	return sb.String()
+}
+
+// Serve 执行命令并回复结果, 执行后中断后续的消息处理函数
+// 没有权限的发送者发送的命令会当作普通消息继续处理
+func (r *CommandRouter) Serve(ctx *MessageContext) {
+	name, args, ok := r.Parse(ctx.Content)
+	route, exist := r.routes[name]
+	if !ok || !exist || !r.allowed(ctx.Message) {
+		return
+	}
+	ctx.Abort()
+	text, err := route.handler(&Command{Name: name, Args: args, MessageContext: ctx})
+	if err != nil {
+		text = fmt.Sprintf("命令执行失败: %v", err)
+	}
+	if text == "" {
+		return
+	}
+	if err = r.send(ctx.Message, text); err != nil {
+		ctx.Bot().Logger().Warn("reply command failed", "command", name, "error", err)
+	}
+}
+
+// Register 将命令路由注册到 MessageMatchDispatcher
+func (r *CommandRouter) Register(dispatcher *MessageMatchDispatcher) {
+	dispatcher.RegisterHandler(r.Match, r.Serve)
+}
+
+func (r *CommandRouter) allowed(msg *Message) bool {
+	if r.Allow == nil {
+		return msg.IsSendBySelf()
+	}
+	return r.Allow(msg)
+}
+
+func (r *CommandRouter) send(msg *Message, text string) error {
+	if r.reply != nil {
+		return r.reply(msg, text)
+	}
+	_, err := msg.ReplyText(text)
+	return err
+}
+
+// AllowFriends 允许自己和指定备注名或昵称的好友执行命令, 好友在群里发送的命令也可以执行
+// 设置了备注名的好友只按备注名匹配, 昵称由对方随意修改, 不能用来判断身份
+func AllowFriends(names ...string) func(msg *Message) bool {
+	allowed := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		allowed[name] = struct{}{}
+	}
+	return func(msg *Message) bool {
+		if msg.IsSendBySelf() {
+			return true
+		}
+		var userName string
+		if msg.IsSendByGroup() {
+			sender, err := msg.SenderInGroup()
+			if err != nil {
+				return false
+			}
+			userName = sender.UserName
+		} else if msg.IsSendByFriend() {
+			userName = msg.FromUserName
+		} else {
+			return false
+		}
+		// 从好友列表中查找, 群成员的信息里没有备注名
+		friends, err := msg.Owner().Friends()
+		if err != nil {
+			return false
+		}
+		friend := friends.SearchByUserName(1, userName).First()
+		if friend == nil {
+			return false
+		}
+		name := friend.RemarkName
+		if name == "" {
+			name = friend.NickName
+		}
+		_, ok := allowed[name]
+		return ok
+	}
+}
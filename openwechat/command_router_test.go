@@ -0,0 +1,108 @@
+package openwechat
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newCommandTestBot() *Bot {
+	bot := &Bot{context: context.Background()}
+	self := newTestSelf(&User{UserName: "@friend", NickName: "小明", RemarkName: "老板"}, &User{UserName: "@stranger", NickName: "路人"},
+		&User{UserName: "@imposter", NickName: "老板", RemarkName: "小王"})
+	self.bot = bot
+	bot.self.Store(self)
+	return bot
+}
+
+func newCommandMessage(bot *Bot, from, to, content string) *Message {
+	return &Message{MsgType: MsgTypeText, FromUserName: from, ToUserName: to, Content: content, bot: bot}
+}
+
+func TestCommandRouterParse(t *testing.T) {
+	router := NewCommandRouter("/")
+	tests := []struct {
+		content string
+		name    string
+		args    []string
+		ok      bool
+	}{
+		{"/mute 2h", "mute", []string{"2h"}, true},
+		{"  /Status  ", "status", []string{}, true},
+		{"/mute 工作群  30m", "mute", []string{"工作群", "30m"}, true},
+		{"/", "", nil, false},
+		{"mute 2h", "", nil, false},
+	}
+	for _, tt := range tests {
+		name, args, ok := router.Parse(tt.content)
+		if name != tt.name || ok != tt.ok || (ok && !reflect.DeepEqual(args, tt.args)) {
+			t.Errorf("Parse(%q) = %q %v %v", tt.content, name, args, ok)
+		}
+	}
+}
+
+func TestCommandRouterServe(t *testing.T) {
+	bot := newCommandTestBot()
+	router := NewCommandRouter("/")
+	var replies []string
+	router.reply = func(msg *Message, text string) error {
+		replies = append(replies, text)
+		return nil
+	}
+	var gotArgs []string
+	router.Handle("mute", "屏蔽群消息", func(cmd *Command) (string, error) {
+		gotArgs = cmd.Args
+		return "已屏蔽", nil
+	})
+	router.Handle("fail", "总是失败", func(cmd *Command) (string, error) {
+		return "", errors.New("boom")
+	})
+	dispatcher := NewMessageMatchDispatcher()
+	router.Register(dispatcher)
+	var forwarded []string
+	dispatcher.RegisterHandler(func(*Message) bool { return true }, func(ctx *MessageContext) {
+		forwarded = append(forwarded, ctx.Content)
+	})
+
+	dispatcher.Dispatch(newCommandMessage(bot, "@self", "filehelper", "/mute 工作群 2h"))
+	dispatcher.Dispatch(newCommandMessage(bot, "@self", "filehelper", "/fail"))
+	dispatcher.Dispatch(newCommandMessage(bot, "@self", "filehelper", "/help"))
+	// 没有注册的命令和没有权限的发送者都当作普通消息
+	dispatcher.Dispatch(newCommandMessage(bot, "@self", "filehelper", "/unknown"))
+	dispatcher.Dispatch(newCommandMessage(bot, "@friend", "@self", "/mute 2h"))
+
+	if !reflect.DeepEqual(gotArgs, []string{"工作群", "2h"}) {
+		t.Errorf("args: %v", gotArgs)
+	}
+	if len(replies) != 3 || replies[0] != "已屏蔽" || replies[1] != "命令执行失败: boom" {
+		t.Fatalf("replies: %q", replies)
+	}
+	if !strings.Contains(replies[2], "/mute  屏蔽群消息") || !strings.HasPrefix(replies[2], "可用命令:\n/help") {
+		t.Errorf("help: %q", replies[2])
+	}
+	if !reflect.DeepEqual(forwarded, []string{"/unknown", "/mute 2h"}) {
+		t.Errorf("forwarded: %q", forwarded)
+	}
+}
+
+func TestAllowFriends(t *testing.T) {
+	bot := newCommandTestBot()
+	allow := AllowFriends("老板", "路人", "小明")
+	tests := []struct {
+		from string
+		want bool
+	}{
+		{"@self", true},
+		{"@friend", true},    // 备注名匹配
+		{"@stranger", true},  // 没有备注名时按昵称匹配
+		{"@imposter", false}, // 设置了备注名时不按昵称匹配
+		{"@unknown", false},
+	}
+	for _, tt := range tests {
+		if got := allow(newCommandMessage(bot, tt.from, "@self", "/status")); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.from, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package openwechat
+
+// ContactChangeType 联系人变更的类型
+type ContactChangeType int
+
+const (
+	ContactAdded      ContactChangeType = iota + 1 // 新增联系人或群
+	ContactRemoved                                 // 删除联系人或退出群
+	ContactRenamed                                 // 联系人或群改名
+	ContactModified                                // 联系人或群的其他信息变更
+	GroupMemberJoined                              // 有成员加入群
+	GroupMemberLeft                                // 有成员退出群
+)
+
+// implement fmt.Stringer
+func (t ContactChangeType) String() string {
+	switch t {
+	case ContactAdded:
+		return "ContactAdded"
+	case ContactRemoved:
+		return "ContactRemoved"
+	case ContactRenamed:
+		return "ContactRenamed"
+	case ContactModified:
+		return "ContactModified"
+	case GroupMemberJoined:
+		return "GroupMemberJoined"
+	case GroupMemberLeft:
+		return "GroupMemberLeft"
+	default:
+		return "Unknown"
+	}
+}
+
+// ContactChange 同步消息中携带的一次联系人变更
+type ContactChange struct {
+	Type        ContactChangeType
+	User        *User   // 变更后的联系人或群, 删除时为删除前的信息
+	OldNickName string  // 改名前的昵称, 仅 ContactRenamed 有值
+	Members     Members // 加入或退出的群成员, 仅 GroupMemberJoined 和 GroupMemberLeft 有值
+}
+
+// ContactChangeHandler 联系人变更的处理函数
+type ContactChangeHandler func(change ContactChange)
+
+// applyContactChanges 将同步消息中变更和删除的联系人应用到缓存, 返回产生的变更事件
+// 通讯录还没有加载过时不做处理, 下次获取时会拉取完整的通讯录
+func (s *Self) applyContactChanges(modified, deleted Members) []ContactChange {
+	if len(modified) == 0 && len(deleted) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.members == nil {
+		return nil
+	}
+	var changes []ContactChange
+	members := append(Members{}, s.members...)
+	for _, user := range modified {
+		user.self = s
+		user.formatEmoji()
+		user.MemberList.init(s)
+		index := members.indexOf(user.UserName)
+		if index < 0 {
+			members = append(members, user)
+			changes = append(changes, ContactChange{Type: ContactAdded, User: user})
+			continue
+		}
+		old := members[index]
+		// 同步消息里可能不带群成员, 这时保留原来的成员列表
+		if len(user.MemberList) == 0 {
+			user.MemberList = old.MemberList
+		}
+		changes = append(changes, diffContact(old, user)...)
+		// 替换为新的对象, 其他 goroutine 可能正在读取旧的对象, 不能原地修改
+		members[index] = user
+	}
+	for _, user := range deleted {
+		for i, member := range members {
+			if member.UserName != user.UserName {
+				continue
+			}
+			members = append(members[:i], members[i+1:]...)
+			changes = append(changes, ContactChange{Type: ContactRemoved, User: member})
+			break
+		}
+	}
+	// 重新生成分类的缓存, 不能置空, 否则下次获取时会重新拉取通讯录
+	s.setMembers(members)
+	return changes
+}
+
+// diffContact 比较联系人变更前后的信息, 变更事件中为变更后的联系人
+func diffContact(old, user *User) []ContactChange {
+	var changes []ContactChange
+	if old.NickName != user.NickName {
+		changes = append(changes, ContactChange{Type: ContactRenamed, User: user, OldNickName: old.NickName})
+	}
+	// 只有变更前后都带有成员列表时才能比较出成员的进出
+	if user.IsGroup() && len(old.MemberList) > 0 && len(user.MemberList) > 0 {
+		if joined := memberDiff(user.MemberList, old.MemberList); len(joined) > 0 {
+			changes = append(changes, ContactChange{Type: GroupMemberJoined, User: user, Members: joined})
+		}
+		if left := memberDiff(old.MemberList, user.MemberList); len(left) > 0 {
+			changes = append(changes, ContactChange{Type: GroupMemberLeft, User: user, Members: left})
+		}
+	}
+	if len(changes) == 0 {
+		changes = append(changes, ContactChange{Type: ContactModified, User: user})
+	}
+	return changes
+}
+
+// indexOf 返回 UserName 对应的成员的位置, 不存在时返回 -1
+func (m Members) indexOf(username string) int {
+	for i, member := range m {
+		if member.UserName == username {
+			return i
+		}
+	}
+	return -1
+}
+
+// memberDiff 返回在 a 中但不在 b 中的成员
+func memberDiff(a, b Members) Members {
+	exist := make(map[string]struct{}, len(b))
+	for _, member := range b {
+		exist[member.UserName] = struct{}{}
+	}
+	var diff Members
+	for _, member := range a {
+		if _, ok := exist[member.UserName]; !ok {
+			diff = append(diff, member)
+		}
+	}
+	return diff
+}
@@ -0,0 +1,105 @@
+package openwechat
+
+import "testing"
+
+func newTestSelf(members ...*User) *Self {
+	self := &Self{User: &User{UserName: "@self", NickName: "me"}}
+	Members(members).init(self)
+	for _, member := range members {
+		member.MemberList.init(self)
+	}
+	self.setMembers(members)
+	return self
+}
+
+func TestApplyContactChanges(t *testing.T) {
+	group := &User{UserName: "@@group", NickName: "旧群名", MemberList: Members{
+		{UserName: "@a", NickName: "a"},
+		{UserName: "@b", NickName: "b"},
+	}}
+	friend := &User{UserName: "@friend", NickName: "friend"}
+	self := newTestSelf(group, friend)
+	groups, err := self.Groups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	held := groups.First()
+
+	changes := self.applyContactChanges(Members{
+		{UserName: "@@group", NickName: "新群名", MemberList: Members{
+			{UserName: "@a", NickName: "a"},
+			{UserName: "@c", NickName: "c"},
+		}},
+		{UserName: "@new", NickName: "new"},
+	}, Members{{UserName: "@friend"}})
+
+	want := []ContactChangeType{ContactRenamed, GroupMemberJoined, GroupMemberLeft, ContactAdded, ContactRemoved}
+	if len(changes) != len(want) {
+		t.Fatalf("got %d changes, want %d: %+v", len(changes), len(want), changes)
+	}
+	for i, change := range changes {
+		if change.Type != want[i] {
+			t.Errorf("change %d: got %s, want %s", i, change.Type, want[i])
+		}
+	}
+	if changes[0].OldNickName != "旧群名" || changes[0].User.NickName != "新群名" {
+		t.Errorf("rename: got %q -> %q", changes[0].OldNickName, changes[0].User.NickName)
+	}
+	if m := changes[1].Members; len(m) != 1 || m[0].UserName != "@c" {
+		t.Errorf("joined: got %v", m)
+	}
+	if m := changes[2].Members; len(m) != 1 || m[0].UserName != "@b" {
+		t.Errorf("left: got %v", m)
+	}
+	if changes[4].User.NickName != "friend" {
+		t.Errorf("removed: got %v", changes[4].User)
+	}
+
+	// 已经拿到的群不会被修改, 重新获取时才能看到变更
+	if held.NickName != "旧群名" {
+		t.Errorf("held group modified in place: %q", held.NickName)
+	}
+	groups, _ = self.Groups()
+	updated := groups.First()
+	if updated.NickName != "新群名" || updated.User != changes[0].User {
+		t.Errorf("group not replaced: %+v", updated)
+	}
+	if _, ok := self.members.GetByUserName("@friend"); ok {
+		t.Error("deleted friend still cached")
+	}
+	if friends, _ := self.Friends(); friends.Count() != 1 || friends.First().UserName != "@new" {
+		t.Errorf("friends: got %v", friends)
+	}
+	for _, member := range updated.MemberList {
+		if member.self != self {
+			t.Errorf("member %s has no self", member.UserName)
+		}
+	}
+}
+
+func TestApplyContactChangesKeepsMembers(t *testing.T) {
+	group := &User{UserName: "@@group", NickName: "群", MemberList: Members{{UserName: "@a"}}}
+	self := newTestSelf(group)
+
+	changes := self.applyContactChanges(Members{{UserName: "@@group", NickName: "群", RemarkName: "备注"}}, nil)
+	if len(changes) != 1 || changes[0].Type != ContactModified {
+		t.Fatalf("got %+v", changes)
+	}
+	updated, _ := self.members.GetByUserName("@@group")
+	if updated.RemarkName != "备注" || len(updated.MemberList) != 1 {
+		t.Errorf("got remark %q and %d members", updated.RemarkName, len(updated.MemberList))
+	}
+	if group.RemarkName != "" {
+		t.Error("old group modified in place")
+	}
+}
+
+func TestApplyContactChangesNotLoaded(t *testing.T) {
+	self := &Self{User: &User{UserName: "@self"}}
+	if changes := self.applyContactChanges(Members{{UserName: "@a"}}, nil); changes != nil {
+		t.Errorf("got %+v", changes)
+	}
+	if self.members != nil {
+		t.Error("members should stay unloaded")
+	}
+}
@@ -105,6 +105,8 @@ type WebWxSyncResponse struct {
 	SyncKey                *SyncKey
 	BaseResponse           BaseResponse
 	ModChatRoomMemberList  Members
+	ModContactList         Members
+	DelContactList         Members
 	AddMsgList             []*Message
 }
 
@@ -0,0 +1,516 @@
+package openwechat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWeChat 进程内的网页版微信服务端, 作为 http.RoundTripper 接管 Client 的所有请求,
+// 按路径分发, 不区分 login.wx.qq.com、wx.qq.com、webpush.wx.qq.com 等域名
+type fakeWeChat struct {
+	t   *testing.T
+	mux *http.ServeMux
+
+	mu         sync.Mutex
+	uuid       string
+	loginCodes []LoginCode // 登录轮询依次返回的状态码, 用完后返回登录成功
+	self       *User
+	contacts   Members
+	syncKey    int64
+	pending    []*fakeSync // 等待 webwxsync 返回的数据
+	notify     chan struct{}
+	loggedOut  bool
+	syncRet    string // synccheck 返回的 retcode, 为空时为 "0"
+	sent       []*SendMessage
+	oplogs     []map[string]any
+	uploads    []fakeUpload
+	requests   map[string]int // 按路径统计的请求次数
+
+	uploadDelay       time.Duration           // 每个分块处理的时间, 用于检查并发上传
+	uploadFailures    map[int]int             // 分块 -> 还需要返回失败的次数
+	uploadErrors      map[int]int             // 分块 -> 还需要返回 500 的次数
+	uploadInFlight    int                     // 正在处理的分块数
+	uploadMaxInFlight int                     // 同时处理的最多分块数
+	chunksByMd5       map[string]map[int]bool // 文件 md5 -> 收到的分块
+}
+
+// fakeSync 一次 webwxsync 返回的内容
+type fakeSync struct {
+	AddMsgList     []*fakeMessage
+	ModContactList Members
+	DelContactList Members
+}
+
+// fakeMessage webwxsync 中返回的消息
+type fakeMessage struct {
+	MsgId        string
+	FromUserName string
+	ToUserName   string
+	MsgType      MessageType
+	Content      string
+	CreateTime   int64
+}
+
+// fakeUpload 收到的一次分块上传
+type fakeUpload struct {
+	Name   string
+	Chunk  int
+	Chunks int
+	Md5    string
+	Size   int64
+	Data   []byte
+}
+
+func newFakeWeChat(t *testing.T) *fakeWeChat {
+	f := &fakeWeChat{
+		t:          t,
+		mux:        http.NewServeMux(),
+		uuid:       "fake-uuid==",
+		loginCodes: []LoginCode{LoginCodeWait, LoginCodeScanned},
+		self:       &User{Uin: 10001, UserName: "@self", NickName: "测试账号"},
+		syncKey:    1,
+		notify:     make(chan struct{}, 1),
+		requests:   make(map[string]int),
+
+		uploadFailures: make(map[int]int),
+		uploadErrors:   make(map[int]int),
+		chunksByMd5:    make(map[string]map[int]bool),
+	}
+	f.mux.HandleFunc("/jslogin", f.serveJsLogin)
+	f.mux.HandleFunc("/cgi-bin/mmwebwx-bin/login", f.serveLogin)
+	f.mux.HandleFunc("/cgi-bin/mmwebwx-bin/webwxnewloginpage", f.serveNewLoginPage)
+	f.mux.HandleFunc(webwxinit, f.serveInit)
+	f.mux.HandleFunc(webwxstatusnotify, f.serveOK)
+	f.mux.HandleFunc(synccheck, f.serveSyncCheck)
+	f.mux.HandleFunc(webwxsync, f.serveSync)
+	f.mux.HandleFunc(webwxgetcontact, f.serveGetContact)
+	f.mux.HandleFunc(webwxbatchgetcontact, f.serveBatchGetContact)
+	f.mux.HandleFunc(webwxsendmsg, f.serveSendMsg)
+	f.mux.HandleFunc(webwxsendmsgimg, f.serveSendMsg)
+	f.mux.HandleFunc(webwxsendappmsg, f.serveSendMsg)
+	f.mux.HandleFunc(webwxsendvideomsg, f.serveSendMsg)
+	f.mux.HandleFunc(webwxuploadmedia, f.serveUpload)
+	f.mux.HandleFunc(webwxcheckupload, f.serveCheckUpload)
+	f.mux.HandleFunc(webwxoplog, f.serveOplog)
+	f.mux.HandleFunc(webwxlogout, f.serveLogout)
+	return f
+}
+
+// RoundTrip 实现 http.RoundTripper, 请求不会离开当前进程
+func (f *fakeWeChat) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	f.requests[req.URL.Path]++
+	f.mu.Unlock()
+	recorder := httptest.NewRecorder()
+	f.mux.ServeHTTP(recorder, req)
+	if recorder.Code == http.StatusNotFound {
+		f.t.Errorf("fake wechat: unexpected request %s %s", req.Method, req.URL)
+	}
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	resp := recorder.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// ServeHTTP 实现 http.Handler, 可以配合 httptest.NewServer 使用
+func (f *fakeWeChat) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests[r.URL.Path]++
+	f.mu.Unlock()
+	f.mux.ServeHTTP(w, r)
+}
+
+// newBot 创建一个请求都发往当前服务端的 Bot
+func (f *fakeWeChat) newBot(prepares ...BotPreparer) *Bot {
+	bot := DefaultBot(append([]BotPreparer{Desktop}, prepares...)...)
+	bot.UUIDCallback = nil
+	bot.Caller.Client.HTTPClient().Transport = f
+	// 等待轮询和退出都结束, 避免测试结束后后台的 goroutine 还在访问 t 和服务端
+	f.t.Cleanup(func() {
+		if bot.Alive() {
+			bot.Exit()
+		}
+		if bot.syncDone != nil {
+			<-bot.syncDone
+			<-bot.Context().Done()
+		}
+	})
+	return bot
+}
+
+// login 创建 Bot 并完成扫码登录
+func (f *fakeWeChat) login(prepares ...BotPreparer) *Bot {
+	f.t.Helper()
+	bot := f.newBot(prepares...)
+	if err := bot.Login(); err != nil {
+		f.t.Fatalf("login: %v", err)
+	}
+	return bot
+}
+
+// push 让下一次 synccheck 返回有新消息, webwxsync 返回 sync 的内容
+func (f *fakeWeChat) push(sync *fakeSync) {
+	f.mu.Lock()
+	f.pending = append(f.pending, sync)
+	f.mu.Unlock()
+	select {
+	case f.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pushText 推送一条文本消息
+func (f *fakeWeChat) pushText(from, to, content string) {
+	f.mu.Lock()
+	f.syncKey++
+	id := strconv.FormatInt(f.syncKey, 10)
+	f.mu.Unlock()
+	f.push(&fakeSync{AddMsgList: []*fakeMessage{{
+		MsgId:        id,
+		FromUserName: from,
+		ToUserName:   to,
+		MsgType:      MsgTypeText,
+		Content:      content,
+		CreateTime:   time.Now().Unix(),
+	}}})
+}
+
+// kick 模拟在手机上退出登录, 之后 synccheck 返回 1101
+func (f *fakeWeChat) kick() {
+	f.mu.Lock()
+	f.syncRet = "1101"
+	f.mu.Unlock()
+	select {
+	case f.notify <- struct{}{}:
+	default:
+	}
+}
+
+// sentMessages 收到的所有发送消息请求
+func (f *fakeWeChat) sentMessages() []*SendMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*SendMessage(nil), f.sent...)
+}
+
+// uploadedChunks 返回收到的所有分块
+func (f *fakeWeChat) uploadedChunks() []fakeUpload {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeUpload(nil), f.uploads...)
+}
+
+// waitSent 等待收到 n 条发送消息请求
+func (f *fakeWeChat) waitSent(n int) []*SendMessage {
+	f.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if sent := f.sentMessages(); len(sent) >= n {
+			return sent
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	f.t.Fatalf("timed out waiting for %d sent messages, got %d", n, len(f.sentMessages()))
+	return nil
+}
+
+func (f *fakeWeChat) requestCount(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[path]
+}
+
+func (f *fakeWeChat) writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "text/plain")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		f.t.Errorf("fake wechat: encode response: %v", err)
+	}
+}
+
+func (f *fakeWeChat) serveJsLogin(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("appid") != appId {
+		http.Error(w, "bad appid", http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintf(w, `window.QRLogin.code = 200; window.QRLogin.uuid = "%s";`, f.uuid)
+}
+
+func (f *fakeWeChat) serveLogin(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("uuid") != f.uuid {
+		fmt.Fprint(w, `window.code=400;`)
+		return
+	}
+	f.mu.Lock()
+	code := LoginCodeSuccess
+	if len(f.loginCodes) > 0 {
+		code = f.loginCodes[0]
+		f.loginCodes = f.loginCodes[1:]
+	}
+	f.mu.Unlock()
+	switch code {
+	case LoginCodeSuccess:
+		fmt.Fprintf(w, "window.code=200;\nwindow.redirect_uri=\"%s/cgi-bin/mmwebwx-bin/webwxnewloginpage?ticket=fake-ticket&uuid=%s&lang=zh_CN&scan=1\";", f.redirectHost(r), f.uuid)
+	case LoginCodeScanned:
+		fmt.Fprint(w, "window.code=201;window.userAvatar = 'data:img/jpg;base64,';")
+	default:
+		fmt.Fprintf(w, "window.code=%s;", string(code))
+	}
+}
+
+// local 判断请求是否通过 httptest.Server 发送, 而不是直接经过 RoundTrip
+func (f *fakeWeChat) local(r *http.Request) bool {
+	return !strings.HasSuffix(r.Host, "qq.com")
+}
+
+// redirectHost 登录成功后跳转的域名
+func (f *fakeWeChat) redirectHost(r *http.Request) string {
+	if f.local(r) {
+		return "http://" + r.Host
+	}
+	return "https://wx.qq.com"
+}
+
+func (f *fakeWeChat) serveNewLoginPage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("ticket") != "fake-ticket" {
+		fmt.Fprint(w, `<error><ret>1</ret><message>invalid ticket</message></error>`)
+		return
+	}
+	for _, cookie := range []*http.Cookie{
+		{Name: "wxuin", Value: strconv.FormatInt(f.self.Uin, 10)},
+		{Name: "wxsid", Value: "fake-sid"},
+		{Name: "webwx_data_ticket", Value: "fake-data-ticket"},
+	} {
+		if !f.local(r) {
+			cookie.Domain = "wx.qq.com"
+		}
+		cookie.Path = "/"
+		http.SetCookie(w, cookie)
+	}
+	fmt.Fprintf(w, `<error><ret>0</ret><message></message><skey>@crypt_fake</skey><wxsid>fake-sid</wxsid><wxuin>%d</wxuin><pass_ticket>fake-pass-ticket</pass_ticket><isgrayscale>1</isgrayscale></error>`, f.self.Uin)
+}
+
+func (f *fakeWeChat) currentSyncKey() *SyncKey {
+	return &SyncKey{Count: 1, List: []struct{ Key, Val int64 }{{Key: 1, Val: f.syncKey}}}
+}
+
+func (f *fakeWeChat) serveInit(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writeJSON(w, map[string]any{
+		"BaseResponse": BaseResponse{},
+		"User":         f.self,
+		"SyncKey":      f.currentSyncKey(),
+		"SKey":         "@crypt_fake",
+		"ContactList":  Members{},
+	})
+}
+
+func (f *fakeWeChat) serveOK(w http.ResponseWriter, r *http.Request) {
+	f.writeJSON(w, map[string]any{"BaseResponse": BaseResponse{}})
+}
+
+// serveSyncCheck 长轮询, 有新的数据或者超时后返回
+func (f *fakeWeChat) serveSyncCheck(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("sid") != "fake-sid" || r.URL.Query().Get("skey") != "@crypt_fake" {
+		fmt.Fprint(w, `window.synccheck={retcode:"1101",selector:"0"}`)
+		return
+	}
+	timer := time.NewTimer(100 * time.Millisecond)
+	defer timer.Stop()
+	for {
+		f.mu.Lock()
+		ret, pending := f.syncRet, len(f.pending)
+		f.mu.Unlock()
+		switch {
+		case ret != "":
+			fmt.Fprintf(w, `window.synccheck={retcode:"%s",selector:"0"}`, ret)
+			return
+		case pending > 0:
+			fmt.Fprint(w, `window.synccheck={retcode:"0",selector:"2"}`)
+			return
+		}
+		select {
+		case <-f.notify:
+		case <-timer.C:
+			fmt.Fprint(w, `window.synccheck={retcode:"0",selector:"0"}`)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (f *fakeWeChat) serveSync(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sync := &fakeSync{}
+	if len(f.pending) > 0 {
+		sync, f.pending = f.pending[0], f.pending[1:]
+	}
+	f.syncKey++
+	f.writeJSON(w, map[string]any{
+		"BaseResponse":   BaseResponse{},
+		"SyncKey":        f.currentSyncKey(),
+		"AddMsgCount":    len(sync.AddMsgList),
+		"AddMsgList":     sync.AddMsgList,
+		"ModContactList": sync.ModContactList,
+		"DelContactList": sync.DelContactList,
+	})
+}
+
+func (f *fakeWeChat) serveGetContact(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writeJSON(w, map[string]any{
+		"BaseResponse": BaseResponse{},
+		"MemberCount":  len(f.contacts),
+		"MemberList":   f.contacts,
+		"Seq":          0,
+	})
+}
+
+func (f *fakeWeChat) serveBatchGetContact(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		List []struct{ UserName string }
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list := make(Members, 0, len(body.List))
+	for _, item := range body.List {
+		if user, ok := f.contacts.GetByUserName(item.UserName); ok {
+			list = append(list, user)
+		}
+	}
+	f.writeJSON(w, map[string]any{"BaseResponse": BaseResponse{}, "Count": len(list), "ContactList": list})
+}
+
+func (f *fakeWeChat) serveSendMsg(w http.ResponseWriter, r *http.Request) {
+	var body struct{ Msg *SendMessage }
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Msg == nil {
+		http.Error(w, "bad message", http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.sent = append(f.sent, body.Msg)
+	id := len(f.sent)
+	f.mu.Unlock()
+	f.writeJSON(w, MessageResponse{MsgID: strconv.Itoa(id), LocalID: body.Msg.LocalID})
+}
+
+func (f *fakeWeChat) serveUpload(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.FormValue("webwx_data_ticket") != "fake-data-ticket" {
+		f.writeJSON(w, UploadResponse{BaseResponse: BaseResponse{Ret: ticketError}})
+		return
+	}
+	file, _, err := r.FormFile("filename")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer func() { _ = file.Close() }()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	upload := fakeUpload{Name: r.FormValue("name"), Data: data}
+	upload.Chunk, _ = strconv.Atoi(r.FormValue("chunk"))
+	upload.Chunks, _ = strconv.Atoi(r.FormValue("chunks"))
+	var request struct {
+		FileMd5  string
+		TotalLen int64
+	}
+	if err = json.Unmarshal([]byte(r.FormValue("uploadmediarequest")), &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	upload.Md5, upload.Size = request.FileMd5, request.TotalLen
+
+	f.mu.Lock()
+	f.uploadInFlight++
+	if f.uploadInFlight > f.uploadMaxInFlight {
+		f.uploadMaxInFlight = f.uploadInFlight
+	}
+	delay := f.uploadDelay
+	f.mu.Unlock()
+	time.Sleep(delay)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.uploadInFlight--
+	if f.uploadErrors[upload.Chunk] > 0 {
+		f.uploadErrors[upload.Chunk]--
+		http.Error(w, "fake server error", http.StatusInternalServerError)
+		return
+	}
+	if f.uploadFailures[upload.Chunk] > 0 {
+		f.uploadFailures[upload.Chunk]--
+		f.writeJSON(w, UploadResponse{BaseResponse: BaseResponse{Ret: 1, ErrMsg: "fake upload failure"}})
+		return
+	}
+	f.uploads = append(f.uploads, upload)
+	if f.chunksByMd5[upload.Md5] == nil {
+		f.chunksByMd5[upload.Md5] = make(map[int]bool)
+	}
+	f.chunksByMd5[upload.Md5][upload.Chunk] = true
+	f.writeJSON(w, UploadResponse{MediaId: "@fake_media_" + upload.Name})
+}
+
+// serveCheckUpload 文件的所有分块都收到过时返回 MediaId
+func (f *fakeWeChat) serveCheckUpload(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		FileMd5  string
+		FileName string
+		FileSize int64
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	chunks := int((body.FileSize + chunkSize - 1) / chunkSize)
+	var resp UploadResponse
+	if len(f.chunksByMd5[body.FileMd5]) == chunks {
+		resp.MediaId = "@fake_media_checked_" + body.FileName
+	}
+	f.writeJSON(w, resp)
+}
+
+func (f *fakeWeChat) serveOplog(w http.ResponseWriter, r *http.Request) {
+	var body map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	delete(body, "BaseRequest")
+	f.mu.Lock()
+	f.oplogs = append(f.oplogs, body)
+	f.mu.Unlock()
+	f.serveOK(w, r)
+}
+
+func (f *fakeWeChat) serveLogout(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.loggedOut = true
+	f.mu.Unlock()
+	f.serveOK(w, r)
+}
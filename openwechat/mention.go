@@ -0,0 +1,156 @@
+package openwechat
+
+import (
+	"sort"
+	"strings"
+)
+
+// MentionKind @ 的对象类型
+type MentionKind int
+
+const (
+	MentionMe    MentionKind = iota + 1 // @ 当前登录的用户
+	MentionAll                          // @所有人
+	MentionOther                        // @ 其他群成员
+)
+
+// mentionSeparator 微信客户端在 @ 的名称后面插入的分隔符
+const mentionSeparator = "\u2005"
+
+// mentionAllNames @所有人 在不同语言客户端中的写法
+var mentionAllNames = []string{"所有人", "All", "all"}
+
+// maxMentionNameLen 未匹配到群成员时, 名称的最大字节数
+const maxMentionNameLen = 96
+
+// Mention 消息中的一次 @
+type Mention struct {
+	Kind MentionKind
+	// Name @ 后面的名称, 不包含 @ 和分隔符
+	Name string
+	// UserName 被 @ 的群成员, 未匹配到群成员或 @所有人 时为空
+	UserName string
+	// Offset @ 在消息内容中的字节偏移
+	Offset int
+	// Length @ 和名称的字节长度, 不包含分隔符
+	Length int
+}
+
+// Mentions 消息中所有的 @
+type Mentions []Mention
+
+// Me 是否 @ 了当前登录的用户
+func (m Mentions) Me() bool {
+	return m.has(MentionMe)
+}
+
+// All 是否 @所有人
+func (m Mentions) All() bool {
+	return m.has(MentionAll)
+}
+
+// Others 是否 @ 了其他群成员
+func (m Mentions) Others() bool {
+	return m.has(MentionOther)
+}
+
+func (m Mentions) has(kind MentionKind) bool {
+	for _, mention := range m {
+		if mention.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+type mentionCandidate struct {
+	name     string
+	userName string
+}
+
+// ParseMentions 根据群成员列表解析消息内容中的 @
+// content 为处理过 emoji 的消息内容, selfUserName 为当前登录用户的 UserName
+// 群成员的名称优先匹配最长的群昵称或者昵称, 因此名称中包含空格或者 emoji 也可以正确匹配
+func ParseMentions(content string, members Members, selfUserName string) Mentions {
+	if !strings.Contains(content, "@") {
+		return nil
+	}
+	candidates := mentionCandidates(members, selfUserName)
+	var mentions Mentions
+	for offset := 0; offset < len(content); {
+		index := strings.IndexByte(content[offset:], '@')
+		if index < 0 {
+			break
+		}
+		offset += index
+		mention, ok := matchMention(content[offset+1:], candidates, selfUserName)
+		if !ok {
+			offset++
+			continue
+		}
+		mention.Offset = offset
+		mention.Length = len(mention.Name) + 1
+		mentions = append(mentions, mention)
+		offset += mention.Length
+	}
+	return mentions
+}
+
+// mentionCandidates 群成员可能被 @ 的名称, 按长度从长到短排列, 长度相同时自己优先
+func mentionCandidates(members Members, selfUserName string) []mentionCandidate {
+	var candidates []mentionCandidate
+	for _, member := range members {
+		names := []string{FormatEmoji(member.DisplayName), FormatEmoji(member.NickName)}
+		if names[0] == names[1] {
+			names = names[:1]
+		}
+		for _, name := range names {
+			if name != "" {
+				candidates = append(candidates, mentionCandidate{name: name, userName: member.UserName})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if len(candidates[i].name) != len(candidates[j].name) {
+			return len(candidates[i].name) > len(candidates[j].name)
+		}
+		return candidates[i].userName == selfUserName && candidates[j].userName != selfUserName
+	})
+	return candidates
+}
+
+// matchMention 匹配 @ 之后的内容
+func matchMention(rest string, candidates []mentionCandidate, selfUserName string) (Mention, bool) {
+	for _, name := range mentionAllNames {
+		if strings.HasPrefix(rest, name) && isMentionBoundary(rest[len(name):]) {
+			return Mention{Kind: MentionAll, Name: name}, true
+		}
+	}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(rest, candidate.name) && isMentionBoundary(rest[len(candidate.name):]) {
+			kind := MentionOther
+			if selfUserName != "" && candidate.userName == selfUserName {
+				kind = MentionMe
+			}
+			return Mention{Kind: kind, Name: candidate.name, UserName: candidate.userName}, true
+		}
+	}
+	// 不在群成员列表中, 只有出现分隔符时才认为是 @, 避免把邮箱地址当成 @
+	index := strings.Index(rest, mentionSeparator)
+	if index <= 0 || index > maxMentionNameLen || strings.ContainsAny(rest[:index], "@\n") {
+		return Mention{}, false
+	}
+	return Mention{Kind: MentionOther, Name: rest[:index]}, true
+}
+
+// isMentionBoundary 名称之后必须是分隔符、空白或者结尾
+func isMentionBoundary(s string) bool {
+	if s == "" || strings.HasPrefix(s, mentionSeparator) {
+		return true
+	}
+	switch s[0] {
+	case ' ', '\n', '\t':
+		return true
+	}
+	return false
+}
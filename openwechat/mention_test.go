@@ -0,0 +1,93 @@
+package openwechat
+
+import "testing"
+
+func TestParseMentions(t *testing.T) {
+	members := Members{
+		{UserName: "@self", NickName: "小明", DisplayName: "明 🐯 Ming"},
+		{UserName: "@tom", NickName: "Tom"},
+		{UserName: "@tom2", NickName: "Tom Smith"},
+		{UserName: "@li", NickName: `李四<span class="emoji emoji1f604"></span>`},
+	}
+	type want struct {
+		kind     MentionKind
+		name     string
+		userName string
+		offset   int
+	}
+	cases := []struct {
+		name    string
+		content string
+		want    []want
+	}{
+		{
+			name:    "display name with emoji and space",
+			content: "@明 🐯 Ming\u2005晚上吃什么",
+			want:    []want{{MentionMe, "明 🐯 Ming", "@self", 0}},
+		},
+		{
+			name:    "nickname when display name is set",
+			content: "你好 @小明",
+			want:    []want{{MentionMe, "小明", "@self", len("你好 ")}},
+		},
+		{
+			name:    "all",
+			content: "@所有人\u2005明天放假",
+			want:    []want{{MentionAll, "所有人", "", 0}},
+		},
+		{
+			name:    "longest name wins",
+			content: "@Tom Smith\u2005@Tom\u2005开会",
+			want: []want{
+				{MentionOther, "Tom Smith", "@tom2", 0},
+				{MentionOther, "Tom", "@tom", len("@Tom Smith\u2005")},
+			},
+		},
+		{
+			name:    "emoji in member nickname",
+			content: "@李四😄\u2005收到",
+			want:    []want{{MentionOther, "李四😄", "@li", 0}},
+		},
+		{
+			name:    "unknown member with separator",
+			content: "@王五\u2005在吗",
+			want:    []want{{MentionOther, "王五", "", 0}},
+		},
+		{
+			name:    "email is not a mention",
+			content: "发到 tom@example.com 就行",
+		},
+		{
+			name:    "prefix of a name is not a mention",
+			content: "@小明明 你好",
+		},
+		{
+			name:    "no mention",
+			content: "普通消息",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := ParseMentions(c.content, members, "@self")
+			if len(got) != len(c.want) {
+				t.Fatalf("got %+v, want %+v", got, c.want)
+			}
+			for i, w := range c.want {
+				g := got[i]
+				if g.Kind != w.kind || g.Name != w.name || g.UserName != w.userName || g.Offset != w.offset {
+					t.Errorf("mention %d: got %+v, want %+v", i, g, w)
+				}
+				if c.content[g.Offset:g.Offset+g.Length] != "@"+w.name {
+					t.Errorf("mention %d: offset and length do not cover %q", i, "@"+w.name)
+				}
+			}
+		})
+	}
+}
+
+func TestMentionsHelpers(t *testing.T) {
+	mentions := ParseMentions("@所有人 @小明", Members{{UserName: "@self", NickName: "小明"}}, "@self")
+	if !mentions.All() || !mentions.Me() || mentions.Others() {
+		t.Errorf("got %+v", mentions)
+	}
+}
@@ -17,8 +17,8 @@ import (
 )
 
 type Message struct {
-	isAt    bool
-	AppInfo struct {
+	mentions Mentions
+	AppInfo  struct {
 		Type  int
 		AppID string
 	}
@@ -64,7 +64,7 @@ func (m *Message) Sender() (*User, error) {
 		return m.Owner().User, nil
 	}
 	// 首先尝试从缓存里面查找, 如果没有找到则从服务器获取
-	members, err := m.bot.self.Members()
+	members, err := m.Owner().Members()
 	if err != nil {
 		return nil, err
 	}
@@ -110,8 +110,8 @@ func (m *Message) SenderInGroup() (*User, error) {
 // 如果消息是好友消息，则返回好友
 // 如果消息是系统消息，则返回当前用户
 func (m *Message) Receiver() (*User, error) {
-	if m.IsSystem() || m.ToUserName == m.bot.self.UserName {
-		return m.bot.self.User, nil
+	if m.IsSystem() || m.ToUserName == m.Owner().UserName {
+		return m.Owner().User, nil
 	}
 	// https://github.com/eatmoreapple/openwechat/issues/113
 	if m.ToUserName == FileHelper {
@@ -173,7 +173,7 @@ func (m *Message) IsSelfSendToGroup() bool {
 func (m *Message) ReplyText(content string) (*SentMessage, error) {
 	// 判断是否由自己发送
 	username := m.FromUserName
-	if m.IsSelfSendToGroup() {
+	if m.IsSendBySelf() {
 		username = m.ToUserName
 	}
 	return m.Owner().sendTextToUser(username, content)
@@ -183,7 +183,7 @@ func (m *Message) ReplyText(content string) (*SentMessage, error) {
 func (m *Message) ReplyImage(file io.Reader) (*SentMessage, error) {
 	// 判断是否由自己发送
 	username := m.FromUserName
-	if m.IsSelfSendToGroup() {
+	if m.IsSendBySelf() {
 		username = m.ToUserName
 	}
 	return m.Owner().sendImageToUser(username, file)
@@ -193,7 +193,7 @@ func (m *Message) ReplyImage(file io.Reader) (*SentMessage, error) {
 func (m *Message) ReplyVideo(file io.Reader) (*SentMessage, error) {
 	// 判断是否由自己发送
 	username := m.FromUserName
-	if m.IsSelfSendToGroup() {
+	if m.IsSendBySelf() {
 		username = m.ToUserName
 	}
 	return m.Owner().sendVideoToUser(username, file)
@@ -203,7 +203,7 @@ func (m *Message) ReplyVideo(file io.Reader) (*SentMessage, error) {
 func (m *Message) ReplyFile(file io.Reader) (*SentMessage, error) {
 	// 判断是否由自己发送
 	username := m.FromUserName
-	if m.IsSelfSendToGroup() {
+	if m.IsSendBySelf() {
 		username = m.ToUserName
 	}
 	return m.Owner().sendFileToUser(username, file)
@@ -495,30 +495,6 @@ func (m *Message) init(bot *Bot) {
 				data := strings.Split(m.Content, ":<br/>")
 				m.Content = strings.Join(data[1:], "")
 				m.senderUserNameInGroup = data[0]
-				if strings.Contains(m.Content, "@") {
-					sender, err := m.Sender()
-					if err == nil {
-						receiver := sender.MemberList.SearchByUserName(1, m.ToUserName)
-						if receiver != nil {
-							displayName := receiver.First().DisplayName
-							if displayName == "" {
-								displayName = receiver.First().NickName
-							}
-							var atFlag string
-							msgContent := FormatEmoji(m.Content)
-							atName := FormatEmoji(displayName)
-							if strings.Contains(msgContent, "\u2005") {
-								atFlag = "@" + atName + "\u2005"
-							} else {
-								atFlag = "@" + atName
-							}
-							m.isAt = strings.Contains(msgContent, atFlag) || strings.HasSuffix(msgContent, atFlag)
-						}
-					}
-				}
-			} else {
-				// 这块不严谨，但是只能这么干了
-				m.isAt = strings.Contains(m.Content, "@") || strings.Contains(m.Content, "\u2005")
 			}
 		}
 	}
@@ -528,6 +504,31 @@ func (m *Message) init(bot *Bot) {
 	m.Content = html.UnescapeString(m.Content)
 	// 处理消息中的emoji表情
 	m.Content = FormatEmoji(m.Content)
+	// 解析群消息中的@
+	if m.IsSendByGroup() && m.IsText() && strings.Contains(m.Content, "@") {
+		m.mentions = ParseMentions(m.Content, m.groupMembers(), m.Owner().UserName)
+	}
+}
+
+// groupMembers 获取群消息所在群的成员列表, 获取失败时返回 nil
+func (m *Message) groupMembers() Members {
+	if !m.IsSendBySelf() {
+		group, err := m.Sender()
+		if err != nil {
+			return nil
+		}
+		return group.MemberList
+	}
+	// 自己发送的消息不从服务器获取, 只使用缓存
+	members, err := m.Owner().Members()
+	if err != nil {
+		return nil
+	}
+	group, exist := members.GetByUserName(m.ToUserName)
+	if !exist {
+		return nil
+	}
+	return group.MemberList
 }
 
 // SendMessage 发送消息的结构体
@@ -819,8 +820,17 @@ func (m *Message) String() string {
 }
 
 // IsAt 判断消息是否为@消息
+// 收到的消息判断是否@了自己, 自己发送的消息判断是否@了其他人
 func (m *Message) IsAt() bool {
-	return m.isAt
+	if m.IsSendBySelf() {
+		return len(m.mentions) > 0
+	}
+	return m.mentions.Me()
+}
+
+// Mentions 返回群消息中所有的@
+func (m *Message) Mentions() Mentions {
+	return m.mentions
 }
 
 // IsPaiYiPai 判断消息是否为拍一拍
@@ -856,7 +866,7 @@ func (m *Message) Bot() *Bot {
 
 // Owner 返回当前消息的拥有者
 func (m *Message) Owner() *Self {
-	return m.Bot().self
+	return m.Bot().self.Load()
 }
 
 func (m *Message) Context() context.Context {
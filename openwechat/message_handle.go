@@ -89,8 +89,9 @@ type matchNodes []*matchNode
 //	bot := DefaultBot()
 //	bot.MessageHandler = DispatchMessage(dispatcher)
 type MessageMatchDispatcher struct {
-	async      bool
-	matchNodes matchNodes
+	async       bool
+	matchNodes  matchNodes
+	middlewares MessageContextHandlerGroup
 }
 
 // NewMessageMatchDispatcher Constructor
@@ -114,6 +115,10 @@ func (m *MessageMatchDispatcher) Dispatch(msg *Message) {
 			group = append(group, node.group...)
 		}
 	}
+	// 中间件包裹在匹配上的处理函数外层
+	if len(group) > 0 && len(m.middlewares) > 0 {
+		group = append(append(MessageContextHandlerGroup{}, m.middlewares...), group...)
+	}
 	ctx := &MessageContext{Message: msg, messageHandlers: group}
 	if m.async {
 		go m.do(ctx)
@@ -0,0 +1,181 @@
+package openwechat
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Use 注册全局的消息处理中间件, 按注册顺序执行
+// 中间件包裹在所有匹配上的消息处理函数外层, 需要调用 ctx.Next() 才会继续执行后面的处理函数
+// 没有匹配上任何处理函数的消息不会经过中间件
+//
+//	dispatcher.Use(openwechat.RecoveryMiddleware(nil), openwechat.TimeoutMiddleware(time.Minute))
+func (m *MessageMatchDispatcher) Use(middlewares ...MessageContextHandler) {
+	m.middlewares = append(m.middlewares, middlewares...)
+}
+
+// RecoveryMiddleware 捕获消息处理函数中的panic, 避免同步消息的goroutine退出
+// onPanic 为空时打印panic的内容和调用栈
+func RecoveryMiddleware(onPanic func(ctx *MessageContext, err any)) MessageContextHandler {
+	return func(ctx *MessageContext) {
+		defer func() {
+			if err := recover(); err != nil {
+				ctx.Abort()
+				if onPanic != nil {
+					onPanic(ctx, err)
+					return
+				}
+				ctx.Bot().Logger().Error("handle message panic", "msg_id", ctx.MsgId, "panic", err, "stack", string(debug.Stack()))
+			}
+		}()
+		ctx.Next()
+	}
+}
+
+// TimeoutMiddleware 为后面的消息处理函数设置超时时间
+// 超时后 Message.Context() 会被取消, 使用这个 context 的网络请求会立即返回,
+// 但不会中断正在执行的处理函数
+func TimeoutMiddleware(timeout time.Duration) MessageContextHandler {
+	return func(ctx *MessageContext) {
+		parent := ctx.Message.Context()
+		timeoutCtx, cancel := context.WithTimeout(parent, timeout)
+		defer cancel()
+		ctx.WithContext(timeoutCtx)
+		defer ctx.WithContext(parent)
+		ctx.Next()
+	}
+}
+
+// LoggingMiddleware 以结构化日志记录每条消息的处理结果和耗时
+// logger 为空时使用 slog.Default()
+func LoggingMiddleware(logger *slog.Logger) MessageContextHandler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(ctx *MessageContext) {
+		start := time.Now()
+		ctx.Next()
+		logger.Info("message handled",
+			slog.String("msg_id", ctx.MsgId),
+			slog.Int("msg_type", int(ctx.MsgType)),
+			slog.String("from", ctx.FromUserName),
+			slog.String("to", ctx.ToUserName),
+			slog.Bool("aborted", ctx.IsAbort()),
+			slog.Duration("duration", time.Since(start)),
+		)
+	}
+}
+
+// MessageMetrics 消息处理的统计
+type MessageMetrics struct {
+	handled  atomic.Uint64
+	aborted  atomic.Uint64
+	duration atomic.Int64
+	mu       sync.Mutex
+	byType   map[MessageType]uint64
+}
+
+// MessageMetricsSnapshot 某一时刻的消息处理统计
+type MessageMetricsSnapshot struct {
+	Handled  uint64                 // 处理的消息数量
+	Aborted  uint64                 // 被中断的消息数量
+	Duration time.Duration          // 处理消息的总耗时
+	ByType   map[MessageType]uint64 // 按消息类型统计的数量
+}
+
+// Middleware 返回记录统计的中间件
+func (m *MessageMetrics) Middleware() MessageContextHandler {
+	return func(ctx *MessageContext) {
+		start := time.Now()
+		ctx.Next()
+		m.handled.Add(1)
+		if ctx.IsAbort() {
+			m.aborted.Add(1)
+		}
+		m.duration.Add(int64(time.Since(start)))
+		m.mu.Lock()
+		if m.byType == nil {
+			m.byType = make(map[MessageType]uint64)
+		}
+		m.byType[ctx.MsgType]++
+		m.mu.Unlock()
+	}
+}
+
+// Snapshot 获取当前的统计
+func (m *MessageMetrics) Snapshot() MessageMetricsSnapshot {
+	snapshot := MessageMetricsSnapshot{
+		Handled:  m.handled.Load(),
+		Aborted:  m.aborted.Load(),
+		Duration: time.Duration(m.duration.Load()),
+		ByType:   make(map[MessageType]uint64),
+	}
+	m.mu.Lock()
+	for msgType, count := range m.byType {
+		snapshot.ByType[msgType] = count
+	}
+	m.mu.Unlock()
+	return snapshot
+}
+
+// RateLimitMiddleware 限制每个 key 在 per 时间内最多处理 limit 条消息, 超出的消息会被中断
+// key 为空时按发送者 FromUserName 限制
+func RateLimitMiddleware(limit int, per time.Duration, key func(msg *Message) string) MessageContextHandler {
+	if limit <= 0 || per <= 0 {
+		panic(fmt.Sprintf("invalid rate limit: %d per %s", limit, per))
+	}
+	if key == nil {
+		key = func(msg *Message) string { return msg.FromUserName }
+	}
+	limiter := &messageRateLimiter{limit: limit, per: per, windows: make(map[string]*rateWindow)}
+	return func(ctx *MessageContext) {
+		if !limiter.allow(key(ctx.Message), time.Now()) {
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// messageRateLimiter 固定窗口计数的限流器
+type messageRateLimiter struct {
+	limit   int
+	per     time.Duration
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+	pruned  time.Time
+}
+
+func (l *messageRateLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// 定期清理过期的窗口, 避免 key 太多时占用内存
+	if now.Sub(l.pruned) > l.per {
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= l.per {
+				delete(l.windows, k)
+			}
+		}
+		l.pruned = now
+	}
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.per {
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	if w.count >= l.limit {
+		return false
+	}
+	w.count++
+	return true
+}
@@ -0,0 +1,112 @@
+package openwechat
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestMessage(from string) *Message {
+	return &Message{
+		MsgId:        "1",
+		MsgType:      MsgTypeText,
+		FromUserName: from,
+		bot:          &Bot{context: context.Background()},
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	dispatcher := NewMessageMatchDispatcher()
+	var calls []string
+	dispatcher.Use(func(ctx *MessageContext) {
+		calls = append(calls, "before")
+		ctx.Next()
+		calls = append(calls, "after")
+	})
+	dispatcher.RegisterHandler(func(*Message) bool { return true }, func(*MessageContext) { calls = append(calls, "a") })
+	dispatcher.RegisterHandler(func(*Message) bool { return false }, func(*MessageContext) { calls = append(calls, "skipped") })
+	dispatcher.RegisterHandler(func(*Message) bool { return true }, func(*MessageContext) { calls = append(calls, "b") })
+
+	dispatcher.Dispatch(newTestMessage("@a"))
+	if got := strings.Join(calls, ","); got != "before,a,b,after" {
+		t.Errorf("got %s", got)
+	}
+
+	// 没有匹配上的消息不经过中间件
+	calls = nil
+	empty := NewMessageMatchDispatcher()
+	empty.Use(func(ctx *MessageContext) { calls = append(calls, "middleware") })
+	empty.Dispatch(newTestMessage("@a"))
+	if len(calls) != 0 {
+		t.Errorf("got %v", calls)
+	}
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	dispatcher := NewMessageMatchDispatcher()
+	var recovered any
+	dispatcher.Use(RecoveryMiddleware(func(ctx *MessageContext, err any) { recovered = err }))
+	ran := false
+	dispatcher.RegisterHandler(func(*Message) bool { return true },
+		func(*MessageContext) { panic("boom") },
+		func(*MessageContext) { ran = true },
+	)
+	dispatcher.Dispatch(newTestMessage("@a"))
+	if recovered != "boom" {
+		t.Errorf("recovered %v", recovered)
+	}
+	if ran {
+		t.Error("handlers after a panic should not run")
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	dispatcher := NewMessageMatchDispatcher()
+	dispatcher.Use(TimeoutMiddleware(10 * time.Millisecond))
+	var err error
+	dispatcher.OnText(func(ctx *MessageContext) {
+		<-ctx.Message.Context().Done()
+		err = ctx.Message.Context().Err()
+	})
+	msg := newTestMessage("@a")
+	dispatcher.Dispatch(msg)
+	if err != context.DeadlineExceeded {
+		t.Errorf("got %v", err)
+	}
+	if msg.Context().Err() != nil {
+		t.Error("context should be restored after the handlers return")
+	}
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	dispatcher := NewMessageMatchDispatcher()
+	dispatcher.Use(LoggingMiddleware(slog.New(slog.NewTextHandler(&buf, nil))))
+	dispatcher.OnText(func(*MessageContext) {})
+	dispatcher.Dispatch(newTestMessage("@a"))
+	if out := buf.String(); !strings.Contains(out, "msg_id=1") || !strings.Contains(out, "from=@a") {
+		t.Errorf("got %s", out)
+	}
+}
+
+func TestMetricsAndRateLimitMiddleware(t *testing.T) {
+	var metrics MessageMetrics
+	dispatcher := NewMessageMatchDispatcher()
+	dispatcher.Use(metrics.Middleware(), RateLimitMiddleware(2, time.Minute, nil))
+	handled := 0
+	dispatcher.OnText(func(*MessageContext) { handled++ })
+	for i := 0; i < 3; i++ {
+		dispatcher.Dispatch(newTestMessage("@a"))
+	}
+	dispatcher.Dispatch(newTestMessage("@b"))
+	if handled != 3 {
+		t.Errorf("handled %d messages, want 3", handled)
+	}
+	snapshot := metrics.Snapshot()
+	if snapshot.Handled != 4 || snapshot.Aborted != 1 || snapshot.ByType[MsgTypeText] != 4 {
+		t.Errorf("got %+v", snapshot)
+	}
+}
@@ -25,7 +25,7 @@ var (
 type normalMode struct{}
 
 func (n normalMode) PushLogin(ctx context.Context, client *Client, uin int64) (*http.Response, error) {
-	path, err := url.Parse(client.Domain.BaseHost() + webwxpushloginurl)
+	path, err := url.Parse(client.endpoint(webwxpushloginurl))
 	if err != nil {
 		return nil, err
 	}
@@ -40,12 +40,12 @@ func (n normalMode) PushLogin(ctx context.Context, client *Client, uin int64) (*
 }
 
 func (n normalMode) GetLoginUUID(ctx context.Context, client *Client) (*http.Response, error) {
-	path, err := url.Parse(jslogin)
+	path, err := url.Parse(client.endpoint(jslogin))
 	if err != nil {
 		return nil, err
 	}
 	params := url.Values{}
-	redirectUrl, err := url.Parse(webwxnewloginpage)
+	redirectUrl, err := url.Parse(client.endpoint(webwxnewloginpage))
 	if err != nil {
 		return nil, err
 	}
@@ -74,12 +74,12 @@ func (n normalMode) GetLoginInfo(ctx context.Context, client *Client, path strin
 type desktopMode struct{}
 
 func (n desktopMode) GetLoginUUID(ctx context.Context, client *Client) (*http.Response, error) {
-	path, err := url.Parse(jslogin)
+	path, err := url.Parse(client.endpoint(jslogin))
 	if err != nil {
 		return nil, err
 	}
 	params := url.Values{}
-	redirectUrl, err := url.Parse(webwxnewloginpage)
+	redirectUrl, err := url.Parse(client.endpoint(webwxnewloginpage))
 	if err != nil {
 		return nil, err
 	}
@@ -110,7 +110,7 @@ func (n desktopMode) GetLoginInfo(ctx context.Context, client *Client, path stri
 }
 
 func (n desktopMode) PushLogin(ctx context.Context, client *Client, uin int64) (*http.Response, error) {
-	path, err := url.Parse(client.Domain.BaseHost() + webwxpushloginurl)
+	path, err := url.Parse(client.endpoint(webwxpushloginurl))
 	if err != nil {
 		return nil, err
 	}
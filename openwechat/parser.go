@@ -7,7 +7,6 @@ import (
 	"math/rand"
 	"net/http"
 	"path/filepath"
-	"reflect"
 	"strconv"
 	"strings"
 	"time"
@@ -74,5 +73,5 @@ func getMessageType(filename string) string {
 }
 
 func stringToByte(s string) []byte {
-	return *(*[]byte)(unsafe.Pointer(&*(*reflect.StringHeader)(unsafe.Pointer(&s))))
+	return unsafe.Slice(unsafe.StringData(s), len(s))
 }
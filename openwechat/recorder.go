@@ -0,0 +1,290 @@
+package openwechat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// redacted 脱敏后的值
+const redacted = "***"
+
+var (
+	// url 和 js 响应中的登录凭证, 如 redirect_uri 里的 ticket
+	redactParamRegexp = regexp.MustCompile(`\b(skey|pass_ticket|ticket|sid|wxsid|uin|wxuin|webwx_data_ticket|deviceid)=([^&"';\s]+)`)
+	// json 中的登录凭证, 数字类型的替换为0, 保证回放时能正常反序列化
+	redactJSONRegexp = regexp.MustCompile(`"(Skey|SKey|Sid|Uin|DeviceID|PassTicket|pass_ticket|webwx_data_ticket)"(\s*:\s*)("(?:[^"\\]|\\.)*"|-?\d+)`)
+	// LoginInfo xml 中的登录凭证
+	redactXMLRegexp = regexp.MustCompile(`<(skey|wxsid|pass_ticket)>[^<]*<`)
+	// wxuin 需要是数字
+	redactXMLUinRegexp = regexp.MustCompile(`<wxuin>[^<]*<`)
+	// Cookie 请求头中的每一个值
+	redactCookieRegexp = regexp.MustCompile(`([^=;\s]+)=([^;]*)`)
+	// Set-Cookie 响应头中的值, 保留名称和属性
+	redactSetCookieRegexp = regexp.MustCompile(`^([^=]+)=[^;]*`)
+)
+
+// Recording 一次请求和响应的记录, 以 JSONL 格式保存, 每行一条
+type Recording struct {
+	Time           time.Time   `json:"time"`
+	Method         string      `json:"method"`
+	URL            string      `json:"url"`
+	RequestHeader  http.Header `json:"request_header,omitempty"`
+	RequestBody    string      `json:"request_body,omitempty"`
+	Status         int         `json:"status"`
+	ResponseHeader http.Header `json:"response_header,omitempty"`
+	ResponseBody   string      `json:"response_body,omitempty"`
+	// ResponseBodyEncoding 为 base64 时 ResponseBody 为二进制内容的 base64 编码, 如图片
+	ResponseBodyEncoding string `json:"response_body_encoding,omitempty"`
+	Error                string `json:"error,omitempty"`
+}
+
+// RecordTransport 将请求和响应脱敏后写入 JSONL, 用于排查协议变化导致的解析失败
+// Cookie、SKey、pass_ticket、uin 等登录凭证都会被替换
+//
+//	file, _ := os.Create("wechat.jsonl")
+//	bot := openwechat.DefaultBot(openwechat.Desktop, openwechat.WithTrafficRecorder(file))
+type RecordTransport struct {
+	// OmitContent 为 true 时只记录登录和 synccheck 接口的内容, 其他接口只记录大小
+	// 消息、联系人和上传下载的文件都不会写入记录, 这样的记录不能用于回放
+	OmitContent bool
+
+	next http.RoundTripper
+	mu   sync.Mutex
+	w    io.Writer
+}
+
+// contentSafeEndpoints 不包含消息和联系人信息的接口, 脱敏后可以完整记录
+var contentSafeEndpoints = map[string]bool{
+	jslogin:           true,
+	login:             true,
+	qrcode:            true,
+	webwxnewloginpage: true,
+	webwxpushloginurl: true,
+	synccheck:         true,
+	webwxlogout:       true,
+}
+
+// NewRecordTransport 创建 RecordTransport, next 为空时使用 http.DefaultTransport
+func NewRecordTransport(next http.RoundTripper, w io.Writer) *RecordTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &RecordTransport{next: next, w: w}
+}
+
+// RoundTrip 实现 http.RoundTripper, 记录失败不影响请求
+func (t *RecordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	record := &Recording{
+		Time:          time.Now(),
+		Method:        req.Method,
+		URL:           redactText(req.URL.String()),
+		RequestHeader: redactHeader(req.Header, "Cookie"),
+	}
+	omit := t.OmitContent && !contentSafeEndpoints[endpointName(req.URL.Path)]
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		// 上传的文件不记录, 表单里还有 webwx_data_ticket
+		if omit {
+			record.RequestBody = omittedBody(len(body))
+		} else if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/") {
+			record.RequestBody = "[multipart omitted]"
+		} else {
+			record.RequestBody = redactText(string(body))
+		}
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		// 退出时取消的请求不是微信服务器的响应, 不记录, 否则回放时会在这里提前出错
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+		record.Error = err.Error()
+		t.write(record)
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		record.Error = err.Error()
+	}
+	record.Status = resp.StatusCode
+	record.ResponseHeader = redactHeader(resp.Header, "Set-Cookie")
+	if omit {
+		record.ResponseBody = omittedBody(len(body))
+	} else if utf8.Valid(body) {
+		record.ResponseBody = redactText(string(body))
+	} else {
+		record.ResponseBody = base64.StdEncoding.EncodeToString(body)
+		record.ResponseBodyEncoding = "base64"
+	}
+	t.write(record)
+	return resp, nil
+}
+
+func omittedBody(size int) string {
+	return fmt.Sprintf("[%d bytes omitted]", size)
+}
+
+func (t *RecordTransport) write(record *Recording) {
+	// 不转义 html, 方便直接阅读 xml 和消息内容
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(record); err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, _ = t.w.Write(buf.Bytes())
+}
+
+// redactText 替换文本中的登录凭证
+func redactText(text string) string {
+	text = redactParamRegexp.ReplaceAllString(text, "${1}="+redacted)
+	text = redactJSONRegexp.ReplaceAllStringFunc(text, func(s string) string {
+		match := redactJSONRegexp.FindStringSubmatch(s)
+		if strings.HasPrefix(match[3], `"`) {
+			return `"` + match[1] + `"` + match[2] + `"` + redacted + `"`
+		}
+		return `"` + match[1] + `"` + match[2] + "0"
+	})
+	text = redactXMLRegexp.ReplaceAllString(text, "<${1}>"+redacted+"<")
+	return redactXMLUinRegexp.ReplaceAllString(text, "<wxuin>0<")
+}
+
+// redactHeader 复制请求头, 并替换其中 Cookie 或 Set-Cookie 的值
+func redactHeader(header http.Header, cookieKey string) http.Header {
+	header = header.Clone()
+	values := header.Values(cookieKey)
+	for i, value := range values {
+		if cookieKey == "Set-Cookie" {
+			values[i] = redactSetCookieRegexp.ReplaceAllString(value, "${1}="+redacted)
+		} else {
+			values[i] = redactCookieRegexp.ReplaceAllString(value, "${1}="+redacted)
+		}
+	}
+	return header
+}
+
+// ReplayTransport 按记录返回响应, 用于将抓到的数据变成测试用例
+// 相同方法和路径的请求按记录的顺序依次返回, 不比较域名和查询参数
+type ReplayTransport struct {
+	mu         sync.Mutex
+	recordings map[string][]*Recording
+}
+
+// NewReplayTransport 从 RecordTransport 写入的 JSONL 中读取记录
+func NewReplayTransport(r io.Reader) (*ReplayTransport, error) {
+	t := &ReplayTransport{recordings: make(map[string][]*Recording)}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record Recording
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("replay: line %d: %w", line, err)
+		}
+		key, err := replayKey(record.Method, record.URL)
+		if err != nil {
+			return nil, fmt.Errorf("replay: line %d: %w", line, err)
+		}
+		t.recordings[key] = append(t.recordings[key], &record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	key, err := replayKey(req.Method, req.URL.String())
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	records := t.recordings[key]
+	if len(records) == 0 {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("replay: no recorded response for %s", key)
+	}
+	record := records[0]
+	t.recordings[key] = records[1:]
+	t.mu.Unlock()
+
+	if record.Error != "" && record.Status == 0 {
+		return nil, fmt.Errorf("replay: %s", record.Error)
+	}
+	body := []byte(record.ResponseBody)
+	if record.ResponseBodyEncoding == "base64" {
+		if body, err = base64.StdEncoding.DecodeString(record.ResponseBody); err != nil {
+			return nil, fmt.Errorf("replay: %s: %w", key, err)
+		}
+	}
+	header := record.ResponseHeader.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", record.Status, http.StatusText(record.Status)),
+		StatusCode:    record.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// Remaining 还没有被回放的记录数
+func (t *ReplayTransport) Remaining() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var n int
+	for _, records := range t.recordings {
+		n += len(records)
+	}
+	return n
+}
+
+func replayKey(method, rawURL string) (string, error) {
+	path := rawURL
+	if i := strings.Index(path, "://"); i >= 0 {
+		path = path[i+3:]
+		if j := strings.IndexByte(path, '/'); j >= 0 {
+			path = path[j:]
+		} else {
+			path = "/"
+		}
+	}
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	if path == "" {
+		return "", fmt.Errorf("invalid url %q", rawURL)
+	}
+	return method + " " + path, nil
+}
@@ -0,0 +1,161 @@
+package openwechat
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordTransportRedacts(t *testing.T) {
+	server := newFakeWeChat(t)
+	server.contacts = Members{{UserName: "@friend", NickName: "小明"}}
+	var buf bytes.Buffer
+	bot := server.newBot()
+	bot.Caller.Client.HTTPClient().Transport = NewRecordTransport(server, &buf)
+	if err := bot.Login(); err != nil {
+		t.Fatal(err)
+	}
+	self, _ := bot.GetCurrentUser()
+	if _, err := self.Friends(); err != nil {
+		t.Fatal(err)
+	}
+	bot.Exit()
+	<-bot.syncDone // 等待轮询停止后再读取记录
+
+	out := buf.String()
+	for _, secret := range []string{"fake-sid", "@crypt_fake", "fake-pass-ticket", "fake-data-ticket", "fake-ticket", "10001"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("recording contains %q", secret)
+		}
+	}
+	for _, want := range []string{"wxuin=***", "webwx_data_ticket=***", "<wxuin>0<", "测试账号", "小明"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("recording does not contain %q", want)
+		}
+	}
+}
+
+// 隐私模式下只记录登录和 synccheck 的内容
+func TestRecordTransportOmitContent(t *testing.T) {
+	server := newFakeWeChat(t)
+	server.contacts = Members{{UserName: "@friend", NickName: "小明"}}
+	var buf bytes.Buffer
+	bot := server.newBot()
+	transport := NewRecordTransport(server, &buf)
+	transport.OmitContent = true
+	bot.Caller.Client.HTTPClient().Transport = transport
+	if err := bot.Login(); err != nil {
+		t.Fatal(err)
+	}
+	self, _ := bot.GetCurrentUser()
+	if _, err := self.Friends(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := self.SendTextToFriend(&Friend{User: &User{UserName: "@friend"}}, "悄悄话"); err != nil {
+		t.Fatal(err)
+	}
+	bot.Exit()
+	<-bot.syncDone
+
+	out := buf.String()
+	for _, private := range []string{"测试账号", "小明", "悄悄话"} {
+		if strings.Contains(out, private) {
+			t.Errorf("recording contains %q", private)
+		}
+	}
+	for _, want := range []string{"window.code=200", "bytes omitted"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("recording does not contain %q", want)
+		}
+	}
+}
+
+// 录制一次登录和收发消息, 再回放给新的 Bot
+func TestReplayTransport(t *testing.T) {
+	server := newFakeWeChat(t)
+	server.contacts = Members{{UserName: "@friend", NickName: "小明"}}
+	var buf bytes.Buffer
+	bot := server.newBot()
+	bot.Caller.Client.HTTPClient().Transport = NewRecordTransport(server, &buf)
+	bot.MessageHandler = func(msg *Message) {
+		if msg.IsText() && msg.Content == "ping" {
+			_, _ = msg.ReplyText("pong")
+		}
+	}
+	if err := bot.Login(); err != nil {
+		t.Fatal(err)
+	}
+	server.pushText("@friend", "@self", "ping")
+	server.waitSent(1)
+	bot.Exit()
+	<-bot.syncDone
+
+	replay, err := NewReplayTransport(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot = DefaultBot(Desktop)
+	bot.UUIDCallback = nil
+	bot.Caller.Client.HTTPClient().Transport = replay
+	bot.Caller.Client.RetryPolicy = NoRetry
+	// 记录回放完后 synccheck 会返回错误, 直接退出
+	bot.MessageErrorHandler = func(err error) error { return err }
+	received := make(chan string, 1)
+	bot.MessageHandler = func(msg *Message) {
+		received <- msg.Content
+		if _, err := msg.ReplyText("pong"); err != nil {
+			t.Error(err)
+		}
+	}
+	if err = bot.Login(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case content := <-received:
+		if content != "ping" {
+			t.Errorf("got %q", content)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for replayed message")
+	}
+	<-bot.Context().Done()
+	if !strings.Contains(bot.CrashReason().Error(), "no recorded response") {
+		t.Errorf("got %v", bot.CrashReason())
+	}
+}
+
+// 从线上抓到的 webwxsync 响应, 群消息中带有表情
+func TestReplayWebWxSyncGroupMessage(t *testing.T) {
+	file, err := os.Open("testdata/webwxsync_group_message.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+	replay, err := NewReplayTransport(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caller := DefaultCaller()
+	caller.Client.HTTPClient().Transport = replay
+	caller.Client.Domain = "wx2.qq.com"
+	resp, err := caller.WebWxSync(context.Background(), &CallerWebWxSyncOptions{
+		BaseRequest:     &BaseRequest{},
+		WebInitResponse: &WebInitResponse{SyncKey: &SyncKey{}},
+		LoginInfo:       &LoginInfo{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.AddMsgList) != 1 || resp.SyncKey.List[0].Val != 700000002 {
+		t.Fatalf("got %+v", resp)
+	}
+	if msg := resp.AddMsgList[0]; msg.FromUserName != "@@3f1c0e" || !strings.HasPrefix(msg.Content, "@7d9e:<br/>周五下午三点开会") {
+		t.Errorf("got %+v", msg)
+	}
+	if replay.Remaining() != 0 {
+		t.Errorf("%d recordings not replayed", replay.Remaining())
+	}
+}
@@ -1,6 +1,7 @@
 package openwechat
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"math/rand"
@@ -44,6 +45,25 @@ func (f *Friend) SendFile(file io.Reader) (*SentMessage, error) {
 	return f.Self().SendFileToFriend(f, file)
 }
 
+// SendImageWithOptions 发送图片消息, ctx 取消时停止上传, opt 可以为空
+func (f *Friend) SendImageWithOptions(ctx context.Context, file io.Reader, opt *SendMediaOptions) (*SentMessage, error) {
+	return f.Self().sendImageToUserWithOptions(ctx, f.User.UserName, file, opt)
+}
+
+// SendVideoWithOptions 发送视频消息, ctx 取消时停止上传, opt 可以为空
+func (f *Friend) SendVideoWithOptions(ctx context.Context, file io.Reader, opt *SendMediaOptions) (*SentMessage, error) {
+	return f.Self().sendVideoToUserWithOptions(ctx, f.User.UserName, file, opt)
+}
+
+// SendFileWithOptions 发送文件消息, ctx 取消时停止上传, opt 可以为空
+//
+//	friend.SendFileWithOptions(ctx, file, &openwechat.SendMediaOptions{
+//		Progress: func(uploaded, total int64) { fmt.Printf("%d/%d\n", uploaded, total) },
+//	})
+func (f *Friend) SendFileWithOptions(ctx context.Context, file io.Reader, opt *SendMediaOptions) (*SentMessage, error) {
+	return f.Self().sendFileToUserWithOptions(ctx, f.User.UserName, file, opt)
+}
+
 // AddIntoGroup 拉该好友入群
 func (f *Friend) AddIntoGroup(groups ...*Group) error {
 	return f.Self().AddFriendIntoManyGroups(f, groups...)
@@ -201,6 +221,21 @@ func (g *Group) SendFile(file io.Reader) (*SentMessage, error) {
 	return g.Self().SendFileToGroup(g, file)
 }
 
+// SendImageWithOptions 发送图片消息给当前的群组, ctx 取消时停止上传, opt 可以为空
+func (g *Group) SendImageWithOptions(ctx context.Context, file io.Reader, opt *SendMediaOptions) (*SentMessage, error) {
+	return g.Self().sendImageToUserWithOptions(ctx, g.User.UserName, file, opt)
+}
+
+// SendVideoWithOptions 发送视频消息给当前的群组, ctx 取消时停止上传, opt 可以为空
+func (g *Group) SendVideoWithOptions(ctx context.Context, file io.Reader, opt *SendMediaOptions) (*SentMessage, error) {
+	return g.Self().sendVideoToUserWithOptions(ctx, g.User.UserName, file, opt)
+}
+
+// SendFileWithOptions 发送文件给当前的群组, ctx 取消时停止上传, opt 可以为空
+func (g *Group) SendFileWithOptions(ctx context.Context, file io.Reader, opt *SendMediaOptions) (*SentMessage, error) {
+	return g.Self().sendFileToUserWithOptions(ctx, g.User.UserName, file, opt)
+}
+
 // Members 获取所有的群成员
 func (g *Group) Members() (Members, error) {
 	if err := g.Detail(); err != nil {
@@ -448,6 +483,16 @@ func (m *Mp) SendFile(file io.Reader) (*SentMessage, error) {
 	return m.Self().SendFileToMp(m, file)
 }
 
+// SendImageWithOptions 发送图片消息给公众号, ctx 取消时停止上传, opt 可以为空
+func (m *Mp) SendImageWithOptions(ctx context.Context, file io.Reader, opt *SendMediaOptions) (*SentMessage, error) {
+	return m.Self().sendImageToUserWithOptions(ctx, m.User.UserName, file, opt)
+}
+
+// SendFileWithOptions 发送文件消息给公众号, ctx 取消时停止上传, opt 可以为空
+func (m *Mp) SendFileWithOptions(ctx context.Context, file io.Reader, opt *SendMediaOptions) (*SentMessage, error) {
+	return m.Self().sendFileToUserWithOptions(ctx, m.User.UserName, file, opt)
+}
+
 // GetByUsername 根据username查询一个Friend
 func (f Friends) GetByUsername(username string) *Friend {
 	return f.SearchByUserName(1, username).First()
@@ -0,0 +1,238 @@
+package openwechat
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RetryClass 接口是否可以安全重试
+type RetryClass int
+
+const (
+	// RetryUnsafe 重试可能产生副作用, 如重复发送消息, 不重试
+	RetryUnsafe RetryClass = iota
+	// RetrySafe 重复请求没有副作用, 如获取联系人
+	RetrySafe
+	// RetryIfDeduplicated 请求中带有 ClientMsgId 时服务端会去重, 可以重试
+	RetryIfDeduplicated
+)
+
+func (c RetryClass) String() string {
+	switch c {
+	case RetrySafe:
+		return "safe"
+	case RetryIfDeduplicated:
+		return "deduplicated"
+	default:
+		return "unsafe"
+	}
+}
+
+// endpointRetryClasses 按接口路径区分是否可以重试, 没有列出的接口 GET 请求可以重试, 其他请求不重试
+var endpointRetryClasses = map[string]RetryClass{
+	jslogin:              RetrySafe,
+	login:                RetrySafe,
+	webwxnewloginpage:    RetrySafe,
+	webwxinit:            RetrySafe,
+	webwxstatusnotify:    RetrySafe,
+	synccheck:            RetrySafe,
+	webwxsync:            RetrySafe,
+	webwxgetcontact:      RetrySafe,
+	webwxbatchgetcontact: RetrySafe,
+	webwxcheckupload:     RetrySafe,
+	webwxuploadmedia:     RetrySafe,
+	webwxsendmsg:         RetryIfDeduplicated,
+	webwxsendmsgimg:      RetryIfDeduplicated,
+	webwxsendappmsg:      RetryIfDeduplicated,
+	webwxsendvideomsg:    RetryIfDeduplicated,
+	webwxoplog:           RetryUnsafe,
+	webwxverifyuser:      RetryUnsafe,
+	webwxupdatechatroom:  RetryUnsafe,
+	webwxcreatechatroom:  RetryUnsafe,
+	webwxrevokemsg:       RetryUnsafe,
+	webwxlogout:          RetryUnsafe,
+}
+
+var clientMsgIdRegexp = regexp.MustCompile(`"ClientMsgId"\s*:\s*("[^"]+"|[1-9]\d*)`)
+
+// ClassifyRetry 判断请求是否可以重试, body 为请求体
+func ClassifyRetry(req *http.Request, body []byte) RetryClass {
+	class, ok := endpointRetryClasses[endpointName(req.URL.Path)]
+	if !ok {
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			return RetrySafe
+		}
+		return RetryUnsafe
+	}
+	if class == RetryIfDeduplicated && !clientMsgIdRegexp.Match(body) {
+		return RetryUnsafe
+	}
+	return class
+}
+
+// RetryPolicy 决定请求失败后是否重试以及重试前等待的时间
+type RetryPolicy interface {
+	// Retry attempt 为已经请求的次数, 从1开始, resp 和 err 为上一次请求的结果
+	Retry(req *http.Request, body []byte, attempt int, resp *http.Response, err error) (time.Duration, bool)
+}
+
+// RetryPolicyFunc 函数形式的 RetryPolicy
+type RetryPolicyFunc func(req *http.Request, body []byte, attempt int, resp *http.Response, err error) (time.Duration, bool)
+
+func (f RetryPolicyFunc) Retry(req *http.Request, body []byte, attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	return f(req, body, attempt, resp, err)
+}
+
+// NoRetry 不重试
+var NoRetry RetryPolicy = RetryPolicyFunc(func(*http.Request, []byte, int, *http.Response, error) (time.Duration, bool) {
+	return 0, false
+})
+
+type retryPolicyKey struct{}
+
+// withRetryPolicy 为单个请求指定重试策略, 优先于 Client.RetryPolicy
+func withRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+// BackoffRetryPolicy 指数退避的重试策略
+// 网络错误、429 和 5xx 响应会重试, 不能安全重试的接口不重试
+type BackoffRetryPolicy struct {
+	MaxAttempts int           // 最多请求的次数, 包含第一次, 为0时使用 Client.MaxRetryTimes
+	BaseDelay   time.Duration // 第一次重试前等待的时间, 之后每次翻倍
+	MaxDelay    time.Duration // 最长的等待时间
+	Jitter      float64       // 随机减少等待时间的比例, 0-1, 避免同时重试
+
+	// Classify 判断请求是否可以重试, 为空时使用 ClassifyRetry
+	Classify func(req *http.Request, body []byte) RetryClass
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// DefaultRetryPolicy 默认的重试策略
+func DefaultRetryPolicy() *BackoffRetryPolicy {
+	return &BackoffRetryPolicy{BaseDelay: 200 * time.Millisecond, MaxDelay: 5 * time.Second, Jitter: 0.2}
+}
+
+var defaultRetryPolicy RetryPolicy = DefaultRetryPolicy()
+
+// Retry 实现 RetryPolicy
+func (p *BackoffRetryPolicy) Retry(req *http.Request, body []byte, attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	if !retryable(resp, err) {
+		return 0, false
+	}
+	classify := p.Classify
+	if classify == nil {
+		classify = ClassifyRetry
+	}
+	if classify(req, body) == RetryUnsafe {
+		return 0, false
+	}
+	return p.delay(attempt), true
+}
+
+func (p *BackoffRetryPolicy) delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 && delay > 0 {
+		p.mu.Lock()
+		if p.rnd == nil {
+			p.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+		delay -= time.Duration(p.rnd.Float64() * p.Jitter * float64(delay))
+		p.mu.Unlock()
+	}
+	return delay
+}
+
+// retryable 网络错误、429 和 5xx 可以重试
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// RetryHook 实现了该接口的 HttpHook 会在每次重试前被调用, 用于统计重试
+type RetryHook interface {
+	OnRetry(req *http.Request, attempt int, delay time.Duration, resp *http.Response, err error)
+}
+
+// OnRetry 调用实现了 RetryHook 的钩子
+func (h HttpHooks) OnRetry(req *http.Request, attempt int, delay time.Duration, resp *http.Response, err error) {
+	for _, hook := range h {
+		if retryHook, ok := hook.(RetryHook); ok {
+			retryHook.OnRetry(req, attempt, delay, resp, err)
+		}
+	}
+}
+
+// RetryMetrics 统计请求和重试的次数, 通过 Client.AddHttpHook 添加
+type RetryMetrics struct {
+	requests atomic.Int64
+	failures atomic.Int64
+	retries  sync.Map // 接口路径 -> *atomic.Int64
+}
+
+// RetryMetricsSnapshot RetryMetrics 某一时刻的数据
+type RetryMetricsSnapshot struct {
+	Requests int64            // 请求次数, 重试不计入
+	Failures int64            // 重试后仍然失败的次数
+	Retries  map[string]int64 // 每个接口的重试次数
+}
+
+func (m *RetryMetrics) BeforeRequest(*http.Request) { m.requests.Add(1) }
+
+func (m *RetryMetrics) AfterRequest(resp *http.Response, err error) {
+	if err != nil || (resp != nil && resp.StatusCode >= http.StatusInternalServerError) {
+		m.failures.Add(1)
+	}
+}
+
+func (m *RetryMetrics) OnRetry(req *http.Request, _ int, _ time.Duration, _ *http.Response, _ error) {
+	counter, _ := m.retries.LoadOrStore(endpointName(req.URL.Path), new(atomic.Int64))
+	counter.(*atomic.Int64).Add(1)
+}
+
+// Snapshot 返回当前的统计数据
+func (m *RetryMetrics) Snapshot() RetryMetricsSnapshot {
+	snapshot := RetryMetricsSnapshot{
+		Requests: m.requests.Load(),
+		Failures: m.failures.Load(),
+		Retries:  make(map[string]int64),
+	}
+	m.retries.Range(func(key, value any) bool {
+		snapshot.Retries[key.(string)] = value.(*atomic.Int64).Load()
+		return true
+	})
+	return snapshot
+}
+
+// sleepContext 等待 d, ctx 结束时提前返回 ctx 的错误
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,147 @@
+package openwechat
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func newRetryTestClient(fail func(n int) (int, error)) (*Client, *atomic.Int32) {
+	var calls atomic.Int32
+	client := NewClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		n := int(calls.Add(1))
+		if req.Body != nil {
+			if body, _ := io.ReadAll(req.Body); !strings.Contains(string(body), "BaseRequest") {
+				return nil, errors.New("request body was not replayed")
+			}
+		}
+		status, err := fail(n)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("{}")), Request: req}, nil
+	})})
+	client.MaxRetryTimes = 4
+	client.Domain = "wx.qq.com"
+	client.RetryPolicy = &BackoffRetryPolicy{BaseDelay: time.Millisecond, MaxDelay: 4 * time.Millisecond}
+	return client, &calls
+}
+
+func newRetryTestRequest(t *testing.T, ctx context.Context, path, body string) *http.Request {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://wx.qq.com"+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestRetryClassify(t *testing.T) {
+	tests := []struct {
+		path string
+		body string
+		want RetryClass
+	}{
+		{synccheck, "", RetrySafe},
+		{webwxgetcontact, "", RetrySafe},
+		{webwxsendmsg, `{"BaseRequest":{},"Msg":{"ClientMsgId":"17292870001234567"}}`, RetryIfDeduplicated},
+		{webwxsendmsg, `{"BaseRequest":{},"Msg":{"ClientMsgId":""}}`, RetryUnsafe},
+		{webwxoplog, `{"BaseRequest":{}}`, RetryUnsafe},
+		{"/unknown", "", RetryUnsafe},
+	}
+	for _, tt := range tests {
+		req := newRetryTestRequest(t, context.Background(), tt.path, tt.body)
+		if got := ClassifyRetry(req, []byte(tt.body)); got != tt.want {
+			t.Errorf("%s %s: got %s, want %s", tt.path, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		fail   func(n int) (int, error)
+		calls  int32
+		status int
+		err    bool
+	}{
+		{"network error then ok", webwxgetcontact, `{"BaseRequest":{}}`, func(n int) (int, error) {
+			if n < 3 {
+				return 0, errors.New("connection reset")
+			}
+			return http.StatusOK, nil
+		}, 3, http.StatusOK, false},
+		{"5xx retried until MaxRetryTimes", webwxsync, `{"BaseRequest":{}}`, func(int) (int, error) {
+			return http.StatusBadGateway, nil
+		}, 4, http.StatusBadGateway, false},
+		{"4xx not retried", webwxsync, `{"BaseRequest":{}}`, func(int) (int, error) {
+			return http.StatusBadRequest, nil
+		}, 1, http.StatusBadRequest, false},
+		{"send without ClientMsgId not retried", webwxsendmsg, `{"BaseRequest":{},"Msg":{}}`, func(int) (int, error) {
+			return 0, errors.New("timeout")
+		}, 1, 0, true},
+		{"send with ClientMsgId retried", webwxsendmsg, `{"BaseRequest":{},"Msg":{"ClientMsgId":"1"}}`, func(n int) (int, error) {
+			if n == 1 {
+				return 0, errors.New("timeout")
+			}
+			return http.StatusOK, nil
+		}, 2, http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, calls := newRetryTestClient(tt.fail)
+			metrics := &RetryMetrics{}
+			client.AddHttpHook(metrics)
+			resp, err := client.Do(newRetryTestRequest(t, context.Background(), tt.path, tt.body))
+			if (err != nil) != tt.err || (err != nil && !IsNetworkError(err)) {
+				t.Fatalf("err = %v", err)
+			}
+			if err == nil && resp.StatusCode != tt.status {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if calls.Load() != tt.calls {
+				t.Errorf("calls %d, want %d", calls.Load(), tt.calls)
+			}
+			snapshot := metrics.Snapshot()
+			if snapshot.Requests != 1 || snapshot.Retries[tt.path] != int64(tt.calls-1) {
+				t.Errorf("metrics %+v", snapshot)
+			}
+		})
+	}
+}
+
+func TestClientRetryContextCanceled(t *testing.T) {
+	client, calls := newRetryTestClient(func(int) (int, error) { return http.StatusServiceUnavailable, nil })
+	client.RetryPolicy = &BackoffRetryPolicy{BaseDelay: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.Do(newRetryTestRequest(t, ctx, synccheck, `{"BaseRequest":{}}`))
+	if !errors.Is(err, context.DeadlineExceeded) || !IsNetworkError(err) {
+		t.Errorf("err = %v", err)
+	}
+	if calls.Load() != 1 || time.Since(start) > time.Second {
+		t.Errorf("calls %d, took %s", calls.Load(), time.Since(start))
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	policy := &BackoffRetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Jitter: 0.5}
+	for attempt, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		delay := policy.delay(attempt + 1)
+		if delay > max || delay < max/2 {
+			t.Errorf("attempt %d: delay %s not in [%s, %s]", attempt+1, delay, max/2, max)
+		}
+	}
+}
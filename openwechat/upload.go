@@ -0,0 +1,495 @@
+package openwechat
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sniffLen 判断文件类型时读取的字节数
+const sniffLen = 512
+
+const (
+	// DefaultUploadParallelism 默认同时上传的分块数
+	DefaultUploadParallelism = 3
+	// DefaultUploadChunkRetryTimes 默认每个分块最多上传的次数
+	DefaultUploadChunkRetryTimes = 3
+	// DefaultUploadResumeTimes 默认分块上传失败后继续上传的次数
+	DefaultUploadResumeTimes = 1
+)
+
+// uploadRetryPolicy 分块上传失败后等待的时间
+var uploadRetryPolicy = DefaultRetryPolicy()
+
+// UploadProgress 上传进度回调, uploaded 为已经上传成功的字节数, total 为文件大小
+type UploadProgress func(uploaded, total int64)
+
+// SendMediaOptions 发送图片、视频和文件时的可选项
+type SendMediaOptions struct {
+	FileName string         // 文件名, 为空时使用 *os.File 的文件名
+	Size     int64          // 文件大小, 和 Md5 都提供时不需要先读取一遍文件
+	Md5      string         // 文件内容 md5 的十六进制, 不能 Seek 的 Reader 没有提供 Size 和 Md5 时会先写入临时文件
+	Progress UploadProgress // 每个分块上传成功后调用
+}
+
+func (o *SendMediaOptions) apply(opt *CallerUploadMediaCommonOptions) {
+	if o == nil {
+		return
+	}
+	opt.FileName, opt.Size, opt.Md5, opt.Progress = o.FileName, o.Size, o.Md5, o.Progress
+}
+
+// UploadFile 要上传的文件
+// Size 和 Md5 都已知时直接从 Reader 边读边上传, 否则需要先读取一遍计算:
+// Reader 实现了 io.Seeker 时计算完成后回到开始的位置, 不需要额外的磁盘和内存,
+// 其他的 Reader 会在计算的同时写入临时文件, 上传完成后需要调用 Close 删除.
+// 因此上传 HTTP 响应这类只能读取一次的内容时, 调用方应该同时提供 Size 和 Md5 以避免临时文件
+//
+// UploadFile 会记录已经上传成功的分块, 上传失败后可以用同一个 UploadFile 继续上传
+type UploadFile struct {
+	Reader      io.Reader
+	Name        string    // 文件名, 为空时使用 *os.File 的文件名
+	Size        int64     // 文件大小, 为0时计算
+	Md5         string    // 文件内容 md5 的十六进制, 为空时计算
+	ContentType string    // 为空时根据文件开头的内容判断
+	ModTime     time.Time // 为空时使用 *os.File 的修改时间或者当前时间
+
+	readerAt io.ReaderAt // 可以按位置读取时每个分块单独读取
+	offset   int64       // 文件内容在 readerAt 中开始的位置
+	cleanup  func()
+
+	mu            sync.Mutex
+	clientMediaId int64
+	uploaded      []bool // 已经上传成功的分块
+}
+
+// NewUploadFile 从 io.Reader 创建 UploadFile, 文件的大小和 md5 在上传时计算
+func NewUploadFile(reader io.Reader) *UploadFile {
+	return &UploadFile{Reader: reader}
+}
+
+// prepare 补全文件的名称、大小、md5 和类型, 最多读取一遍文件, ctx 取消时停止读取
+func (f *UploadFile) prepare(ctx context.Context) error {
+	if file, ok := f.Reader.(*os.File); ok {
+		if f.Name == "" {
+			f.Name = filepath.Base(file.Name())
+		}
+		if f.ModTime.IsZero() {
+			if stat, err := file.Stat(); err == nil {
+				f.ModTime = stat.ModTime()
+			}
+		}
+	}
+	if f.Name == "" {
+		f.Name = strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	if f.ModTime.IsZero() {
+		f.ModTime = time.Now()
+	}
+
+	seeker, seekable := f.Reader.(io.Seeker)
+	if seekable {
+		offset, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		f.offset = offset
+		if readerAt, ok := f.Reader.(io.ReaderAt); ok {
+			f.readerAt = readerAt
+		}
+	}
+	if f.Size > 0 && f.Md5 != "" {
+		return f.sniff()
+	}
+	if !seekable {
+		return f.spool(ctx)
+	}
+
+	// 一次读取同时计算大小、md5 和类型, 之后回到开始的位置
+	h := md5.New()
+	head := &headWriter{}
+	size, err := io.Copy(io.MultiWriter(h, head), contextReader{ctx, f.Reader})
+	if err != nil {
+		return err
+	}
+	if _, err = seeker.Seek(f.offset, io.SeekStart); err != nil {
+		return err
+	}
+	f.fill(size, h.Sum(nil), head.buf)
+	return nil
+}
+
+// spool 将不能回到开始位置的 Reader 写入临时文件, 同时计算大小、md5 和类型
+func (f *UploadFile) spool(ctx context.Context) error {
+	file, err := os.CreateTemp("", "openwechat-upload-*")
+	if err != nil {
+		return err
+	}
+	f.cleanup = func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}
+	h := md5.New()
+	head := &headWriter{}
+	size, err := io.Copy(io.MultiWriter(file, h, head), contextReader{ctx, f.Reader})
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	f.fill(size, h.Sum(nil), head.buf)
+	f.Reader, f.readerAt, f.offset = io.NewSectionReader(file, 0, size), file, 0
+	return nil
+}
+
+// sniff 大小和 md5 已知时只读取开头判断文件类型
+func (f *UploadFile) sniff() error {
+	if f.ContentType != "" {
+		return nil
+	}
+	head := make([]byte, sniffLen)
+	var (
+		n   int
+		err error
+	)
+	if f.readerAt != nil {
+		n, err = f.readerAt.ReadAt(head, f.offset)
+	} else {
+		reader := newPeekReader(f.Reader, sniffLen)
+		head, err = reader.Peek()
+		n, f.Reader = len(head), reader
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+	f.ContentType = http.DetectContentType(head[:n])
+	return nil
+}
+
+func (f *UploadFile) fill(size int64, sum, head []byte) {
+	if f.Size <= 0 {
+		f.Size = size
+	}
+	if f.Md5 == "" {
+		f.Md5 = hex.EncodeToString(sum)
+	}
+	if f.ContentType == "" {
+		f.ContentType = http.DetectContentType(head)
+	}
+}
+
+// begin 开始上传, 返回是否有之前已经上传成功的分块
+func (f *UploadFile) begin(chunks int64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if int64(len(f.uploaded)) != chunks {
+		f.uploaded = make([]bool, chunks)
+		f.clientMediaId = time.Now().UnixNano() / 1e5
+	}
+	for _, ok := range f.uploaded {
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pending 返回前 n 个分块中还没有上传成功的分块
+func (f *UploadFile) pending(n int64) []int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var chunks []int64
+	for i := int64(0); i < n; i++ {
+		if !f.uploaded[i] {
+			chunks = append(chunks, i)
+		}
+	}
+	return chunks
+}
+
+// resumable 是否有已经上传成功的分块, 并且可以从中间读取没有成功的分块
+func (f *UploadFile) resumable() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.readerAt == nil {
+		return false
+	}
+	for _, ok := range f.uploaded {
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// done 标记分块上传成功
+func (f *UploadFile) done(chunk int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.uploaded[chunk] = true
+}
+
+// uploadedBytes 已经上传成功的字节数
+func (f *UploadFile) uploadedBytes() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var uploaded int64
+	for i, ok := range f.uploaded {
+		if ok {
+			uploaded += f.chunkLen(int64(i))
+		}
+	}
+	return uploaded
+}
+
+// chunkLen 第 index 个分块的大小
+func (f *UploadFile) chunkLen(index int64) int64 {
+	if remain := f.Size - index*chunkSize; remain < chunkSize {
+		return remain
+	}
+	return chunkSize
+}
+
+// chunk 返回第 index 个分块的内容
+// 不能按位置读取时必须按顺序读取每个分块
+func (f *UploadFile) chunk(index, size int64) io.Reader {
+	if f.readerAt != nil {
+		return io.NewSectionReader(f.readerAt, f.offset+index*chunkSize, size)
+	}
+	return f.Reader
+}
+
+// Close 删除读取时创建的临时文件, 不会关闭 Reader
+func (f *UploadFile) Close() error {
+	if f.cleanup != nil {
+		f.cleanup()
+		f.cleanup = nil
+	}
+	return nil
+}
+
+// Stat 返回文件信息, 用于构造文件消息
+func (f *UploadFile) Stat() os.FileInfo {
+	return uploadFileInfo{f}
+}
+
+type uploadFileInfo struct{ file *UploadFile }
+
+func (i uploadFileInfo) Name() string       { return i.file.Name }
+func (i uploadFileInfo) Size() int64        { return i.file.Size }
+func (i uploadFileInfo) Mode() fs.FileMode  { return 0644 }
+func (i uploadFileInfo) ModTime() time.Time { return i.file.ModTime }
+func (i uploadFileInfo) IsDir() bool        { return false }
+func (i uploadFileInfo) Sys() any           { return nil }
+
+// uploadProgress 按顺序调用进度回调
+type uploadProgress struct {
+	mu       sync.Mutex
+	file     *UploadFile
+	progress UploadProgress
+}
+
+func newUploadProgress(file *UploadFile, progress UploadProgress) *uploadProgress {
+	if progress != nil {
+		progress(file.uploadedBytes(), file.Size)
+	}
+	return &uploadProgress{file: file, progress: progress}
+}
+
+func (p *uploadProgress) done(chunk int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.file.done(chunk)
+	if p.progress != nil {
+		p.progress(p.file.uploadedBytes(), p.file.Size)
+	}
+}
+
+// uploadChunks 使用 parallelism 个 goroutine 上传分块, 有一个分块失败时取消其他分块并返回错误
+func uploadChunks(ctx context.Context, chunks []int64, parallelism int, upload func(ctx context.Context, chunk int64) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	queue := make(chan int64)
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for i := 0; i < parallelism && i < len(chunks); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range queue {
+				if err := upload(ctx, chunk); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+send:
+	for _, chunk := range chunks {
+		select {
+		case queue <- chunk:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// checkUploadChunkResponse 检查分块上传的结果, 成功时可以再次读取 resp.Body
+func checkUploadChunkResponse(resp *http.Response) error {
+	item, err := readUploadResponse(resp)
+	if err != nil {
+		return err
+	}
+	if !item.BaseResponse.Ok() {
+		_ = resp.Body.Close()
+		return item.BaseResponse.Err()
+	}
+	return nil
+}
+
+// checkUploadedResponse 检查 webwxcheckupload 的结果, 文件已经上传过时返回 true, 可以从 resp.Body 读取 MediaId
+func checkUploadedResponse(resp *http.Response) (bool, error) {
+	item, err := readUploadResponse(resp)
+	if err != nil {
+		return false, err
+	}
+	if !item.BaseResponse.Ok() {
+		_ = resp.Body.Close()
+		return false, item.BaseResponse.Err()
+	}
+	if item.MediaId == "" {
+		_ = resp.Body.Close()
+		return false, nil
+	}
+	return true, nil
+}
+
+// readUploadResponse 解析上传接口返回的 UploadResponse, 并将 resp.Body 重置为读取前的内容
+func readUploadResponse(resp *http.Response) (*UploadResponse, error) {
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upload: unexpected status %s", resp.Status)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	var item UploadResponse
+	if err = json.Unmarshal(body, &item); err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	return &item, nil
+}
+
+// contextReader ctx 取消后读取返回 ctx 的错误
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
+// headWriter 保留写入内容的前 sniffLen 个字节
+type headWriter struct{ buf []byte }
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if remain := sniffLen - len(w.buf); remain > 0 {
+		if len(p) < remain {
+			remain = len(p)
+		}
+		w.buf = append(w.buf, p[:remain]...)
+	}
+	return len(p), nil
+}
+
+// peekReader 先读取开头的内容用于判断文件类型, 之后再依次读出
+type peekReader struct {
+	head   []byte
+	reader io.Reader
+	n      int
+}
+
+func newPeekReader(reader io.Reader, n int) *peekReader {
+	return &peekReader{reader: reader, n: n}
+}
+
+func (r *peekReader) Peek() ([]byte, error) {
+	buf := make([]byte, r.n)
+	n, err := io.ReadFull(r.reader, buf)
+	r.head = buf[:n]
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return r.head, err
+}
+
+func (r *peekReader) Read(p []byte) (int, error) {
+	if len(r.head) > 0 {
+		n := copy(p, r.head)
+		r.head = r.head[n:]
+		return n, nil
+	}
+	return r.reader.Read(p)
+}
+
+// multipartChunk 生成一个分块的上传表单, 分块不超过 chunkSize, 因此直接在内存中生成
+// 返回表单的内容和 Content-Type, 重试时可以重复使用
+func multipartChunk(fields map[string]string, filename string, data io.Reader, size int64) ([]byte, string, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := writer.WriteField(key, fields[key]); err != nil {
+			return nil, "", err
+		}
+	}
+	part, err := writer.CreateFormFile("filename", filename)
+	if err != nil {
+		return nil, "", err
+	}
+	n, err := io.Copy(part, io.LimitReader(data, size))
+	if err != nil {
+		return nil, "", err
+	}
+	if n != size {
+		return nil, "", io.ErrUnexpectedEOF
+	}
+	if err = writer.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), writer.FormDataContentType(), nil
+}
@@ -0,0 +1,482 @@
+package openwechat
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// streamReader 只实现 io.Reader, 并统计读取的字节数
+type streamReader struct {
+	reader io.Reader
+	read   int64
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestUploadFilePrepareSeeker(t *testing.T) {
+	data := []byte("skip" + strings.Repeat("hello world ", 100))
+	reader := bytes.NewReader(data)
+	_, _ = reader.Seek(4, io.SeekStart)
+	file := NewUploadFile(reader)
+	if err := file.prepare(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+	if file.cleanup != nil {
+		t.Error("seekable reader should not be spooled")
+	}
+	if file.Size != int64(len(data)-4) || file.Md5 != md5Hex(data[4:]) {
+		t.Errorf("size %d md5 %s", file.Size, file.Md5)
+	}
+	if !strings.HasPrefix(file.ContentType, "text/plain") {
+		t.Errorf("content type %s", file.ContentType)
+	}
+	chunk, _ := io.ReadAll(file.chunk(0, file.Size))
+	if !bytes.Equal(chunk, data[4:]) {
+		t.Error("chunk does not start at the reader position")
+	}
+}
+
+func TestUploadFileSuppliedMetadata(t *testing.T) {
+	data := bytes.Repeat([]byte{0xff, 0xd8, 0xff}, 1000)
+	reader := &streamReader{reader: bytes.NewReader(data)}
+	file := &UploadFile{Reader: reader, Size: int64(len(data)), Md5: md5Hex(data)}
+	if err := file.prepare(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if file.cleanup != nil {
+		t.Error("reader with size and md5 should not be spooled")
+	}
+	if reader.read != sniffLen {
+		t.Errorf("read %d bytes before upload, want %d", reader.read, sniffLen)
+	}
+	if file.ContentType != "image/jpeg" {
+		t.Errorf("content type %s", file.ContentType)
+	}
+	got, _ := io.ReadAll(file.chunk(0, file.Size))
+	if !bytes.Equal(got, data) {
+		t.Error("content changed after sniffing")
+	}
+}
+
+func TestUploadFileSpool(t *testing.T) {
+	data := []byte(strings.Repeat("a", 2000))
+	file := NewUploadFile(&streamReader{reader: bytes.NewReader(data)})
+	if err := file.prepare(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if file.cleanup == nil {
+		t.Fatal("stream without md5 should be spooled")
+	}
+	if file.Size != int64(len(data)) || file.Md5 != md5Hex(data) {
+		t.Errorf("size %d md5 %s", file.Size, file.Md5)
+	}
+	name := file.readerAt.(*os.File).Name()
+	_ = file.Close()
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("temp file not removed: %v", err)
+	}
+}
+
+func TestMultipartChunk(t *testing.T) {
+	body, contentType, err := multipartChunk(map[string]string{"name": "a.txt", "size": "5"}, "a.txt", strings.NewReader("hello world"), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	if req.ContentLength != int64(len(body)) {
+		t.Errorf("content length %d, body %d", req.ContentLength, len(body))
+	}
+	if err = req.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	if name := req.FormValue("name"); name != "a.txt" {
+		t.Errorf("name %q", name)
+	}
+	file, _, err := req.FormFile("filename")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(file); string(data) != "hello" {
+		t.Errorf("file content %q", data)
+	}
+
+	if _, _, err = multipartChunk(nil, "a.txt", strings.NewReader("hi"), 5); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestSendFileByChunk(t *testing.T) {
+	server := newFakeWeChat(t)
+	server.contacts = Members{{UserName: "@friend", NickName: "小明"}}
+	bot := server.login()
+	self, _ := bot.GetCurrentUser()
+	friends, err := self.Friends()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("0123456789"), int(chunkSize*2/10+100))
+	reader := &streamReader{reader: bytes.NewReader(data)}
+	if _, err = friends.First().SendFile(reader); err != nil {
+		t.Fatal(err)
+	}
+	uploads := server.uploadedChunks()
+	if len(uploads) != 3 {
+		t.Fatalf("got %d chunks, want 3", len(uploads))
+	}
+	// 前两个分块并发上传, 最后一个分块总是最后上传
+	if uploads[2].Chunk != 2 {
+		t.Errorf("last uploaded chunk %d", uploads[2].Chunk)
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].Chunk < uploads[j].Chunk })
+	var got []byte
+	for i, upload := range uploads {
+		if upload.Chunk != i || upload.Chunks != 3 || upload.Md5 != md5Hex(data) || upload.Size != int64(len(data)) {
+			t.Errorf("chunk %d: chunk %d/%d md5 %s size %d", i, upload.Chunk, upload.Chunks, upload.Md5, upload.Size)
+		}
+		got = append(got, upload.Data...)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("uploaded content mismatch")
+	}
+	sent := server.sentMessages()
+	if len(sent) != 1 || sent[0].Type != AppMessage {
+		t.Errorf("sent: %+v", sent)
+	}
+}
+
+func TestCallerSendFileWithMetadata(t *testing.T) {
+	server := newFakeWeChat(t)
+	bot := server.login()
+	data := bytes.Repeat([]byte("x"), int(chunkSize)+10)
+	reader := &streamReader{reader: bytes.NewReader(data)}
+	_, err := bot.Caller.WebWxSendFile(bot.Context(), &CallerWebWxSendFileOptions{
+		FromUserName: "@self",
+		ToUserName:   "@friend",
+		Reader:       reader,
+		BaseRequest:  bot.Storage.Request,
+		LoginInfo:    bot.Storage.LoginInfo,
+		FileName:     "report.txt",
+		Size:         int64(len(data)),
+		Md5:          md5Hex(data),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.read != int64(len(data)) {
+		t.Errorf("read %d bytes, want a single pass over %d", reader.read, len(data))
+	}
+	uploads := server.uploadedChunks()
+	if len(uploads) != 2 || uploads[0].Name != "report.txt" {
+		t.Fatalf("uploads: %d", len(uploads))
+	}
+	sent := server.sentMessages()
+	if len(sent) != 1 || !strings.Contains(sent[0].Content, "<title>report.txt</title>") {
+		t.Errorf("sent: %+v", sent)
+	}
+}
+
+// uploadByChunk 直接调用 Client.WebWxUploadMediaByChunk 上传文件
+func uploadByChunk(bot *Bot, file *UploadFile, progress UploadProgress) (*UploadResponse, error) {
+	resp, err := bot.Caller.Client.WebWxUploadMediaByChunk(bot.Context(), &ClientWebWxUploadMediaByChunkOptions{
+		FromUserName: "@self",
+		ToUserName:   "@friend",
+		File:         file,
+		BaseRequest:  bot.Storage.Request,
+		LoginInfo:    bot.Storage.LoginInfo,
+		Progress:     progress,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	item, err := readUploadResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func TestUploadParallel(t *testing.T) {
+	server := newFakeWeChat(t)
+	server.uploadDelay = 20 * time.Millisecond
+	bot := server.login()
+	bot.Caller.Client.UploadParallelism = 4
+	data := bytes.Repeat([]byte("p"), int(chunkSize*5))
+	var (
+		mu       sync.Mutex
+		progress []int64
+	)
+	resp, err := uploadByChunk(bot, NewUploadFile(bytes.NewReader(data)), func(uploaded, total int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		if total != int64(len(data)) {
+			t.Errorf("total %d", total)
+		}
+		progress = append(progress, uploaded)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.MediaId == "" {
+		t.Error("no media id")
+	}
+	uploads := server.uploadedChunks()
+	if len(uploads) != 5 || uploads[4].Chunk != 4 {
+		t.Fatalf("uploads: %d", len(uploads))
+	}
+	server.mu.Lock()
+	maxInFlight := server.uploadMaxInFlight
+	server.mu.Unlock()
+	if maxInFlight < 2 || maxInFlight > 4 {
+		t.Errorf("max in flight %d, want 2-4", maxInFlight)
+	}
+	// 开始时调用一次, 之后每个分块调用一次
+	if len(progress) != 6 || progress[0] != 0 || progress[5] != int64(len(data)) {
+		t.Errorf("progress: %v", progress)
+	}
+	for i := 1; i < len(progress); i++ {
+		if progress[i] <= progress[i-1] {
+			t.Errorf("progress not increasing: %v", progress)
+		}
+	}
+}
+
+func TestUploadChunkRetry(t *testing.T) {
+	server := newFakeWeChat(t)
+	server.uploadFailures[1] = 2
+	bot := server.login()
+	data := bytes.Repeat([]byte("r"), int(chunkSize*2+1))
+	if _, err := uploadByChunk(bot, NewUploadFile(bytes.NewReader(data)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.requestCount(webwxuploadmedia); got != 5 {
+		t.Errorf("upload requests %d, want 5", got)
+	}
+	if got := len(server.uploadedChunks()); got != 3 {
+		t.Errorf("uploaded chunks %d, want 3", got)
+	}
+}
+
+// 分块上传自己重试, Client 不应该再重试每一次上传
+func TestUploadChunkRetryOnce(t *testing.T) {
+	server := newFakeWeChat(t)
+	server.uploadErrors[0] = 100
+	bot := server.login()
+	data := bytes.Repeat([]byte("e"), int(chunkSize+1))
+	if _, err := uploadByChunk(bot, NewUploadFile(bytes.NewReader(data)), nil); err == nil {
+		t.Fatal("expected chunk 0 to fail")
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if got := 100 - server.uploadErrors[0]; got != DefaultUploadChunkRetryTimes {
+		t.Errorf("chunk 0 sent %d times, want %d", got, DefaultUploadChunkRetryTimes)
+	}
+}
+
+func TestUploadResume(t *testing.T) {
+	server := newFakeWeChat(t)
+	server.uploadFailures[2] = DefaultUploadChunkRetryTimes
+	bot := server.login()
+	bot.Caller.Client.UploadParallelism = 1
+	data := bytes.Repeat([]byte("0123456789abcdef"), int(chunkSize*4/16))
+	file := NewUploadFile(bytes.NewReader(data))
+
+	_, err := uploadByChunk(bot, file, nil)
+	if err == nil {
+		t.Fatal("expected chunk 2 to fail")
+	}
+	if got := file.uploadedBytes(); got != 2*chunkSize {
+		t.Fatalf("uploaded %d bytes before failure", got)
+	}
+
+	var resumed []int64
+	resp, err := uploadByChunk(bot, file, func(uploaded, total int64) { resumed = append(resumed, uploaded) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.MediaId == "" {
+		t.Error("no media id")
+	}
+	if got := server.requestCount(webwxcheckupload); got != 1 {
+		t.Errorf("checkupload requests %d, want 1", got)
+	}
+	var chunks []int
+	for _, upload := range server.uploadedChunks() {
+		chunks = append(chunks, upload.Chunk)
+	}
+	if len(chunks) != 4 || chunks[2] != 2 || chunks[3] != 3 {
+		t.Errorf("uploaded chunks %v, want [0 1 2 3]", chunks)
+	}
+	if len(resumed) == 0 || resumed[0] != 2*chunkSize {
+		t.Errorf("resumed progress %v", resumed)
+	}
+}
+
+func TestUploadResumeAlreadyUploaded(t *testing.T) {
+	server := newFakeWeChat(t)
+	bot := server.login()
+	data := bytes.Repeat([]byte("u"), int(chunkSize+1))
+	file := &UploadFile{Reader: bytes.NewReader(data), Name: "done.bin"}
+	if err := file.prepare(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// 上一次上传时所有分块都已经成功, 但没有收到最后的结果
+	file.begin(2)
+	file.done(0)
+	server.chunksByMd5[file.Md5] = map[int]bool{0: true, 1: true}
+
+	resp, err := uploadByChunk(bot, file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.MediaId != "@fake_media_checked_done.bin" {
+		t.Errorf("media id %s", resp.MediaId)
+	}
+	if got := server.requestCount(webwxuploadmedia); got != 0 {
+		t.Errorf("upload requests %d, want 0", got)
+	}
+}
+
+func TestSendFileWithOptions(t *testing.T) {
+	server := newFakeWeChat(t)
+	server.contacts = Members{{UserName: "@@group", NickName: "工作群"}}
+	bot := server.login()
+	self, _ := bot.GetCurrentUser()
+	groups, err := self.Groups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("o"), int(chunkSize*2))
+	reader := &streamReader{reader: bytes.NewReader(data)}
+	var last int64
+	_, err = groups.First().SendFileWithOptions(context.Background(), reader, &SendMediaOptions{
+		FileName: "notes.txt",
+		Size:     int64(len(data)),
+		Md5:      md5Hex(data),
+		Progress: func(uploaded, total int64) {
+			if uploaded < last || total != int64(len(data)) {
+				t.Errorf("progress %d/%d after %d", uploaded, total, last)
+			}
+			last = uploaded
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != int64(len(data)) {
+		t.Errorf("final progress %d, want %d", last, len(data))
+	}
+	if reader.read != int64(len(data)) {
+		t.Errorf("read %d bytes, want a single pass", reader.read)
+	}
+	sent := server.sentMessages()
+	if len(sent) != 1 || sent[0].ToUserName != "@@group" || !strings.Contains(sent[0].Content, "<title>notes.txt</title>") {
+		t.Errorf("sent: %+v", sent)
+	}
+}
+
+// 发送文件时分块上传失败后自动继续上传没有成功的分块
+func TestSendFileResume(t *testing.T) {
+	server := newFakeWeChat(t)
+	server.contacts = Members{{UserName: "@@group", NickName: "工作群"}}
+	server.uploadFailures[2] = DefaultUploadChunkRetryTimes
+	bot := server.login()
+	bot.Caller.Client.UploadParallelism = 1
+	self, _ := bot.GetCurrentUser()
+	groups, err := self.Groups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("0123456789abcdef"), int(chunkSize*4/16))
+	if _, err = groups.First().SendFileWithOptions(context.Background(), bytes.NewReader(data), &SendMediaOptions{FileName: "resume.bin"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.requestCount(webwxcheckupload); got != 1 {
+		t.Errorf("checkupload requests %d, want 1", got)
+	}
+	var chunks []int
+	for _, upload := range server.uploadedChunks() {
+		chunks = append(chunks, upload.Chunk)
+	}
+	if len(chunks) != 4 {
+		t.Errorf("uploaded chunks %v, want [0 1 2 3]", chunks)
+	}
+
+	// 不继续上传时返回分块的错误
+	server.uploadFailures[1] = DefaultUploadChunkRetryTimes
+	bot.Caller.Client.UploadResumeTimes = -1
+	if _, err = groups.First().SendFileWithOptions(context.Background(), bytes.NewReader(data), &SendMediaOptions{FileName: "fail.bin"}); err == nil {
+		t.Error("expected chunk 1 to fail")
+	}
+}
+
+func TestSendVideoWithOptionsCancel(t *testing.T) {
+	server := newFakeWeChat(t)
+	server.contacts = Members{{UserName: "@friend", NickName: "小明"}}
+	server.uploadDelay = 20 * time.Millisecond
+	bot := server.login()
+	self, _ := bot.GetCurrentUser()
+	friends, err := self.Friends()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	data := bytes.Repeat([]byte("v"), int(chunkSize*6))
+	_, err = friends.First().SendVideoWithOptions(ctx, bytes.NewReader(data), &SendMediaOptions{
+		Progress: func(uploaded, total int64) {
+			// 第一个分块上传成功后取消
+			if uploaded > 0 {
+				cancel()
+			}
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if bot.Context().Err() != nil {
+		t.Error("canceling the send should not stop the bot")
+	}
+	for _, upload := range server.uploadedChunks() {
+		if upload.Chunk == 5 {
+			t.Error("last chunk uploaded after cancel")
+		}
+	}
+	if sent := server.sentMessages(); len(sent) != 0 {
+		t.Errorf("sent: %+v", sent)
+	}
+}
+
+func TestUploadPrepareCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	file := NewUploadFile(&streamReader{reader: strings.NewReader("data")})
+	if err := file.prepare(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if file.cleanup != nil {
+		t.Error("temp file not removed after cancel")
+	}
+}
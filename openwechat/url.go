@@ -1,5 +1,7 @@
 package openwechat
 
+import "strings"
+
 //// mode 类型限制
 //type mode string
 //
@@ -35,10 +37,17 @@ const (
 	webwxgeticon         = "/cgi-bin/mmwebwx-bin/webwxgeticon"
 	webwxcreatechatroom  = "/cgi-bin/mmwebwx-bin/webwxcreatechatroom"
 
-	webwxnewloginpage = "https://wx.qq.com/cgi-bin/mmwebwx-bin/webwxnewloginpage"
-	jslogin           = "https://login.wx.qq.com/jslogin"
-	login             = "https://login.wx.qq.com/cgi-bin/mmwebwx-bin/login"
-	qrcode            = "https://login.weixin.qq.com/qrcode/"
+	// 登录前的接口, 不跟随 Domain 变化
+	webwxnewloginpage = "/cgi-bin/mmwebwx-bin/webwxnewloginpage"
+	jslogin           = "/jslogin"
+	login             = "/cgi-bin/mmwebwx-bin/login"
+	qrcode            = "/qrcode/"
+)
+
+const (
+	loginHost        = "https://login.wx.qq.com"
+	qrcodeHost       = "https://login.weixin.qq.com"
+	newLoginPageHost = "https://wx.qq.com"
 )
 
 type WechatDomain string
@@ -54,3 +63,58 @@ func (w WechatDomain) FileHost() string {
 func (w WechatDomain) SyncHost() string {
 	return "https://webpush." + string(w)
 }
+
+// EndpointResolver 根据域名和接口路径返回完整的请求地址
+// path 为接口路径, 如 /cgi-bin/mmwebwx-bin/webwxinit, 可能带有查询参数
+type EndpointResolver interface {
+	ResolveEndpoint(domain WechatDomain, path string) string
+}
+
+// EndpointResolverFunc 函数形式的 EndpointResolver
+type EndpointResolverFunc func(domain WechatDomain, path string) string
+
+func (f EndpointResolverFunc) ResolveEndpoint(domain WechatDomain, path string) string {
+	return f(domain, path)
+}
+
+// DefaultEndpointResolver 默认的请求地址
+// 登录相关的接口使用固定的域名, 其他接口按 Domain 区分主域名、文件域名和同步域名
+var DefaultEndpointResolver EndpointResolver = EndpointResolverFunc(defaultResolveEndpoint)
+
+func defaultResolveEndpoint(domain WechatDomain, path string) string {
+	switch endpointName(path) {
+	case jslogin, login:
+		return loginHost + path
+	case qrcode:
+		return qrcodeHost + path
+	case webwxnewloginpage:
+		return newLoginPageHost + path
+	case synccheck:
+		return domain.SyncHost() + path
+	case webwxuploadmedia, webwxgetmedia:
+		return domain.FileHost() + path
+	default:
+		return domain.BaseHost() + path
+	}
+}
+
+// BaseURLResolver 所有接口都发往 baseURL, 如本地的模拟服务器或者抓包代理
+//
+//	bot.Caller.Client.EndpointResolver = openwechat.BaseURLResolver("http://127.0.0.1:8080")
+func BaseURLResolver(baseURL string) EndpointResolver {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	return EndpointResolverFunc(func(_ WechatDomain, path string) string {
+		return baseURL + path
+	})
+}
+
+// endpointName 去掉路径中的查询参数和 qrcode 后面的 uuid
+func endpointName(path string) string {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	if strings.HasPrefix(path, qrcode) {
+		return qrcode
+	}
+	return path
+}
@@ -0,0 +1,130 @@
+package openwechat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestDefaultEndpointResolver(t *testing.T) {
+	client := DefaultClient()
+	client.Domain = "wx2.qq.com"
+	tests := []struct {
+		path string
+		want string
+	}{
+		{jslogin, "https://login.wx.qq.com/jslogin"},
+		{login, "https://login.wx.qq.com/cgi-bin/mmwebwx-bin/login"},
+		{qrcode + "abc==", "https://login.weixin.qq.com/qrcode/abc=="},
+		{webwxnewloginpage, "https://wx.qq.com/cgi-bin/mmwebwx-bin/webwxnewloginpage"},
+		{webwxinit, "https://wx2.qq.com/cgi-bin/mmwebwx-bin/webwxinit"},
+		{synccheck, "https://webpush.wx2.qq.com/cgi-bin/mmwebwx-bin/synccheck"},
+		{webwxuploadmedia, "https://file.wx2.qq.com/cgi-bin/mmwebwx-bin/webwxuploadmedia"},
+		{webwxgeticon + "?seq=1", "https://wx2.qq.com/cgi-bin/mmwebwx-bin/webwxgeticon?seq=1"},
+	}
+	for _, tt := range tests {
+		if got := client.endpoint(tt.path); got != tt.want {
+			t.Errorf("endpoint(%s) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSetEndpoint(t *testing.T) {
+	client := DefaultClient()
+	client.Domain = "wx.qq.com"
+	client.EndpointResolver = BaseURLResolver("http://127.0.0.1:8080/")
+	client.SetEndpoint(synccheck, "http://127.0.0.1:9090/synccheck")
+	client.SetEndpoint(qrcode, "http://127.0.0.1:9090/qr/")
+	tests := []struct {
+		path string
+		want string
+	}{
+		{synccheck, "http://127.0.0.1:9090/synccheck"},
+		{qrcode + "abc==", "http://127.0.0.1:9090/qr/abc=="},
+		{webwxsync, "http://127.0.0.1:8080/cgi-bin/mmwebwx-bin/webwxsync"},
+		{jslogin, "http://127.0.0.1:8080/jslogin"},
+	}
+	for _, tt := range tests {
+		if got := client.endpoint(tt.path); got != tt.want {
+			t.Errorf("endpoint(%s) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
+
+// 通过 BaseURLResolver 把所有请求发往本地的服务器
+func TestBaseURLResolverLogin(t *testing.T) {
+	server := newFakeWeChat(t)
+	server.contacts = Members{{UserName: "@friend", NickName: "小明"}}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	bot := DefaultBot(Desktop)
+	bot.UUIDCallback = nil
+	bot.Caller.Client.EndpointResolver = BaseURLResolver(httpServer.URL)
+	defer bot.Exit()
+	bot.MessageHandler = func(msg *Message) {
+		if msg.IsText() && msg.Content == "ping" {
+			_, _ = msg.ReplyText("pong")
+		}
+	}
+	if err := bot.Login(); err != nil {
+		t.Fatal(err)
+	}
+	server.pushText("@friend", "@self", "ping")
+	if sent := server.waitSent(1); sent[0].Content != "pong" {
+		t.Errorf("got %+v", sent[0])
+	}
+}
+
+// 扫码后跳转的地址也通过自定义的接口地址请求
+func TestLoginPageEndpoint(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+	}))
+	defer server.Close()
+
+	client := DefaultClient()
+	client.mode = normal
+	client.SetEndpoint(webwxnewloginpage, server.URL+"/newloginpage")
+	redirect, _ := url.Parse("https://wx2.qq.com/cgi-bin/mmwebwx-bin/webwxnewloginpage?ticket=abc")
+	resp, err := client.GetLoginInfo(context.Background(), redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if query != "ticket=abc" {
+		t.Errorf("query = %q", query)
+	}
+
+	// 没有自定义时使用服务器返回的域名
+	client = DefaultClient()
+	if got := client.loginPageURL(redirect); got != redirect.String() {
+		t.Errorf("loginPageURL = %s", got)
+	}
+}
+
+// SetEndpoint 可以和请求同时进行
+func TestSetEndpointConcurrent(t *testing.T) {
+	client := DefaultClient()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			client.SetEndpoint(synccheck, fmt.Sprintf("http://127.0.0.1:%d/synccheck", 9000+i))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		client.endpoint(synccheck)
+	}
+	<-done
+}
+
+func TestGetQrcodeUrl(t *testing.T) {
+	if got := GetQrcodeUrl("abc=="); got != "https://login.weixin.qq.com/qrcode/abc==" {
+		t.Errorf("got %s", got)
+	}
+}
@@ -1,6 +1,7 @@
 package openwechat
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"html"
@@ -12,6 +13,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -278,6 +280,7 @@ type Self struct {
 	*User
 	bot        *Bot
 	fileHelper *Friend
+	mu         sync.RWMutex // 保护下面的联系人缓存, 消息可能在多个goroutine中并发处理
 	members    Members
 	friends    Friends
 	groups     Groups
@@ -288,16 +291,26 @@ type Self struct {
 func (s *Self) Members(update ...bool) (Members, error) {
 	// 首先判断缓存里有没有,如果没有则去更新缓存
 	// 判断是否需要更新,如果传入的参数不为nil,则取第一个
-	if s.members == nil || (len(update) > 0 && update[0]) {
+	force := len(update) > 0 && update[0]
+	if !force {
+		s.mu.RLock()
+		members := s.members
+		s.mu.RUnlock()
+		if members != nil {
+			return members, nil
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.members == nil || force {
 		if err := s.updateMembers(); err != nil {
 			return nil, err
 		}
 	}
-	s.members.Sort()
 	return s.members, nil
 }
 
-// 更新联系人处理
+// 更新联系人处理, 调用时需持有写锁
 func (s *Self) updateMembers() error {
 	info := s.bot.Storage.LoginInfo
 	members, err := s.bot.Caller.WebWxGetContact(s.Bot().Context(), info)
@@ -305,10 +318,33 @@ func (s *Self) updateMembers() error {
 		return err
 	}
 	members.init(s)
-	s.members = members
+	s.setMembers(members)
 	return nil
 }
 
+// setMembers 替换联系人缓存并重新分类, 调用时需持有写锁
+// 每次都生成新的切片, 已经返回给调用方的切片不会被修改
+func (s *Self) setMembers(members Members) {
+	members = append(Members{}, members...).Sort()
+	s.members = members
+	s.friends = members.Friends()
+	s.groups = members.Groups()
+	s.mps = members.MPs()
+}
+
+// addMember 将不在缓存中的联系人加入缓存, 缓存还没有加载时不做处理
+func (s *Self) addMember(user *User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.members == nil {
+		return
+	}
+	if _, exist := s.members.GetByUserName(user.UserName); exist {
+		return
+	}
+	s.setMembers(s.members.Append(user))
+}
+
 // FileHelper 获取文件传输助手对象，封装成Friend返回
 //
 //	fh := self.FileHelper() // or fh := openwechat.NewFriendHelper(self)
@@ -319,47 +355,38 @@ func (s *Self) FileHelper() *Friend {
 	return s.fileHelper
 }
 func (s *Self) ChkFrdGrpMpNil() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.friends == nil && s.groups == nil && s.mps == nil
 }
 
 // Friends 获取所有的好友
 func (s *Self) Friends(update ...bool) (Friends, error) {
-	if (len(update) > 0 && update[0]) || s.ChkFrdGrpMpNil() {
-		if _, err := s.Members(true); err != nil {
-			return nil, err
-		}
-	}
-	if s.friends == nil || (len(update) > 0 && update[0]) {
-		s.friends = s.members.Friends()
+	if _, err := s.Members(update...); err != nil {
+		return nil, err
 	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.friends, nil
 }
 
 // Groups 获取所有的群组
 func (s *Self) Groups(update ...bool) (Groups, error) {
-
-	if (len(update) > 0 && update[0]) || s.ChkFrdGrpMpNil() {
-		if _, err := s.Members(true); err != nil {
-			return nil, err
-		}
-
-	}
-	if s.groups == nil || (len(update) > 0 && update[0]) {
-		s.groups = s.members.Groups()
+	if _, err := s.Members(update...); err != nil {
+		return nil, err
 	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.groups, nil
 }
 
 // Mps 获取所有的公众号
 func (s *Self) Mps(update ...bool) (Mps, error) {
-	if (len(update) > 0 && update[0]) || s.ChkFrdGrpMpNil() {
-		if _, err := s.Members(true); err != nil {
-			return nil, err
-		}
-	}
-	if s.mps == nil || (len(update) > 0 && update[0]) {
-		s.mps = s.members.MPs()
+	if _, err := s.Members(update...); err != nil {
+		return nil, err
 	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.mps, nil
 }
 
@@ -385,6 +412,10 @@ func (s *Self) sendTextToUser(username, text string) (*SentMessage, error) {
 }
 
 func (s *Self) sendImageToUser(username string, file io.Reader) (*SentMessage, error) {
+	return s.sendImageToUserWithOptions(s.Bot().Context(), username, file, nil)
+}
+
+func (s *Self) sendImageToUserWithOptions(ctx context.Context, username string, file io.Reader, options *SendMediaOptions) (*SentMessage, error) {
 	opt := &CallerWebWxSendImageMsgOptions{
 		FromUserName: s.UserName,
 		ToUserName:   username,
@@ -392,11 +423,16 @@ func (s *Self) sendImageToUser(username string, file io.Reader) (*SentMessage, e
 		BaseRequest:  s.bot.Storage.Request,
 		LoginInfo:    s.bot.Storage.LoginInfo,
 	}
-	sentMessage, err := s.bot.Caller.WebWxSendImageMsg(s.Bot().Context(), opt)
+	options.apply((*CallerUploadMediaCommonOptions)(opt))
+	sentMessage, err := s.bot.Caller.WebWxSendImageMsg(ctx, opt)
 	return s.sendMessageWrapper(sentMessage, err)
 }
 
 func (s *Self) sendVideoToUser(username string, file io.Reader) (*SentMessage, error) {
+	return s.sendVideoToUserWithOptions(s.Bot().Context(), username, file, nil)
+}
+
+func (s *Self) sendVideoToUserWithOptions(ctx context.Context, username string, file io.Reader, options *SendMediaOptions) (*SentMessage, error) {
 	opt := &CallerWebWxSendAppMsgOptions{
 		FromUserName: s.UserName,
 		ToUserName:   username,
@@ -404,11 +440,16 @@ func (s *Self) sendVideoToUser(username string, file io.Reader) (*SentMessage, e
 		BaseRequest:  s.bot.Storage.Request,
 		LoginInfo:    s.bot.Storage.LoginInfo,
 	}
-	sentMessage, err := s.bot.Caller.WebWxSendVideoMsg(s.Bot().Context(), opt)
+	options.apply((*CallerUploadMediaCommonOptions)(opt))
+	sentMessage, err := s.bot.Caller.WebWxSendVideoMsg(ctx, opt)
 	return s.sendMessageWrapper(sentMessage, err)
 }
 
 func (s *Self) sendFileToUser(username string, file io.Reader) (*SentMessage, error) {
+	return s.sendFileToUserWithOptions(s.Bot().Context(), username, file, nil)
+}
+
+func (s *Self) sendFileToUserWithOptions(ctx context.Context, username string, file io.Reader, options *SendMediaOptions) (*SentMessage, error) {
 	opt := &CallerWebWxSendFileOptions{
 		FromUserName: s.UserName,
 		ToUserName:   username,
@@ -416,7 +457,8 @@ func (s *Self) sendFileToUser(username string, file io.Reader) (*SentMessage, er
 		BaseRequest:  s.bot.Storage.Request,
 		LoginInfo:    s.bot.Storage.LoginInfo,
 	}
-	sentMessage, err := s.bot.Caller.WebWxSendFile(s.Bot().Context(), opt)
+	options.apply((*CallerUploadMediaCommonOptions)(opt))
+	sentMessage, err := s.bot.Caller.WebWxSendFile(ctx, opt)
 	return s.sendMessageWrapper(sentMessage, err)
 }
 
@@ -481,7 +523,7 @@ func (s *Self) CreateGroup(topic string, friends ...*Friend) (*Group, error) {
 		return nil, err
 	}
 	// 添加到群组列表
-	s.groups = append(s.groups, group)
+	s.addMember(group.User)
 	return group, nil
 }
 
@@ -0,0 +1,169 @@
+package openwechat
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bot 退出时等待工作池处理完剩余消息的最长时间
+const workerPoolDrainTimeout = 30 * time.Second
+
+// WorkerPoolStats 工作池的运行统计
+type WorkerPoolStats struct {
+	Workers    int           // 工作goroutine数量
+	Pending    int           // 排队等待处理的消息数量
+	Submitted  uint64        // 提交的消息总数
+	Processed  uint64        // 处理完成的消息总数
+	Blocked    uint64        // 提交时队列已满需要等待的次数
+	BlockedFor time.Duration // 提交时等待的总时长
+}
+
+// WorkerPool 有界的消息处理工作池
+// 同一个会话的消息总是交给同一个工作goroutine, 保证处理顺序, 不同会话的消息并行处理
+// 队列满时提交会阻塞, 从而让同步消息的长轮询放慢速度
+type WorkerPool struct {
+	// PanicHandler 任务 panic 时调用, 为空时打印 panic 的内容和调用栈, 工作goroutine会继续处理后面的任务
+	PanicHandler func(v any)
+
+	queues     []chan func()
+	mu         sync.RWMutex  // 关闭时等待正在提交的任务进入队列
+	done       chan struct{} // 关闭后不再接收新的任务
+	stop       chan struct{} // 所有提交都结束后通知工作goroutine退出
+	closeOnce  sync.Once
+	wg         sync.WaitGroup
+	submitted  atomic.Uint64
+	processed  atomic.Uint64
+	blocked    atomic.Uint64
+	blockedFor atomic.Int64
+}
+
+// NewWorkerPool 创建一个工作池, workers 为工作goroutine数量, queueSize 为每个goroutine的队列长度
+func NewWorkerPool(workers, queueSize int) *WorkerPool {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	p := &WorkerPool{
+		queues: make([]chan func(), workers),
+		done:   make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan func(), queueSize)
+		p.wg.Add(1)
+		go p.work(p.queues[i])
+	}
+	return p
+}
+
+func (p *WorkerPool) work(queue chan func()) {
+	defer p.wg.Done()
+	for {
+		select {
+		case task := <-queue:
+			p.run(task)
+		case <-p.stop:
+			// 关闭后处理完队列中剩余的消息再退出
+			for {
+				select {
+				case task := <-queue:
+					p.run(task)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *WorkerPool) run(task func()) {
+	defer p.processed.Add(1)
+	defer func() {
+		if v := recover(); v != nil {
+			if p.PanicHandler != nil {
+				p.PanicHandler(v)
+				return
+			}
+			slog.Error("worker pool task panic", "panic", v, "stack", string(debug.Stack()))
+		}
+	}()
+	task()
+}
+
+// Submit 提交一个任务, 相同 key 的任务按提交顺序依次执行
+// 工作池已经关闭时返回 false, 任务不会被执行
+func (p *WorkerPool) Submit(key string, task func()) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	select {
+	case <-p.done:
+		return false
+	default:
+	}
+	queue := p.queues[p.index(key)]
+	select {
+	case queue <- task:
+	default:
+		// 队列已满, 等待工作goroutine处理
+		p.blocked.Add(1)
+		start := time.Now()
+		select {
+		case queue <- task:
+			p.blockedFor.Add(int64(time.Since(start)))
+		case <-p.done:
+			return false
+		}
+	}
+	p.submitted.Add(1)
+	return true
+}
+
+func (p *WorkerPool) index(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// Stats 获取工作池的运行统计
+func (p *WorkerPool) Stats() WorkerPoolStats {
+	stats := WorkerPoolStats{
+		Workers:    len(p.queues),
+		Submitted:  p.submitted.Load(),
+		Processed:  p.processed.Load(),
+		Blocked:    p.blocked.Load(),
+		BlockedFor: time.Duration(p.blockedFor.Load()),
+	}
+	for _, queue := range p.queues {
+		stats.Pending += len(queue)
+	}
+	return stats
+}
+
+// Close 停止接收新的任务, 并等待已经提交的任务执行完成
+// ctx 结束时不再等待, 返回 ctx 的错误, 剩余的任务仍会在后台执行完
+func (p *WorkerPool) Close(ctx context.Context) error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.mu.Lock()
+		close(p.stop)
+		p.mu.Unlock()
+	})
+	finished := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
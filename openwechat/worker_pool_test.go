@@ -0,0 +1,130 @@
+package openwechat
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolOrdering(t *testing.T) {
+	pool := NewWorkerPool(4, 8)
+	var mu sync.Mutex
+	got := make(map[string][]int)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("@chat%d", i%5)
+		i := i
+		pool.Submit(key, func() {
+			mu.Lock()
+			got[key] = append(got[key], i)
+			mu.Unlock()
+		})
+	}
+	if err := pool.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for key, seq := range got {
+		if len(seq) != 20 {
+			t.Errorf("%s: got %d tasks, want 20", key, len(seq))
+		}
+		for j := 1; j < len(seq); j++ {
+			if seq[j] < seq[j-1] {
+				t.Errorf("%s: out of order: %v", key, seq)
+				break
+			}
+		}
+	}
+	if stats := pool.Stats(); stats.Submitted != 100 || stats.Processed != 100 || stats.Pending != 0 {
+		t.Errorf("stats: %+v", stats)
+	}
+}
+
+func TestWorkerPoolParallel(t *testing.T) {
+	pool := NewWorkerPool(2, 1)
+	defer pool.Close(context.Background())
+
+	// 找到分配给不同工作goroutine的两个会话
+	a, b := "@a", ""
+	for i := 0; b == ""; i++ {
+		if key := fmt.Sprintf("@b%d", i); pool.index(key) != pool.index(a) {
+			b = key
+		}
+	}
+	release := make(chan struct{})
+	pool.Submit(a, func() { <-release })
+	done := make(chan struct{})
+	pool.Submit(b, func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("slow conversation blocked another conversation")
+	}
+	close(release)
+}
+
+func TestWorkerPoolBackpressure(t *testing.T) {
+	pool := NewWorkerPool(1, 1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	pool.Submit("@a", func() { close(started); <-release })
+	<-started
+	pool.Submit("@a", func() {}) // 进入队列
+
+	submitted := make(chan struct{})
+	go func() {
+		pool.Submit("@a", func() {})
+		close(submitted)
+	}()
+	select {
+	case <-submitted:
+		t.Fatal("submit should block while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-submitted
+	if err := pool.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if stats := pool.Stats(); stats.Blocked != 1 || stats.Processed != 3 {
+		t.Errorf("stats: %+v", stats)
+	}
+}
+
+func TestWorkerPoolClose(t *testing.T) {
+	pool := NewWorkerPool(1, 4)
+	release := make(chan struct{})
+	pool.Submit("@a", func() { <-release })
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := pool.Close(ctx); err == nil {
+		t.Fatal("close should time out while a task is running")
+	}
+	if pool.Submit("@a", func() {}) {
+		t.Fatal("submit after close should fail")
+	}
+	close(release)
+	if err := pool.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWorkerPoolPanic(t *testing.T) {
+	pool := NewWorkerPool(1, 4)
+	recovered := make(chan any, 1)
+	pool.PanicHandler = func(v any) { recovered <- v }
+	pool.Submit("@a", func() { panic("boom") })
+	done := make(chan struct{})
+	pool.Submit("@a", func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("worker stopped after a task panicked")
+	}
+	if v := <-recovered; v != "boom" {
+		t.Errorf("recovered %v, want boom", v)
+	}
+	if err := pool.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
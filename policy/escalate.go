@@ -0,0 +1,133 @@
+package policy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"bestrui/wechatpush/logging"
+)
+
+var logger = logging.For("policy")
+
+// Notifier 升级提醒使用的第二个通知渠道
+type Notifier interface {
+	Notify(title, content string) error
+}
+
+// NotifierFunc 函数形式的 Notifier
+type NotifierFunc func(title, content string) error
+
+// Notify 实现 Notifier 接口
+func (f NotifierFunc) Notify(title, content string) error {
+	return f(title, content)
+}
+
+type pendingAlert struct {
+	conversation string
+	title        string
+	content      string
+	deadline     time.Time
+}
+
+// Escalator 跟踪没有确认的通知, 超时后通过 Notifier 再次提醒
+type Escalator struct {
+	notifier Notifier
+	mu       sync.Mutex
+	pending  map[string]*pendingAlert
+	// Now 返回当前时间, 测试时可以替换
+	Now func() time.Time
+}
+
+// NewEscalator 创建 Escalator
+func NewEscalator(notifier Notifier) *Escalator {
+	return &Escalator{
+		notifier: notifier,
+		pending:  make(map[string]*pendingAlert),
+		Now:      time.Now,
+	}
+}
+
+// Track 开始跟踪一条通知, 返回用于确认的 id
+func (e *Escalator) Track(conversation, title, content string, after time.Duration) string {
+	id := newID()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pending[id] = &pendingAlert{
+		conversation: conversation,
+		title:        title,
+		content:      content,
+		deadline:     e.Now().Add(after),
+	}
+	return id
+}
+
+// Ack 确认一条通知, 返回通知是否存在
+func (e *Escalator) Ack(id string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.pending[id]
+	delete(e.pending, id)
+	return ok
+}
+
+// AckConversation 确认会话中的所有通知, 例如自己在会话中回复了消息
+func (e *Escalator) AckConversation(conversation string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for id, alert := range e.pending {
+		if alert.conversation == conversation {
+			delete(e.pending, id)
+		}
+	}
+}
+
+// Pending 返回还没有确认的通知数量
+func (e *Escalator) Pending() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.pending)
+}
+
+// Fire 发送所有已经超时的升级提醒, 返回发送的数量
+func (e *Escalator) Fire() int {
+	now := e.Now()
+	var due []*pendingAlert
+	e.mu.Lock()
+	for id, alert := range e.pending {
+		if !now.Before(alert.deadline) {
+			due = append(due, alert)
+			delete(e.pending, id)
+		}
+	}
+	e.mu.Unlock()
+
+	for _, alert := range due {
+		if err := e.notifier.Notify("[未确认] "+alert.title, alert.content); err != nil {
+			logger.Error("发送升级提醒失败", "conversation", alert.conversation, "error", err)
+		}
+	}
+	return len(due)
+}
+
+// Run 定期发送超时的升级提醒, 直到 ctx 结束
+func (e *Escalator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.Fire()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,193 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Rule 通知规则, 按顺序匹配, 第一条匹配的规则生效
+type Rule struct {
+	Name string `json:"name"`
+	// Conversations 群名称或者好友名称, 为空时匹配所有会话
+	Conversations []string `json:"conversations,omitempty"`
+	// Senders 发送者名称, 为空时匹配所有发送者
+	Senders []string `json:"senders,omitempty"`
+	// MentionsMe 只匹配@我的消息
+	MentionsMe bool `json:"mentionsMe,omitempty"`
+	// QuietHours 免打扰时间段, 例如 "22:00-07:00"
+	QuietHours string `json:"quietHours,omitempty"`
+	// Schedule 只在这些时间段内通知, 例如 ["mon-fri 09:00-18:00"]
+	Schedule []string `json:"schedule,omitempty"`
+	// WorkHoursOnly 只在工作时间内通知
+	WorkHoursOnly bool `json:"workHoursOnly,omitempty"`
+	// EscalateAfter @我的消息在这段时间内没有确认时, 通过第二个通知渠道再次提醒, 例如 "10m"
+	EscalateAfter string `json:"escalateAfter,omitempty"`
+}
+
+// Message 用于匹配规则的消息
+type Message struct {
+	Conversation string
+	Sender       string
+	MentionsMe   bool
+}
+
+// Decision 规则匹配的结果
+type Decision struct {
+	Notify bool
+	// Rule 匹配的规则名称, 没有匹配时为空
+	Rule string
+	// Reason 不通知的原因
+	Reason string
+	// EscalateAfter 大于 0 时需要升级提醒
+	EscalateAfter time.Duration
+}
+
+type compiledRule struct {
+	Rule
+	quiet         *Window
+	schedule      []Schedule
+	escalateAfter time.Duration
+}
+
+// Engine 根据规则和当前时间决定是否通知
+type Engine struct {
+	rules     []compiledRule
+	location  *time.Location
+	workHours []Schedule
+	// Now 返回当前时间, 测试时可以替换
+	Now func() time.Time
+}
+
+// DefaultWorkHours 默认的工作时间
+const DefaultWorkHours = "mon-fri 09:00-18:00"
+
+// NewEngine 创建规则引擎, workHours 为空时使用 DefaultWorkHours
+func NewEngine(rules []Rule, location *time.Location, workHours []string) (*Engine, error) {
+	if location == nil {
+		location = DefaultLocation()
+	}
+	if len(workHours) == 0 {
+		workHours = []string{DefaultWorkHours}
+	}
+	e := &Engine{location: location, Now: time.Now}
+	for _, s := range workHours {
+		schedule, err := ParseSchedule(s)
+		if err != nil {
+			return nil, fmt.Errorf("工作时间: %w", err)
+		}
+		e.workHours = append(e.workHours, schedule)
+	}
+	for i, rule := range rules {
+		compiled := compiledRule{Rule: rule}
+		if compiled.Name == "" {
+			compiled.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if rule.QuietHours != "" {
+			window, err := ParseWindow(rule.QuietHours)
+			if err != nil {
+				return nil, fmt.Errorf("规则 %s: %w", compiled.Name, err)
+			}
+			compiled.quiet = &window
+		}
+		for _, s := range rule.Schedule {
+			schedule, err := ParseSchedule(s)
+			if err != nil {
+				return nil, fmt.Errorf("规则 %s: %w", compiled.Name, err)
+			}
+			compiled.schedule = append(compiled.schedule, schedule)
+		}
+		if rule.EscalateAfter != "" {
+			d, err := time.ParseDuration(rule.EscalateAfter)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("规则 %s: escalateAfter %q 无效", compiled.Name, rule.EscalateAfter)
+			}
+			compiled.escalateAfter = d
+		}
+		e.rules = append(e.rules, compiled)
+	}
+	return e, nil
+}
+
+// ParseRules 解析 JSON 格式的规则列表
+func ParseRules(data string) ([]Rule, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var rules []Rule
+	if err := json.Unmarshal([]byte(data), &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// DefaultLocation 使用环境变量 TZ 指定的时区, 默认为 Asia/Shanghai
+func DefaultLocation() *time.Location {
+	name := os.Getenv("TZ")
+	if name == "" {
+		name = "Asia/Shanghai"
+	}
+	if location, err := time.LoadLocation(name); err == nil {
+		return location
+	}
+	// 没有安装 tzdata 时使用固定的东八区
+	return time.FixedZone("CST", 8*60*60)
+}
+
+// Decide 决定是否通知这条消息, 没有匹配的规则时通知
+func (e *Engine) Decide(msg Message) Decision {
+	now := e.Now().In(e.location)
+	for _, rule := range e.rules {
+		if !rule.match(msg) {
+			continue
+		}
+		decision := Decision{Rule: rule.Name}
+		switch {
+		case rule.quiet != nil && rule.quiet.Contains(now.Hour()*60+now.Minute()):
+			decision.Reason = "免打扰时间"
+		case len(rule.schedule) > 0 && !inSchedules(rule.schedule, now):
+			decision.Reason = "不在通知时间内"
+		case rule.WorkHoursOnly && !inSchedules(e.workHours, now):
+			decision.Reason = "不在工作时间内"
+		default:
+			decision.Notify = true
+			if msg.MentionsMe {
+				decision.EscalateAfter = rule.escalateAfter
+			}
+		}
+		return decision
+	}
+	return Decision{Notify: true}
+}
+
+func (r compiledRule) match(msg Message) bool {
+	if r.MentionsMe && !msg.MentionsMe {
+		return false
+	}
+	if len(r.Conversations) > 0 && !contains(r.Conversations, msg.Conversation) {
+		return false
+	}
+	if len(r.Senders) > 0 && !contains(r.Senders, msg.Sender) {
+		return false
+	}
+	return true
+}
+
+func inSchedules(schedules []Schedule, t time.Time) bool {
+	for _, schedule := range schedules {
+		if schedule.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+var shanghai = time.FixedZone("CST", 8*60*60)
+
+// fakeClock 可以手动调整的时钟
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func at(s string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", s, shanghai)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseSchedule(t *testing.T) {
+	cases := []struct {
+		schedule string
+		time     string
+		want     bool
+	}{
+		{"mon-fri 09:00-18:00", "2023-09-18 09:00", true}, // 周一
+		{"mon-fri 09:00-18:00", "2023-09-18 18:00", false},
+		{"mon-fri 09:00-18:00", "2023-09-16 10:00", false}, // 周六
+		{"sat,sun 10:00-12:00", "2023-09-17 11:30", true},
+		{"fri-mon 20:00-02:00", "2023-09-16 01:00", true}, // 周六凌晨属于周五晚上
+		{"fri 22:00-02:00", "2023-09-16 01:00", true},     // 周六凌晨属于周五晚上
+		{"fri 22:00-02:00", "2023-09-15 01:00", false},    // 周五凌晨属于周四晚上
+		{"00:00-23:59", "2023-09-17 12:00", true},
+		{"00:00-00:00", "2023-09-17 23:59", true},
+		{"sat 07:00-07:00", "2023-09-17 06:59", true},  // 周日早上属于周六开始的一整天
+		{"sat 07:00-07:00", "2023-09-16 06:59", false}, // 周六早上属于周五开始的一整天
+	}
+	for _, c := range cases {
+		schedule, err := ParseSchedule(c.schedule)
+		if err != nil {
+			t.Fatalf("%s: %v", c.schedule, err)
+		}
+		if got := schedule.Contains(at(c.time)); got != c.want {
+			t.Errorf("%s at %s = %v, want %v", c.schedule, c.time, got, c.want)
+		}
+	}
+	for _, invalid := range []string{"mon-fri", "xyz 09:00-10:00", "mon 9-10", "a b c"} {
+		if _, err := ParseSchedule(invalid); err == nil {
+			t.Errorf("%q should be invalid", invalid)
+		}
+	}
+}
+
+func TestEngineDecide(t *testing.T) {
+	rules := []Rule{
+		{Name: "boss", MentionsMe: true, EscalateAfter: "10m"},
+		{Name: "family", Conversations: []string{"相亲相爱一家人"}, QuietHours: "22:00-07:00"},
+		{Name: "work", Conversations: []string{"项目群"}, WorkHoursOnly: true},
+		{Name: "weekend", Conversations: []string{"羽毛球群"}, Schedule: []string{"sat,sun 08:00-20:00"}},
+	}
+	clock := &fakeClock{}
+	engine, err := NewEngine(rules, shanghai, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.Now = clock.Now
+
+	cases := []struct {
+		time   string
+		msg    Message
+		notify bool
+		rule   string
+	}{
+		{"2023-09-16 23:30", Message{Conversation: "相亲相爱一家人"}, false, "family"},
+		{"2023-09-16 07:00", Message{Conversation: "相亲相爱一家人"}, true, "family"},
+		{"2023-09-16 23:30", Message{Conversation: "相亲相爱一家人", MentionsMe: true}, true, "boss"},
+		{"2023-09-18 10:00", Message{Conversation: "项目群"}, true, "work"},
+		{"2023-09-18 19:00", Message{Conversation: "项目群"}, false, "work"},
+		{"2023-09-17 10:00", Message{Conversation: "项目群"}, false, "work"},
+		{"2023-09-17 10:00", Message{Conversation: "羽毛球群"}, true, "weekend"},
+		{"2023-09-18 10:00", Message{Conversation: "羽毛球群"}, false, "weekend"},
+		{"2023-09-18 03:00", Message{Conversation: "其他群"}, true, ""},
+	}
+	for _, c := range cases {
+		clock.now = at(c.time)
+		got := engine.Decide(c.msg)
+		if got.Notify != c.notify || got.Rule != c.rule {
+			t.Errorf("%s %+v: got %+v, want notify=%v rule=%s", c.time, c.msg, got, c.notify, c.rule)
+		}
+	}
+
+	clock.now = at("2023-09-18 10:00")
+	if got := engine.Decide(Message{Conversation: "项目群", MentionsMe: true}); got.EscalateAfter != 10*time.Minute {
+		t.Errorf("escalate after = %s", got.EscalateAfter)
+	}
+}
+
+func TestEngineInvalidRule(t *testing.T) {
+	for _, rule := range []Rule{{QuietHours: "22-7"}, {Schedule: []string{"mon"}}, {EscalateAfter: "soon"}} {
+		if _, err := NewEngine([]Rule{rule}, shanghai, nil); err == nil {
+			t.Errorf("%+v should be invalid", rule)
+		}
+	}
+}
+
+func TestEscalator(t *testing.T) {
+	clock := &fakeClock{now: at("2023-09-18 10:00")}
+	var sent []string
+	escalator := NewEscalator(NotifierFunc(func(title, content string) error {
+		sent = append(sent, title+"|"+content)
+		return nil
+	}))
+	escalator.Now = clock.Now
+
+	acked := escalator.Track("@@group", "张三", "@我 开会", 10*time.Minute)
+	escalator.Track("@@group", "李四", "@我 在吗", 10*time.Minute)
+	escalator.Track("@friend", "王五", "@我 吃饭", 5*time.Minute)
+
+	clock.now = clock.now.Add(4 * time.Minute)
+	if n := escalator.Fire(); n != 0 {
+		t.Fatalf("fired %d before deadline", n)
+	}
+	if !escalator.Ack(acked) || escalator.Ack(acked) {
+		t.Error("ack should succeed exactly once")
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	if n := escalator.Fire(); n != 1 || sent[0] != "[未确认] 王五|@我 吃饭" {
+		t.Fatalf("fired %d: %v", n, sent)
+	}
+
+	escalator.AckConversation("@@group")
+	clock.now = clock.now.Add(time.Hour)
+	if n := escalator.Fire(); n != 0 || escalator.Pending() != 0 {
+		t.Errorf("fired %d after conversation ack, pending %d", n, escalator.Pending())
+	}
+}
@@ -0,0 +1,114 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window 一天中的时间段, 以分钟表示, End 小于 Start 时跨越午夜, End 等于 Start 时表示从 Start 开始的一整天
+type Window struct {
+	Start, End int
+}
+
+// ParseWindow 解析 "22:00-07:00" 格式的时间段
+func ParseWindow(s string) (Window, error) {
+	start, end, ok := strings.Cut(strings.TrimSpace(s), "-")
+	if !ok {
+		return Window{}, fmt.Errorf("时间段 %q 格式错误, 应为 HH:MM-HH:MM", s)
+	}
+	var w Window
+	var err error
+	if w.Start, err = parseClock(start); err != nil {
+		return Window{}, err
+	}
+	if w.End, err = parseClock(end); err != nil {
+		return Window{}, err
+	}
+	return w, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("时间 %q 格式错误, 应为 HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Contains 判断一天中的第 minute 分钟是否在时间段内
+func (w Window) Contains(minute int) bool {
+	if w.Start == w.End {
+		return true
+	}
+	if w.Start < w.End {
+		return minute >= w.Start && minute < w.End
+	}
+	return minute >= w.Start || minute < w.End
+}
+
+// Schedule 每周的某几天中的一个时间段
+type Schedule struct {
+	Days   [7]bool
+	Window Window
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseSchedule 解析 "mon-fri 09:00-18:00" 或 "sat,sun 10:00-12:00" 格式的日程, 省略星期时表示每天
+func ParseSchedule(s string) (Schedule, error) {
+	fields := strings.Fields(s)
+	var schedule Schedule
+	var window string
+	switch len(fields) {
+	case 1:
+		for i := range schedule.Days {
+			schedule.Days[i] = true
+		}
+		window = fields[0]
+	case 2:
+		for _, part := range strings.Split(fields[0], ",") {
+			from, to, isRange := strings.Cut(strings.ToLower(part), "-")
+			start, ok := weekdays[from]
+			if !ok {
+				return Schedule{}, fmt.Errorf("日程 %q 中的星期 %q 无效", s, from)
+			}
+			end := start
+			if isRange {
+				if end, ok = weekdays[to]; !ok {
+					return Schedule{}, fmt.Errorf("日程 %q 中的星期 %q 无效", s, to)
+				}
+			}
+			for day := start; ; day = (day + 1) % 7 {
+				schedule.Days[day] = true
+				if day == end {
+					break
+				}
+			}
+		}
+		window = fields[1]
+	default:
+		return Schedule{}, fmt.Errorf("日程 %q 格式错误, 应为 \"mon-fri 09:00-18:00\"", s)
+	}
+	var err error
+	if schedule.Window, err = ParseWindow(window); err != nil {
+		return Schedule{}, err
+	}
+	return schedule, nil
+}
+
+// Contains 判断 t 是否在日程内, 跨越午夜的时间段按开始的那一天计算
+func (s Schedule) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if !s.Window.Contains(minute) {
+		return false
+	}
+	day := t.Weekday()
+	if s.Window.Start >= s.Window.End && minute < s.Window.Start {
+		day = (day + 6) % 7
+	}
+	return s.Days[day]
+}
@@ -0,0 +1,181 @@
+package recall
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"bestrui/wechatpush/openwechat"
+)
+
+// Entry 最近收到的一条消息
+type Entry struct {
+	MsgId        string    `json:"msgId"`
+	Conversation string    `json:"conversation"`
+	Sender       string    `json:"sender"`
+	Content      string    `json:"content"`
+	Media        []string  `json:"media,omitempty"`
+	Time         time.Time `json:"time"`
+	// Notified 原消息是否已经发送过通知
+	Notified bool `json:"notified"`
+}
+
+// Cache 按 MsgId 缓存最近的消息, 超过容量时淘汰最久未访问的消息
+type Cache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	items    map[string]*list.Element
+	version  uint64     // 每次修改加一
+	saved    uint64     // 已经保存到文件的 version
+	saveMu   sync.Mutex // 保证保存按顺序进行
+}
+
+// NewCache 创建容量为 capacity 的缓存
+func NewCache(capacity int) *Cache {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &Cache{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Add 添加或替换一条消息
+func (c *Cache) Add(entry Entry) {
+	if entry.MsgId == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(entry)
+	c.version++
+}
+
+func (c *Cache) add(entry Entry) {
+	if elem, ok := c.items[entry.MsgId]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[entry.MsgId] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(Entry).MsgId)
+	}
+}
+
+// Get 根据 MsgId 获取消息
+func (c *Cache) Get(msgId string) (Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[msgId]
+	if !ok {
+		return Entry{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(Entry), true
+}
+
+// Remove 删除一条消息, 撤回通知发送后不再需要
+func (c *Cache) Remove(msgId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[msgId]; ok {
+		c.order.Remove(elem)
+		delete(c.items, msgId)
+		c.version++
+	}
+}
+
+// Len 返回缓存中消息的数量
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Save 将缓存保存到文件, 没有变化时跳过
+// 保存失败时下次仍然会保存
+func (c *Cache) Save(path string) error {
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+	c.mu.Lock()
+	if c.version == c.saved {
+		c.mu.Unlock()
+		return nil
+	}
+	version := c.version
+	// 从旧到新保存, 加载时按顺序添加即可恢复访问顺序
+	entries := make([]Entry, 0, c.order.Len())
+	for elem := c.order.Back(); elem != nil; elem = elem.Prev() {
+		entries = append(entries, elem.Value.(Entry))
+	}
+	c.mu.Unlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".recall-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.saved = version
+	c.mu.Unlock()
+	return nil
+}
+
+// Load 从文件加载缓存, 文件不存在时不返回错误
+func (c *Cache) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []Entry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("解析撤回缓存 %s 失败: %w", path, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range entries {
+		c.add(entry)
+	}
+	return nil
+}
+
+// OriginalIDs 返回撤回通知中原消息可能的 MsgId
+// 网页版的 MsgId 对应 msgid, oldmsgid 为旧版本的消息 id
+func OriginalIDs(revoke *openwechat.RevokeMsg) []string {
+	var ids []string
+	for _, id := range []int64{revoke.RevokeMsg.MsgId, revoke.RevokeMsg.OldMsgId} {
+		if id != 0 {
+			ids = append(ids, strconv.FormatInt(id, 10))
+		}
+	}
+	return ids
+}
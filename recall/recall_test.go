@@ -0,0 +1,85 @@
+package recall
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCacheEviction(t *testing.T) {
+	c := NewCache(2)
+	c.Add(Entry{MsgId: "1", Content: "a"})
+	c.Add(Entry{MsgId: "2", Content: "b"})
+	// 访问 1 之后, 2 成为最久未访问的消息
+	if _, ok := c.Get("1"); !ok {
+		t.Fatal("1 should exist")
+	}
+	c.Add(Entry{MsgId: "3", Content: "c"})
+	if _, ok := c.Get("2"); ok {
+		t.Error("2 should be evicted")
+	}
+	if c.Len() != 2 {
+		t.Errorf("len = %d", c.Len())
+	}
+	c.Remove("1")
+	if _, ok := c.Get("1"); ok {
+		t.Error("1 should be removed")
+	}
+}
+
+func TestCachePersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recall.json")
+	c := NewCache(3)
+	now := time.Date(2023, 9, 16, 20, 0, 0, 0, time.UTC)
+	for i := 1; i <= 4; i++ {
+		c.Add(Entry{MsgId: strconv.Itoa(i), Content: "msg" + strconv.Itoa(i), Media: []string{"hash"}, Time: now, Notified: true})
+	}
+	if err := c.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewCache(2)
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 2 {
+		t.Fatalf("len = %d", loaded.Len())
+	}
+	entry, ok := loaded.Get("4")
+	if !ok || entry.Content != "msg4" || !entry.Notified || len(entry.Media) != 1 || !entry.Time.Equal(now) {
+		t.Errorf("entry = %+v", entry)
+	}
+	if _, ok = loaded.Get("2"); ok {
+		t.Error("2 should be evicted on load")
+	}
+
+	if err := NewCache(1).Load(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestCacheSaveRetry(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	path := filepath.Join(dir, "recall.json")
+	c := NewCache(3)
+	c.Add(Entry{MsgId: "1", Content: "msg1"})
+	if err := c.Save(path); err == nil {
+		t.Fatal("save into a missing directory should fail")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// 上一次保存失败, 没有新的修改也要再次保存
+	if err := c.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewCache(3)
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.Get("1"); !ok {
+		t.Error("entry was not saved after the failed attempt")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bestrui/wechatpush/archive"
+	"bestrui/wechatpush/logging"
+	"bestrui/wechatpush/recall"
+	"os"
+	"strconv"
+	"time"
+
+	"bestrui/wechatpush/openwechat"
+)
+
+var recallLog = logging.For("recall")
+
+// 最近消息缓存, 用于在消息被撤回时发送原始内容
+var recallCache = recall.NewCache(1000)
+
+// 撤回缓存的持久化文件, 为空时只保存在内存中
+var recallCacheFile string
+
+// 初始化撤回缓存, 配置了 RECALL_CACHE_FILE 时定期保存到文件
+func initRecallCache() {
+	if value := os.Getenv("RECALL_CACHE_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			recallLog.Warn("环境变量 RECALL_CACHE_SIZE 无效, 使用默认值 1000", "value", value)
+		} else {
+			recallCache = recall.NewCache(size)
+		}
+	}
+	recallCacheFile = os.Getenv("RECALL_CACHE_FILE")
+	if recallCacheFile == "" {
+		return
+	}
+	if err := recallCache.Load(recallCacheFile); err != nil {
+		recallLog.Error("加载撤回缓存失败", "error", err)
+	}
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			flushRecallCache()
+		}
+	}()
+}
+
+// 保存撤回缓存, 退出前也需要调用, 否则重启后找不到最后一分钟内消息的原始内容
+func flushRecallCache() {
+	if recallCacheFile == "" {
+		return
+	}
+	if err := recallCache.Save(recallCacheFile); err != nil {
+		recallLog.Error("保存撤回缓存失败", "error", err)
+	}
+}
+
+// 记录最近的消息, 撤回时使用
+func rememberMessage(msg *openwechat.Message, groupName, sender, content string, media []string, notified bool) {
+	conversation := groupName
+	if conversation == "" {
+		conversation = sender
+	}
+	recallCache.Add(recall.Entry{
+		MsgId:        msg.MsgId,
+		Conversation: conversation,
+		Sender:       sender,
+		Content:      content,
+		Media:        media,
+		Time:         time.Unix(msg.CreateTime, 0),
+		Notified:     notified,
+	})
+}
+
+// 查找被撤回的原始消息, 先查缓存, 再查归档
+func lookupRecalled(msgId string) (recall.Entry, bool) {
+	if entry, ok := recallCache.Get(msgId); ok {
+		return entry, true
+	}
+	if messageArchive == nil {
+		return recall.Entry{}, false
+	}
+	record, ok := messageArchive.Get(msgId)
+	if !ok {
+		return recall.Entry{}, false
+	}
+	return recall.Entry{
+		MsgId:        record.MsgId,
+		Conversation: record.Conversation,
+		Sender:       record.Sender,
+		Content:      record.Content,
+		Media:        record.Media,
+		Time:         record.CreateTime,
+		Notified:     record.Notified,
+	}, true
+}
+
+// 供 messageFormatter 在撤回通知中引用原消息
+func recalledContent(msgId string) (string, bool) {
+	entry, ok := lookupRecalled(msgId)
+	if !ok {
+		return "", false
+	}
+	return entry.Sender + ": " + entry.Content, true
+}
+
+// 处理撤回消息: 在归档中标记原消息, 原消息发送过通知时再发送一条撤回通知
+func handleRecall(msg *openwechat.Message) {
+	revoke, err := msg.RevokeMsg()
+	if err != nil {
+		recallLog.Error("解析撤回消息失败", "error", err)
+		return
+	}
+	for _, id := range recall.OriginalIDs(revoke) {
+		entry, ok := lookupRecalled(id)
+		if !ok {
+			continue
+		}
+		if messageArchive != nil {
+			if err := messageArchive.Update(id, func(record *archive.Record) { record.Recalled = true }); err != nil {
+				recallLog.Error("标记撤回消息失败", "msg_id", id, "error", err)
+			}
+		}
+		content, _ := messageFormatter.Format(msg)
+		recallCache.Remove(id)
+		recallLog.Info("消息已撤回", "sender", entry.Sender, logging.Content("content", content))
+		if !entry.Notified {
+			return
+		}
+		// 和普通消息一样, 屏蔽的群和免打扰时间内不发送撤回通知
+		if msg.IsSendByGroup() && isGroupMuted(msg.FromUserName, entry.Conversation) {
+			recallLog.Info("群已屏蔽, 不发送撤回通知", "group", entry.Conversation)
+			return
+		}
+		notification := content
+		if entry.Conversation != entry.Sender {
+			notification = "[" + entry.Conversation + "] " + notification
+		}
+		for _, hash := range entry.Media {
+			notification += "\n" + mediaLink(hash)
+		}
+		notify, notification := applyNotifyPolicy(msg, entry.Conversation, entry.Sender, notification)
+		if !notify {
+			return
+		}
+		sendNotification(entry.Sender, notification)
+		return
+	}
+	recallLog.Info("未找到被撤回的原消息", logging.Content("content", revoke.RevokeMsg.ReplaceMsg))
+}
@@ -0,0 +1,105 @@
+package stt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os/exec"
+)
+
+// Format 音频格式
+type Format string
+
+const (
+	FormatWAV     Format = "wav"
+	FormatAMR     Format = "amr"
+	FormatSILK    Format = "silk"
+	FormatMP3     Format = "mp3"
+	FormatUnknown Format = "unknown"
+)
+
+// Sniff 根据文件头判断音频格式
+func Sniff(data []byte) Format {
+	switch {
+	case len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WAVE")):
+		return FormatWAV
+	case bytes.HasPrefix(data, []byte("#!AMR")):
+		return FormatAMR
+	// 微信的 SILK 文件在标准文件头前多了一个 0x02
+	case bytes.HasPrefix(data, []byte("#!SILK_V3")), bytes.HasPrefix(data, []byte("\x02#!SILK_V3")):
+		return FormatSILK
+	case bytes.HasPrefix(data, []byte("ID3")):
+		return FormatMP3
+	case len(data) >= 2 && data[0] == 0xFF && data[1]&0xE0 == 0xE0:
+		return FormatMP3
+	}
+	return FormatUnknown
+}
+
+// WAV 为 16 位小端序的 PCM 数据添加 WAV 文件头
+func WAV(pcm []byte, sampleRate, channels int) []byte {
+	const bitsPerSample = 16
+	blockAlign := channels * bitsPerSample / 8
+	buf := bytes.NewBuffer(make([]byte, 0, 44+len(pcm)))
+	buf.WriteString("RIFF")
+	_ = binary.Write(buf, binary.LittleEndian, uint32(36+len(pcm)))
+	buf.WriteString("WAVEfmt ")
+	_ = binary.Write(buf, binary.LittleEndian, uint32(16))
+	_ = binary.Write(buf, binary.LittleEndian, uint16(1)) // PCM
+	_ = binary.Write(buf, binary.LittleEndian, uint16(channels))
+	_ = binary.Write(buf, binary.LittleEndian, uint32(sampleRate))
+	_ = binary.Write(buf, binary.LittleEndian, uint32(sampleRate*blockAlign))
+	_ = binary.Write(buf, binary.LittleEndian, uint16(blockAlign))
+	_ = binary.Write(buf, binary.LittleEndian, uint16(bitsPerSample))
+	buf.WriteString("data")
+	_ = binary.Write(buf, binary.LittleEndian, uint32(len(pcm)))
+	buf.Write(pcm)
+	return buf.Bytes()
+}
+
+// Converter 将其他格式的音频转换为 WAV
+type Converter interface {
+	Convert(ctx context.Context, format Format, audio []byte) ([]byte, error)
+}
+
+// ConverterFunc 函数形式的 Converter
+type ConverterFunc func(ctx context.Context, format Format, audio []byte) ([]byte, error)
+
+// Convert 实现 Converter 接口
+func (f ConverterFunc) Convert(ctx context.Context, format Format, audio []byte) ([]byte, error) {
+	return f(ctx, format, audio)
+}
+
+// CommandConverter 调用外部命令转换音频, 音频从标准输入写入, 结果从标准输出读取
+//
+// AMR 与 SILK 没有可用的纯 Go 解码器, 通常配置为 ffmpeg 或 silk-v3-decoder:
+//
+//	ffmpeg -i pipe:0 -ar 16000 -ac 1 -f wav pipe:1
+type CommandConverter struct {
+	Command []string
+	// SampleRate 不为 0 时表示命令输出单声道 16 位 PCM, 由 WAV 添加文件头
+	SampleRate int
+}
+
+// Convert 实现 Converter 接口
+func (c *CommandConverter) Convert(ctx context.Context, _ Format, audio []byte) ([]byte, error) {
+	if len(c.Command) == 0 {
+		return nil, ErrUnsupportedFormat
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Command[0], c.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(audio)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", c.Command[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if c.SampleRate > 0 {
+		return WAV(stdout.Bytes(), c.SampleRate, 1), nil
+	}
+	if Sniff(stdout.Bytes()) != FormatWAV {
+		return nil, fmt.Errorf("%s: 输出不是 WAV 格式", c.Command[0])
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,78 @@
+package stt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// MaxAudioSize 语音消息的最大大小, 微信语音最长 60 秒
+const MaxAudioSize = 16 << 20
+
+// ErrUnsupportedFormat 音频格式无法转换为 WAV
+var ErrUnsupportedFormat = errors.New("stt: unsupported audio format")
+
+// Transcriber 语音识别后端, 输入为 WAV 格式的音频
+type Transcriber interface {
+	Transcribe(ctx context.Context, wav []byte) (string, error)
+}
+
+// Service 将语音消息转换为 WAV 后交给 Transcriber 识别
+type Service struct {
+	Transcriber Transcriber
+	// Converter 将 AMR、SILK、MP3 等格式转换为 WAV, 为 nil 时只支持 WAV
+	Converter Converter
+}
+
+// Transcribe 读取音频并返回识别出的文字
+func (s *Service) Transcribe(ctx context.Context, audio io.Reader) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(audio, MaxAudioSize+1))
+	if err != nil {
+		return "", fmt.Errorf("读取音频失败: %w", err)
+	}
+	if len(data) > MaxAudioSize {
+		return "", fmt.Errorf("音频超过 %d 字节", MaxAudioSize)
+	}
+	format := Sniff(data)
+	if format != FormatWAV {
+		if s.Converter == nil {
+			return "", fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+		}
+		if data, err = s.Converter.Convert(ctx, format, data); err != nil {
+			return "", fmt.Errorf("转换 %s 音频失败: %w", format, err)
+		}
+	}
+	text, err := s.Transcriber.Transcribe(ctx, data)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(text), nil
+}
+
+// Stub 用于测试的 Transcriber, 返回固定的文字并记录收到的音频
+type Stub struct {
+	Text string
+	Err  error
+
+	mu       sync.Mutex
+	received [][]byte
+}
+
+// Transcribe 实现 Transcriber 接口
+func (s *Stub) Transcribe(_ context.Context, wav []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received = append(s.received, bytes.Clone(wav))
+	return s.Text, s.Err
+}
+
+// Received 返回收到的所有音频
+func (s *Stub) Received() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.received...)
+}
@@ -0,0 +1,122 @@
+package stt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSniff(t *testing.T) {
+	cases := []struct {
+		data []byte
+		want Format
+	}{
+		{WAV(make([]byte, 4), 16000, 1), FormatWAV},
+		{[]byte("#!AMR\n\x3c"), FormatAMR},
+		{[]byte("\x02#!SILK_V3\x0c"), FormatSILK},
+		{[]byte("#!SILK_V3"), FormatSILK},
+		{[]byte("ID3\x03\x00"), FormatMP3},
+		{[]byte{0xFF, 0xFB, 0x90, 0x64}, FormatMP3},
+		{[]byte("hello"), FormatUnknown},
+		{nil, FormatUnknown},
+	}
+	for _, c := range cases {
+		if got := Sniff(c.data); got != c.want {
+			t.Errorf("Sniff(%q) = %s, want %s", c.data, got, c.want)
+		}
+	}
+}
+
+func TestWAVHeader(t *testing.T) {
+	pcm := []byte{1, 2, 3, 4, 5, 6}
+	wav := WAV(pcm, 24000, 1)
+	if len(wav) != 44+len(pcm) {
+		t.Fatalf("len = %d", len(wav))
+	}
+	if size := binary.LittleEndian.Uint32(wav[4:8]); size != uint32(36+len(pcm)) {
+		t.Errorf("riff size = %d", size)
+	}
+	if rate := binary.LittleEndian.Uint32(wav[24:28]); rate != 24000 {
+		t.Errorf("sample rate = %d", rate)
+	}
+	if byteRate := binary.LittleEndian.Uint32(wav[28:32]); byteRate != 48000 {
+		t.Errorf("byte rate = %d", byteRate)
+	}
+	if !bytes.Equal(wav[44:], pcm) {
+		t.Error("pcm data mismatch")
+	}
+}
+
+func TestServiceConvert(t *testing.T) {
+	stub := &Stub{Text: " 今晚八点开会 \n"}
+	var converted Format
+	s := &Service{
+		Transcriber: stub,
+		Converter: ConverterFunc(func(_ context.Context, format Format, audio []byte) ([]byte, error) {
+			converted = format
+			return WAV(audio, 16000, 1), nil
+		}),
+	}
+	text, err := s.Transcribe(context.Background(), bytes.NewReader([]byte("#!AMR\nabc")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "今晚八点开会" {
+		t.Errorf("text = %q", text)
+	}
+	if converted != FormatAMR {
+		t.Errorf("converted = %s", converted)
+	}
+	if received := stub.Received(); len(received) != 1 || Sniff(received[0]) != FormatWAV {
+		t.Errorf("transcriber should receive wav")
+	}
+}
+
+func TestServiceUnsupported(t *testing.T) {
+	s := &Service{Transcriber: &Stub{}}
+	_, err := s.Transcribe(context.Background(), bytes.NewReader([]byte("\x02#!SILK_V3")))
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestWhisper(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/inference" {
+			http.NotFound(w, r)
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		data, _ := io.ReadAll(file)
+		if Sniff(data) != FormatWAV || r.FormValue("language") != "zh" || r.FormValue("response_format") != "json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"text":" 收到，马上到\n"}`))
+	}))
+	defer server.Close()
+
+	w := &Whisper{URL: server.URL + "/", Language: "zh"}
+	text, err := w.Transcribe(context.Background(), WAV(make([]byte, 320), 16000, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != " 收到，马上到\n" {
+		t.Errorf("text = %q", text)
+	}
+
+	w.URL = server.URL + "/missing"
+	if _, err = w.Transcribe(context.Background(), WAV(nil, 16000, 1)); err == nil {
+		t.Error("expected error for non 200 response")
+	}
+}
@@ -0,0 +1,85 @@
+package stt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// Whisper 调用兼容 whisper.cpp server 的 /inference 接口
+type Whisper struct {
+	// URL 服务地址, 例如 http://127.0.0.1:8080
+	URL string
+	// Language 识别的语言, 为空时由服务端自动检测
+	Language string
+	Client   *http.Client
+}
+
+type whisperResponse struct {
+	Text  string `json:"text"`
+	Error string `json:"error"`
+}
+
+// Transcribe 实现 Transcriber 接口
+func (w *Whisper) Transcribe(ctx context.Context, wav []byte) (string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "voice.wav")
+	if err != nil {
+		return "", err
+	}
+	if _, err = part.Write(wav); err != nil {
+		return "", err
+	}
+	fields := map[string]string{
+		"response_format": "json",
+		"temperature":     "0.0",
+	}
+	if w.Language != "" {
+		fields["language"] = w.Language
+	}
+	for key, value := range fields {
+		if err = writer.WriteField(key, value); err != nil {
+			return "", err
+		}
+	}
+	if err = writer.Close(); err != nil {
+		return "", err
+	}
+
+	url := strings.TrimSuffix(w.URL, "/") + "/inference"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("请求语音识别服务失败: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("语音识别服务返回 %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	var result whisperResponse
+	if err = json.Unmarshal(data, &result); err != nil {
+		return "", fmt.Errorf("解析语音识别结果失败: %w", err)
+	}
+	if result.Error != "" {
+		return "", fmt.Errorf("语音识别失败: %s", result.Error)
+	}
+	return result.Text, nil
+}
@@ -0,0 +1,72 @@
+package subscription
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/mozillazg/go-pinyin"
+)
+
+var pinyinArgs = pinyin.NewArgs()
+
+// pinyinTokens 将文本转换为拼音音节, 汉字转换为不带声调的拼音, 字母和数字按单词保留
+func pinyinTokens(text string) []string {
+	var tokens []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flush()
+			if readings := pinyin.SinglePinyin(r, pinyinArgs); len(readings) > 0 {
+				tokens = append(tokens, readings[0])
+			}
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word.WriteRune(unicode.ToLower(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
+
+// hasHan 判断文本中是否包含汉字
+func hasHan(text string) bool {
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// pinyinMatcher 按拼音匹配, 可以匹配同音字和错别字
+type pinyinMatcher struct {
+	term string
+	// syllables 关键词包含汉字时按音节边界匹配, 否则与拼音连写匹配
+	syllables string
+	letters   string
+}
+
+func newPinyinMatcher(term string) pinyinMatcher {
+	m := pinyinMatcher{term: term}
+	if hasHan(term) {
+		m.syllables = " " + strings.Join(pinyinTokens(term), " ") + " "
+	} else {
+		m.letters = strings.ToLower(strings.Join(strings.Fields(term), ""))
+	}
+	return m
+}
+
+func (m pinyinMatcher) match(tokens []string) bool {
+	if m.syllables != "" {
+		return strings.Contains(" "+strings.Join(tokens, " ")+" ", m.syllables)
+	}
+	return m.letters != "" && strings.Contains(strings.Join(tokens, ""), m.letters)
+}
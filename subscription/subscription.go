@@ -0,0 +1,293 @@
+package subscription
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"bestrui/wechatpush/logging"
+)
+
+var logger = logging.For("subscriptions")
+
+// Subscription 关键词订阅, 命中时通知订阅自己的收件人
+type Subscription struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Keywords 关键词, 不区分大小写
+	Keywords []string `json:"keywords,omitempty"`
+	// Regex 正则表达式
+	Regex string `json:"regex,omitempty"`
+	// Pinyin 按拼音匹配的关键词, 可以是汉字或者拼音
+	Pinyin []string `json:"pinyin,omitempty"`
+	// Conversations 只匹配这些会话, 为空时匹配所有会话
+	Conversations []string `json:"conversations,omitempty"`
+	// Recipients 收件人邮箱
+	Recipients []string  `json:"recipients"`
+	Hits       int64     `json:"hits"`
+	LastHit    time.Time `json:"lastHit,omitempty"`
+}
+
+// Hit 一次命中
+type Hit struct {
+	Subscription Subscription
+	// Term 命中的关键词、正则表达式或拼音
+	Term string
+}
+
+type compiled struct {
+	Subscription
+	keywords []string
+	regex    *regexp.Regexp
+	pinyin   []pinyinMatcher
+}
+
+func compile(sub Subscription) (*compiled, error) {
+	if strings.TrimSpace(sub.Name) == "" {
+		return nil, errors.New("订阅名称不能为空")
+	}
+	if len(sub.Keywords) == 0 && sub.Regex == "" && len(sub.Pinyin) == 0 {
+		return nil, errors.New("关键词、正则表达式和拼音至少需要一个")
+	}
+	if len(sub.Recipients) == 0 {
+		return nil, errors.New("收件人不能为空")
+	}
+	for _, recipient := range sub.Recipients {
+		if _, err := mail.ParseAddress(recipient); err != nil {
+			return nil, fmt.Errorf("收件人 %q 无效", recipient)
+		}
+	}
+	c := &compiled{Subscription: sub}
+	for _, keyword := range sub.Keywords {
+		if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" {
+			c.keywords = append(c.keywords, keyword)
+		}
+	}
+	if sub.Regex != "" {
+		re, err := regexp.Compile(sub.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("正则表达式无效: %v", err)
+		}
+		c.regex = re
+	}
+	for _, term := range sub.Pinyin {
+		if term = strings.TrimSpace(term); term != "" {
+			c.pinyin = append(c.pinyin, newPinyinMatcher(term))
+		}
+	}
+	return c, nil
+}
+
+// match 返回命中的关键词
+func (c *compiled) match(conversation string, texts []string) (string, bool) {
+	if len(c.Conversations) > 0 {
+		found := false
+		for _, name := range c.Conversations {
+			if name == conversation {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", false
+		}
+	}
+	for _, text := range texts {
+		lower := strings.ToLower(text)
+		for _, keyword := range c.keywords {
+			if strings.Contains(lower, keyword) {
+				return keyword, true
+			}
+		}
+		if c.regex != nil {
+			if found := c.regex.FindString(text); found != "" {
+				return found, true
+			}
+		}
+		if len(c.pinyin) > 0 {
+			tokens := pinyinTokens(text)
+			for _, m := range c.pinyin {
+				if m.match(tokens) {
+					return m.term, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// Store 保存在 JSON 文件中的订阅列表
+// 命中次数只在内存中更新, 由 Flush 或者修改订阅时写入文件
+type Store struct {
+	path  string
+	mu    sync.Mutex
+	subs  []*compiled
+	dirty bool // 有没有写入文件的命中次数
+}
+
+// Open 打开订阅文件, 文件不存在时创建空的订阅列表
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var subs []Subscription
+	if err = json.Unmarshal(data, &subs); err != nil {
+		return nil, fmt.Errorf("解析订阅文件 %s 失败: %w", path, err)
+	}
+	for _, sub := range subs {
+		c, err := compile(sub)
+		if err != nil {
+			return nil, fmt.Errorf("订阅 %s: %w", sub.Name, err)
+		}
+		s.subs = append(s.subs, c)
+	}
+	return s, nil
+}
+
+// List 返回所有订阅
+func (s *Store) List() []Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subs := make([]Subscription, 0, len(s.subs))
+	for _, c := range s.subs {
+		subs = append(subs, c.Subscription)
+	}
+	return subs
+}
+
+// Put 添加或更新订阅, ID 为空时添加, 更新时保留命中次数
+func (s *Store) Put(sub Subscription) (Subscription, error) {
+	c, err := compile(sub)
+	if err != nil {
+		return Subscription{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.ID == "" {
+		c.ID = newID()
+		c.Hits, c.LastHit = 0, time.Time{}
+		s.subs = append(s.subs, c)
+		return c.Subscription, s.save()
+	}
+	for i, old := range s.subs {
+		if old.ID == c.ID {
+			c.Hits, c.LastHit = old.Hits, old.LastHit
+			s.subs[i] = c
+			return c.Subscription, s.save()
+		}
+	}
+	return Subscription{}, fmt.Errorf("订阅 %s 不存在", c.ID)
+}
+
+// Delete 删除订阅, 返回订阅是否存在
+func (s *Store) Delete(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, c := range s.subs {
+		if c.ID == id {
+			s.subs = append(s.subs[:i], s.subs[i+1:]...)
+			return true, s.save()
+		}
+	}
+	return false, nil
+}
+
+// Match 匹配消息的文本, 命中的订阅增加命中次数, 命中次数不会立即写入文件
+func (s *Store) Match(conversation string, at time.Time, texts ...string) []Hit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var hits []Hit
+	for _, c := range s.subs {
+		term, ok := c.match(conversation, texts)
+		if !ok {
+			continue
+		}
+		c.Hits++
+		c.LastHit = at
+		s.dirty = true
+		hits = append(hits, Hit{Subscription: c.Subscription, Term: term})
+	}
+	return hits
+}
+
+// Flush 将命中次数写入文件, 没有新的命中时不写入
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	return s.save()
+}
+
+// RunFlusher 定期将命中次数写入文件, ctx 结束时最后写入一次
+func (s *Store) RunFlusher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.Flush(); err != nil {
+				logger.Error("保存订阅命中次数失败", "error", err)
+			}
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				logger.Error("保存订阅命中次数失败", "error", err)
+			}
+		}
+	}
+}
+
+// save 保存订阅文件, 调用时需要持有锁
+func (s *Store) save() error {
+	subs := make([]Subscription, 0, len(s.subs))
+	for _, c := range s.subs {
+		subs = append(subs, c.Subscription)
+	}
+	data, err := json.MarshalIndent(subs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".subscriptions-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
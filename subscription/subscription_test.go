@@ -0,0 +1,113 @@
+package subscription
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		name string
+		sub  Subscription
+		text string
+		term string
+	}{
+		{"keyword ignores case", Subscription{Keywords: []string{"Outage"}}, "db OUTAGE in prod", "outage"},
+		{"regex", Subscription{Regex: `P[0-9]\b`}, "这是一个P1事故", "P1"},
+		{"pinyin homophone", Subscription{Pinyin: []string{"故障"}}, "服务器顾章了", "故障"},
+		{"pinyin letters", Subscription{Pinyin: []string{"guzhang"}}, "又故障了", "guzhang"},
+		{"pinyin syllable boundary", Subscription{Pinyin: []string{"安"}}, "看一看", ""},
+		{"conversation filter", Subscription{Keywords: []string{"上线"}, Conversations: []string{"项目群"}}, "今晚上线", ""},
+		{"no match", Subscription{Keywords: []string{"outage"}}, "一切正常", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.sub.Name = c.name
+			c.sub.Recipients = []string{"ops@example.com"}
+			compiled, err := compile(c.sub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			term, ok := compiled.match("其他群", []string{"", c.text})
+			if ok != (c.term != "") || term != c.term {
+				t.Errorf("got %q, %v; want %q", term, ok, c.term)
+			}
+		})
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "subscriptions.json")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Put(Subscription{Name: "invalid", Keywords: []string{"x"}, Recipients: []string{"not an address"}}); err == nil {
+		t.Error("invalid recipient should be rejected")
+	}
+	sub, err := store.Put(Subscription{Name: "产品", Keywords: []string{"wechatpush"}, Recipients: []string{"张三 <zhangsan@example.com>"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2023, 9, 18, 10, 0, 0, 0, time.UTC)
+	hits := store.Match("项目群", now, "新版 WeChatPush 发布了")
+	if len(hits) != 1 || hits[0].Subscription.Hits != 1 {
+		t.Fatalf("hits = %+v", hits)
+	}
+
+	// 更新订阅时保留命中次数
+	sub.Keywords = append(sub.Keywords, "推送")
+	if sub, err = store.Put(sub); err != nil || sub.Hits != 1 {
+		t.Fatalf("put = %+v, %v", sub, err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs := reopened.List()
+	if len(subs) != 1 || subs[0].Hits != 1 || !subs[0].LastHit.Equal(now) || len(subs[0].Keywords) != 2 {
+		t.Fatalf("reopened = %+v", subs)
+	}
+	if ok, err := reopened.Delete(sub.ID); !ok || err != nil {
+		t.Fatalf("delete = %v, %v", ok, err)
+	}
+	if len(reopened.List()) != 0 {
+		t.Error("subscription should be deleted")
+	}
+}
+
+func TestMatchFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subscriptions.json")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Put(Subscription{Name: "告警", Keywords: []string{"故障"}, Recipients: []string{"ops@example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2023, 9, 18, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if hits := store.Match("运维群", now, "数据库故障"); len(hits) != 1 {
+			t.Fatalf("hits = %+v", hits)
+		}
+	}
+	hits := func() int64 {
+		reopened, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reopened.List()[0].Hits
+	}
+	// 命中时不写文件
+	if got := hits(); got != 0 {
+		t.Errorf("hits saved before flush: %d", got)
+	}
+	if err = store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := hits(); got != 3 {
+		t.Errorf("hits after flush = %d, want 3", got)
+	}
+}
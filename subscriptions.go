@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bestrui/wechatpush/logging"
+	"bestrui/wechatpush/mail"
+	"bestrui/wechatpush/subscription"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"bestrui/wechatpush/openwechat"
+)
+
+var subscriptionLog = logging.For("subscriptions")
+
+var subscriptions *subscription.Store // 关键词订阅, 打开失败时为 nil
+
+// 订阅文件, 默认保存在归档目录旁边
+func subscriptionsFileFromEnv() string {
+	if path := os.Getenv("SUBSCRIPTIONS_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join(filepath.Dir(archiveDirFromEnv()), "subscriptions.json")
+}
+
+// 打开关键词订阅, 命中次数每分钟保存一次, ctx 结束时停止
+func initSubscriptions(ctx context.Context) {
+	path := subscriptionsFileFromEnv()
+	store, err := subscription.Open(path)
+	if err != nil {
+		subscriptionLog.Error("打开订阅文件失败, 关键词订阅将不可用", "error", err)
+		return
+	}
+	subscriptions = store
+	go store.RunFlusher(ctx, time.Minute)
+	subscriptionLog.Info("已加载关键词订阅", "subscriptions", len(store.List()))
+}
+
+// 退出前保存订阅的命中次数
+func flushSubscriptions() {
+	if subscriptions == nil {
+		return
+	}
+	if err := subscriptions.Flush(); err != nil {
+		subscriptionLog.Error("保存订阅命中次数失败", "error", err)
+	}
+}
+
+// 需要匹配订阅的文本: 文字消息的内容、文章标题和摘要、文件名
+func subscriptionTexts(msg *openwechat.Message) []string {
+	var texts []string
+	if msg.IsText() {
+		texts = append(texts, msg.Content)
+	}
+	if msg.IsMedia() {
+		if data, err := msg.MediaData(); err == nil {
+			texts = append(texts, data.AppMsg.Title, data.AppMsg.Des)
+		}
+		if msg.FileName != "" {
+			texts = append(texts, msg.FileName)
+		}
+	}
+	return texts
+}
+
+// 匹配关键词订阅, 命中时通知订阅的收件人
+func notifySubscribers(msg *openwechat.Message, conversation, sender, notification string) {
+	if subscriptions == nil {
+		return
+	}
+	texts := subscriptionTexts(msg)
+	if len(texts) == 0 {
+		return
+	}
+	hits := subscriptions.Match(conversation, time.Unix(msg.CreateTime, 0), texts...)
+	for _, hit := range hits {
+		title := "[订阅:" + hit.Subscription.Name + "] " + sender
+		content := "[" + conversation + "] " + notification + "\n命中: " + hit.Term
+		for _, recipient := range hit.Subscription.Recipients {
+			recipient := recipient
+			if err := retrySend(func() error { return mail.SendEmailTo(recipient, title, content) }); err != nil {
+				subscriptionLog.Error("发送订阅通知失败", "recipient", recipient, "error", err)
+			}
+		}
+	}
+}
+
+// 管理关键词订阅: GET 列出订阅, POST 添加或更新订阅, DELETE 删除订阅
+func serveSubscriptions(w http.ResponseWriter, r *http.Request) {
+	if !checkPagePassword(w, r) {
+		return
+	}
+	if subscriptions == nil {
+		http.Error(w, "关键词订阅未启用", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(subscriptions.List())
+	case http.MethodPost:
+		var sub subscription.Subscription
+		if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+			http.Error(w, "无效的请求数据", http.StatusBadRequest)
+			return
+		}
+		saved, err := subscriptions.Put(sub)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(saved)
+	case http.MethodDelete:
+		ok, err := subscriptions.Delete(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "保存订阅失败", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	default:
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bestrui/wechatpush/logging"
+	"bestrui/wechatpush/stt"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"bestrui/wechatpush/openwechat"
+)
+
+var transcribeLog = logging.For("stt")
+
+var voiceTranscriber *stt.Service // 语音识别服务, 未配置 STT_URL 时为 nil
+
+// 识别单条语音的超时时间
+const transcribeTimeout = 60 * time.Second
+
+// 默认的语音转换命令, 将标准输入的音频转换为 16kHz 单声道 WAV
+var defaultConverter = []string{"ffmpeg", "-i", "pipe:0", "-ar", "16000", "-ac", "1", "-f", "wav", "pipe:1"}
+
+// 初始化语音识别服务
+func initTranscriber() {
+	url := os.Getenv("STT_URL")
+	if url == "" {
+		return
+	}
+	language := os.Getenv("STT_LANGUAGE")
+	if language == "" {
+		language = "zh"
+	}
+	voiceTranscriber = &stt.Service{
+		Transcriber: &stt.Whisper{URL: url, Language: language, Client: &http.Client{Timeout: transcribeTimeout}},
+	}
+	// 网页版微信的语音为 MP3 格式, 需要外部命令转换为 WAV, 未配置时使用 ffmpeg
+	command := strings.Fields(os.Getenv("STT_CONVERTER"))
+	if len(command) == 0 {
+		command = defaultConverter
+	}
+	if _, err := exec.LookPath(command[0]); err != nil {
+		transcribeLog.Error("找不到语音转换命令, 请安装 ffmpeg 或者配置 STT_CONVERTER", "command", command[0], "error", err)
+		os.Exit(1)
+	}
+	voiceTranscriber.Converter = &stt.CommandConverter{Command: command}
+	transcribeLog.Info("已启用语音识别服务", "url", url, "converter", command[0])
+}
+
+// 识别语音消息, 优先从媒体文件存储中读取已下载的音频, 失败时返回空字符串
+func transcribeVoice(msg *openwechat.Message, hash string) string {
+	if voiceTranscriber == nil || !msg.IsVoice() {
+		return ""
+	}
+	// 从消息的 context 开始, bot 退出时停止识别
+	parent := msg.Context()
+	ctx, cancel := context.WithTimeout(parent, transcribeTimeout)
+	defer cancel()
+
+	var audio io.ReadCloser
+	if mediaStore != nil && hash != "" {
+		if file, err := mediaStore.Open(hash); err == nil {
+			audio = file
+		}
+	}
+	if audio == nil {
+		msg.WithContext(ctx)
+		resp, err := msg.GetVoice()
+		msg.WithContext(parent)
+		if err == nil && resp.StatusCode >= http.StatusBadRequest {
+			_ = resp.Body.Close()
+			err = errors.New(resp.Status)
+		}
+		if err != nil {
+			transcribeLog.Error("下载语音失败", "msg_id", msg.MsgId, "error", err)
+			return ""
+		}
+		audio = resp.Body
+	}
+	defer func() { _ = audio.Close() }()
+
+	text, err := voiceTranscriber.Transcribe(ctx, audio)
+	if err != nil {
+		transcribeLog.Error("语音识别失败", "msg_id", msg.MsgId, "error", err)
+		return ""
+	}
+	return text
+}